@@ -0,0 +1,439 @@
+package adatft
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mit den Funktionen in dieser Datei koennen Kalibrierungen mit tslib
+// (/etc/pointercal) und X11 (xinput_calibrator, evdev, libinput)
+// ausgetauscht werden. Alle Formate beziehen sich auf die native
+// Ausrichtung des Panels (Rotate000) und auf den vollen Wertebereich des
+// Touchscreens (0 bis 4095 fuer RawX und RawY); eine allfaellige Rotation
+// muss auf dem Desktop separat eingestellt werden.
+
+var (
+	ErrCalibFormat = errors.New("calibration: invalid file format")
+)
+
+const (
+	// Nenner der Koeffizienten im pointercal-File von tslib.
+	pointercalScale = 65536
+	// Name der evdev-Optionen in einem xorg.conf-Snippet.
+	xorgCalibration = "Calibration"
+	xorgSwapAxes    = "SwapAxes"
+	xorgMatrix      = "TransformationMatrix"
+)
+
+// 3x3 Matrix fuer die Darstellung der Abbildung in homogenen Koordinaten.
+type mat3 [3][3]float64
+
+func (a mat3) mul(b mat3) (c mat3) {
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				c[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return c
+}
+
+func (a mat3) inverse() (mat3, error) {
+	var b mat3
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	if math.Abs(det) < 1e-15 {
+		return b, ErrCalibDegenerate
+	}
+	for i := range 3 {
+		for j := range 3 {
+			i1, i2 := (j+1)%3, (j+2)%3
+			j1, j2 := (i+1)%3, (i+2)%3
+			b[i][j] = (a[i1][j1]*a[i2][j2] - a[i1][j2]*a[i2][j1]) / det
+		}
+	}
+	return b, nil
+}
+
+func (a mat3) apply(x, y float64) (float64, float64) {
+	w := a[2][0]*x + a[2][1]*y + a[2][2]
+	return (a[0][0]*x + a[0][1]*y + a[0][2]) / w,
+		(a[1][0]*x + a[1][1]*y + a[1][2]) / w
+}
+
+// Liefert die Abbildung von Rohdaten auf Bildschirmkoordinaten (Rotate000)
+// als Matrix. Ist affine gesetzt oder kann das Modell nicht als Matrix
+// dargestellt werden (CalibBilinear), wird an Stelle der aktuellen Abbildung
+// eine affine (bzw. perspektivische) Naeherung aus den Referenzpunkten
+// berechnet.
+func (d *DistortedPlane) matrix(affine bool) (mat3, error) {
+	model, c := d.Model, d.Coeff
+	if len(c) != model.numCoeff() {
+		return mat3{}, ErrCalibModel
+	}
+	if model == CalibBilinear || (affine && model == CalibPerspective) {
+		model = CalibAffine
+		if !affine && len(d.RawPosList) >= CalibPerspective.minPoints() {
+			model = CalibPerspective
+		}
+		var err error
+		if c, err = fitModel(model, d.RawPosList, d.PosList); err != nil {
+			return mat3{}, err
+		}
+	}
+	m := mat3{{c[1], c[2], c[0]}, {c[4], c[5], c[3]}, {0, 0, 1}}
+	if model == CalibPerspective {
+		m[2][0], m[2][1] = c[6], c[7]
+	}
+	return m, nil
+}
+
+// Uebernimmt die Abbildung aus der Matrix m. Je nach Form der Matrix wird
+// das lineare, affine oder perspektivische Modell verwendet. Da importierte
+// Kalibrierungen keine Referenzpunkte enthalten, werden diese aus der
+// Abbildung berechnet (siehe RefPoints(5)), damit die Daten mit
+// WriteConfigFile gespeichert werden koennen.
+func (d *DistortedPlane) setMatrix(m mat3) error {
+	const eps = 1e-12
+
+	if math.Abs(m[2][2]) < eps {
+		return ErrCalibDegenerate
+	}
+	for i := range 3 {
+		for j := range 3 {
+			m[i][j] /= m[2][2]
+		}
+	}
+	inv, err := m.inverse()
+	if err != nil {
+		return err
+	}
+
+	plane := *d
+	plane.Coeff = []float64{m[0][2], m[0][0], m[0][1],
+		m[1][2], m[1][0], m[1][1]}
+	switch {
+	case math.Abs(m[2][0]) > eps || math.Abs(m[2][1]) > eps:
+		plane.Model = CalibPerspective
+		plane.Coeff = append(plane.Coeff, m[2][0], m[2][1])
+	case m[0][1] == 0.0 && m[1][0] == 0.0:
+		plane.Model = CalibLinear
+	default:
+		plane.Model = CalibAffine
+	}
+	if plane.Width == 0 || plane.Height == 0 {
+		plane.Width, plane.Height = plane.estimateSize()
+	}
+
+	plane.RefPoints, _ = RefPoints(5)
+	plane.RawPosList = make([]TouchRawPos, len(plane.RefPoints))
+	plane.PosList = make([]TouchPos, len(plane.RefPoints))
+	rect := plane.nativeRect()
+	for i, id := range plane.RefPoints {
+		pos := refPointPos(id, rect, DefaultCalibOptions.Margin)
+		rx, ry := inv.apply(pos.X, pos.Y)
+		if math.IsNaN(rx) || math.IsNaN(ry) || rx < 0 || ry < 0 ||
+			rx > touchRawMax || ry > touchRawMax {
+			return fmt.Errorf("%w: %v outside the touch range",
+				ErrCalibDegenerate, id)
+		}
+		plane.RawPosList[i] = TouchRawPos{RawX: uint16(math.Round(rx)),
+			RawY: uint16(math.Round(ry))}
+		plane.PosList[i] = pos
+	}
+	*d = plane
+	return nil
+}
+
+// Liefert den Bildschirm in der Rotation Rotate000.
+func (d *DistortedPlane) nativeRect() image.Rectangle {
+	return image.Rect(0, 0, d.Width, d.Height)
+}
+
+// Liest die Kalibrierung aus einem pointercal-File von tslib. Das File
+// enthaelt die 7 Koeffizienten a0 bis a6 der Abbildung
+//
+//	x = (a2 + a0*rx + a1*ry) / a6, y = (a5 + a3*rx + a4*ry) / a6
+//
+// gefolgt von der Aufloesung des Bildschirms, welche als Groesse (Width,
+// Height) uebernommen wird.
+func (d *DistortedPlane) ReadPointercal(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 7 {
+		return fmt.Errorf("%w: pointercal needs 7 coefficients, got %d",
+			ErrCalibFormat, len(fields))
+	}
+	var a [10]int64
+	for i := range min(len(fields), len(a)) {
+		if a[i], err = strconv.ParseInt(fields[i], 10, 64); err != nil {
+			return fmt.Errorf("%w: %v", ErrCalibFormat, err)
+		}
+	}
+	if a[9] != 0 {
+		return fmt.Errorf("%w: tslib rotation %d not supported",
+			ErrCalibFormat, a[9])
+	}
+	if a[6] == 0 {
+		return ErrCalibDegenerate
+	}
+	plane := *d
+	if a[7] > 0 && a[8] > 0 {
+		plane.Width, plane.Height = int(a[7]), int(a[8])
+	}
+	s := float64(a[6])
+	m := mat3{
+		{float64(a[0]) / s, float64(a[1]) / s, float64(a[2]) / s},
+		{float64(a[3]) / s, float64(a[4]) / s, float64(a[5]) / s},
+		{0, 0, 1},
+	}
+	if err = plane.setMatrix(m); err != nil {
+		return err
+	}
+	*d = plane
+	return nil
+}
+
+// Liest die Kalibrierung aus dem angegebenen pointercal-File (siehe
+// ReadPointercal).
+func (d *DistortedPlane) ReadPointercalFile(fileName string) error {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return d.ReadPointercal(fh)
+}
+
+// Schreibt die Kalibrierung im Format des pointercal-Files von tslib. Da
+// tslib nur affine Abbildungen kennt, wird fuer die uebrigen Modelle eine
+// affine Naeherung verwendet.
+func (d *DistortedPlane) WritePointercal(w io.Writer) error {
+	m, err := d.matrix(true)
+	if err != nil {
+		return err
+	}
+	a := [7]int64{}
+	for i, v := range []float64{m[0][0], m[0][1], m[0][2],
+		m[1][0], m[1][1], m[1][2]} {
+		a[i] = int64(math.Round(v * pointercalScale))
+	}
+	a[6] = pointercalScale
+	_, err = fmt.Fprintf(w, "%d %d %d %d %d %d %d %d %d\n", a[0], a[1], a[2],
+		a[3], a[4], a[5], a[6], d.Width, d.Height)
+	return err
+}
+
+// Schreibt die Kalibrierung in das angegebene pointercal-File (siehe
+// WritePointercal).
+func (d *DistortedPlane) WritePointercalFile(fileName string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = d.WritePointercal(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Liefert die Abbildung als "Coordinate Transformation Matrix" fuer X11
+// (xinput set-prop bzw. Option "TransformationMatrix"). Die Matrix bildet
+// die auf [0,1] normierten Rohdaten auf normierte Bildschirmkoordinaten ab;
+// die Werte sind zeilenweise abgelegt. Bei einer perspektivischen Abbildung
+// ist die letzte Zeile nicht (0, 0, 1), was von libinput nicht unterstuetzt
+// wird.
+func (d *DistortedPlane) TransformationMatrix() ([9]float64, error) {
+	var res [9]float64
+
+	m, err := d.matrix(false)
+	if err != nil {
+		return res, err
+	}
+	screen := mat3{{1 / float64(d.Width), 0, 0},
+		{0, 1 / float64(d.Height), 0}, {0, 0, 1}}
+	device := mat3{{touchRawMax, 0, 0}, {0, touchRawMax, 0}, {0, 0, 1}}
+	m = screen.mul(m).mul(device)
+	for i := range 3 {
+		for j := range 3 {
+			res[3*i+j] = m[i][j] / m[2][2]
+		}
+	}
+	return res, nil
+}
+
+// Uebernimmt die Abbildung aus einer "Coordinate Transformation Matrix"
+// (siehe TransformationMatrix). Die Groesse des Bildschirms (Width,
+// Height) muss vorgaengig gesetzt sein.
+func (d *DistortedPlane) SetTransformationMatrix(ctm [9]float64) error {
+	plane := *d
+	if plane.Width == 0 || plane.Height == 0 {
+		plane.Width, plane.Height = plane.estimateSize()
+	}
+	m := mat3{{ctm[0], ctm[1], ctm[2]}, {ctm[3], ctm[4], ctm[5]},
+		{ctm[6], ctm[7], ctm[8]}}
+	screen := mat3{{float64(plane.Width), 0, 0},
+		{0, float64(plane.Height), 0}, {0, 0, 1}}
+	device := mat3{{1.0 / touchRawMax, 0, 0}, {0, 1.0 / touchRawMax, 0},
+		{0, 0, 1}}
+	if err := plane.setMatrix(screen.mul(m).mul(device)); err != nil {
+		return err
+	}
+	*d = plane
+	return nil
+}
+
+// Liefert die Kalibrierung im Format des evdev-Treibers von X11 (wie sie
+// auch xinput_calibrator ermittelt): die Rohdaten am linken, rechten,
+// oberen und unteren Bildschirmrand sowie die Angabe, ob die Achsen
+// vertauscht sind. Da evdev weder Scherung noch Rotation kennt, ist dies
+// nur eine Naeherung der Abbildung.
+func (d *DistortedPlane) EvdevCalibration() (minX, maxX, minY, maxY int,
+	swap bool, err error) {
+	m, err := d.matrix(true)
+	if err != nil {
+		return
+	}
+	swap = math.Abs(m[0][1]) > math.Abs(m[0][0])
+	// Bei vertauschten Achsen haengt X nur von RawY ab und Y nur von RawX.
+	xi, yi := 0, 1
+	if swap {
+		xi, yi = 1, 0
+	}
+	if m[0][xi] == 0.0 || m[1][yi] == 0.0 {
+		err = ErrCalibDegenerate
+		return
+	}
+	raw := func(row, col int, v float64) int {
+		return int(math.Round((v - m[row][2]) / m[row][col]))
+	}
+	minX, maxX = raw(0, xi, 0), raw(0, xi, float64(d.Width))
+	minY, maxY = raw(1, yi, 0), raw(1, yi, float64(d.Height))
+	return
+}
+
+// Uebernimmt die Kalibrierung im Format des evdev-Treibers (siehe
+// EvdevCalibration).
+func (d *DistortedPlane) SetEvdevCalibration(minX, maxX, minY, maxY int,
+	swap bool) error {
+	if minX == maxX || minY == maxY {
+		return ErrCalibDegenerate
+	}
+	plane := *d
+	if plane.Width == 0 || plane.Height == 0 {
+		plane.Width, plane.Height = plane.estimateSize()
+	}
+	sx := float64(plane.Width) / float64(maxX-minX)
+	sy := float64(plane.Height) / float64(maxY-minY)
+	m := mat3{{sx, 0, -sx * float64(minX)}, {0, sy, -sy * float64(minY)},
+		{0, 0, 1}}
+	if swap {
+		m[0][0], m[0][1] = m[0][1], m[0][0]
+		m[1][0], m[1][1] = m[1][1], m[1][0]
+	}
+	if err := plane.setMatrix(m); err != nil {
+		return err
+	}
+	*d = plane
+	return nil
+}
+
+// Schreibt die Kalibrierung als Konfigurations-Snippet fuer X11 (z.B.
+// /etc/X11/xorg.conf.d/99-calibration.conf), analog zur Ausgabe von
+// xinput_calibrator. Neben den Werten fuer evdev wird auch die
+// TransformationMatrix (fuer libinput) geschrieben. Mit product wird der
+// Name des Touchscreens angegeben (z.B. "stmpe-ts").
+func (d *DistortedPlane) WriteXorgConf(w io.Writer, product string) error {
+	minX, maxX, minY, maxY, swap, err := d.EvdevCalibration()
+	if err != nil {
+		return err
+	}
+	ctm, err := d.TransformationMatrix()
+	if err != nil {
+		return err
+	}
+	swapVal := 0
+	if swap {
+		swapVal = 1
+	}
+	ctmStr := make([]string, len(ctm))
+	for i, v := range ctm {
+		ctmStr[i] = strconv.FormatFloat(v, 'g', 8, 64)
+	}
+	_, err = fmt.Fprintf(w, "Section \"InputClass\"\n"+
+		"\tIdentifier \"calibration\"\n"+
+		"\tMatchProduct \"%s\"\n"+
+		"\tOption \"%s\" \"%d %d %d %d\"\n"+
+		"\tOption \"%s\" \"%d\"\n"+
+		"\tOption \"%s\" \"%s\"\n"+
+		"EndSection\n",
+		product, xorgCalibration, minX, maxX, minY, maxY,
+		xorgSwapAxes, swapVal, xorgMatrix, strings.Join(ctmStr, " "))
+	return err
+}
+
+// Liest die Kalibrierung aus einem Konfigurations-Snippet fuer X11 (siehe
+// WriteXorgConf). Ist die Option "TransformationMatrix" vorhanden, wird
+// diese verwendet, ansonsten die Optionen "Calibration" und "SwapAxes"
+// von evdev. Die Groesse des Bildschirms (Width, Height) muss vorgaengig
+// gesetzt sein.
+func (d *DistortedPlane) ReadXorgConf(r io.Reader) error {
+	var calib, matrix []float64
+	var swap bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Option "Name" "Wert"
+		parts := strings.Split(scanner.Text(), "\"")
+		if len(parts) < 4 || strings.TrimSpace(parts[0]) != "Option" {
+			continue
+		}
+		name, val := parts[1], parts[3]
+		switch name {
+		case xorgCalibration, xorgMatrix:
+			var values []float64
+			for _, f := range strings.Fields(val) {
+				v, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					return fmt.Errorf("%w: option %s: %v", ErrCalibFormat,
+						name, err)
+				}
+				values = append(values, v)
+			}
+			if name == xorgCalibration {
+				calib = values
+			} else {
+				matrix = values
+			}
+		case xorgSwapAxes:
+			switch strings.ToLower(strings.TrimSpace(val)) {
+			case "1", "on", "true", "yes":
+				swap = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	switch {
+	case len(matrix) == 9:
+		return d.SetTransformationMatrix([9]float64(matrix))
+	case len(calib) == 4:
+		return d.SetEvdevCalibration(int(calib[0]), int(calib[1]),
+			int(calib[2]), int(calib[3]), swap)
+	}
+	return fmt.Errorf("%w: no calibration found", ErrCalibFormat)
+}
@@ -0,0 +1,149 @@
+package adatft
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Liefert eine affin kalibrierte Abbildung mit leicht verdrehtem
+// Touchscreen.
+func exportTestPlane(t *testing.T, cm CalibModel) *DistortedPlane {
+	plane := &DistortedPlane{Model: cm, Width: 240, Height: 320}
+	ids, _ := RefPoints(9)
+	for _, id := range ids {
+		pos := refPointPos(id, plane.nativeRect(), 20)
+		plane.SetRefPoint(id, calibTestRaw(pos), pos)
+	}
+	if err := plane.Compute(); err != nil {
+		t.Fatal(err)
+	}
+	return plane
+}
+
+// Vergleicht die Abbildungen von a und b an einigen Punkten des
+// Touchscreens.
+func compareMapping(t *testing.T, name string, a, b *DistortedPlane,
+	tol float64) {
+	t.Helper()
+	for rx := uint16(500); rx < 4000; rx += 700 {
+		for ry := uint16(400); ry < 4000; ry += 700 {
+			raw := TouchRawPos{RawX: rx, RawY: ry}
+			pa, _ := a.Transform(raw)
+			pb, err := b.Transform(raw)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if d := math.Hypot(pa.X-pb.X, pa.Y-pb.Y); d > tol {
+				t.Errorf("%s: %v -> %v, want %v", name, raw, pb, pa)
+				return
+			}
+		}
+	}
+}
+
+func TestPointercal(t *testing.T) {
+	plane := exportTestPlane(t, CalibAffine)
+	var buf bytes.Buffer
+	if err := plane.WritePointercal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(buf.String())); n != 9 {
+		t.Errorf("pointercal has %d fields: %q", n, buf.String())
+	}
+	read := &DistortedPlane{}
+	if err := read.ReadPointercal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if read.Width != 240 || read.Height != 320 || read.Model != CalibAffine {
+		t.Errorf("read %+v", read)
+	}
+	compareMapping(t, "pointercal", plane, read, 0.5)
+
+	// Die importierten Daten muessen sich speichern lassen.
+	if err := (&CalibData{Version: CalibVersion, Model: read.Model,
+		Width: read.Width, Height: read.Height, RefPoints: read.RefPoints,
+		RawPosList: read.RawPosList, PosList: read.PosList,
+		Coeff: read.Coeff}).Validate(); err != nil {
+		t.Errorf("imported data invalid: %v", err)
+	}
+
+	// Einfaches Beispiel: X = RawX/16, Y = RawY/12 (ohne Aufloesung).
+	read = &DistortedPlane{Width: 240, Height: 320}
+	err := read.ReadPointercal(strings.NewReader("4096 0 0 0 5461 0 65536"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, _ := read.Transform(TouchRawPos{RawX: 1600, RawY: 2400})
+	if math.Abs(pos.X-100) > 1e-6 || math.Abs(pos.Y-200) > 0.05 ||
+		read.Model != CalibLinear {
+		t.Errorf("got %v (%v), want (100, 200)", pos, read.Model)
+	}
+	err = read.ReadPointercal(strings.NewReader("1 2 3"))
+	if !errors.Is(err, ErrCalibFormat) {
+		t.Errorf("short pointercal accepted: %v", err)
+	}
+}
+
+func TestTransformationMatrix(t *testing.T) {
+	for _, cm := range []CalibModel{CalibAffine, CalibPerspective} {
+		plane := exportTestPlane(t, cm)
+		ctm, err := plane.TransformationMatrix()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := &DistortedPlane{Width: 240, Height: 320}
+		if err := read.SetTransformationMatrix(ctm); err != nil {
+			t.Fatal(err)
+		}
+		compareMapping(t, cm.String(), plane, read, 1e-6)
+	}
+}
+
+func TestXorgConf(t *testing.T) {
+	plane := exportTestPlane(t, CalibAffine)
+	var buf bytes.Buffer
+	if err := plane.WriteXorgConf(&buf, "stmpe-ts"); err != nil {
+		t.Fatal(err)
+	}
+	read := &DistortedPlane{Width: 240, Height: 320}
+	if err := read.ReadXorgConf(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	compareMapping(t, "xorg matrix", plane, read, 1e-3)
+
+	// Ohne Matrix wird die evdev-Kalibrierung verwendet, welche die
+	// Scherung nicht beruecksichtigt und entsprechend ungenau ist.
+	var conf strings.Builder
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.Contains(line, xorgMatrix) {
+			conf.WriteString(line + "\n")
+		}
+	}
+	read = &DistortedPlane{Width: 240, Height: 320}
+	if err := read.ReadXorgConf(strings.NewReader(conf.String())); err != nil {
+		t.Fatal(err)
+	}
+	compareMapping(t, "xorg evdev", plane, read, 20.0)
+}
+
+func TestEvdevCalibration(t *testing.T) {
+	for _, swap := range []bool{false, true} {
+		plane := &DistortedPlane{Width: 240, Height: 320}
+		if err := plane.SetEvdevCalibration(3900, 200, 150, 3950,
+			swap); err != nil {
+			t.Fatal(err)
+		}
+		minX, maxX, minY, maxY, gotSwap, err := plane.EvdevCalibration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if minX != 3900 || maxX != 200 || minY != 150 || maxY != 3950 ||
+			gotSwap != swap {
+			t.Errorf("swap %v: got %d %d %d %d %v", swap, minX, maxX, minY,
+				maxY, gotSwap)
+		}
+	}
+}
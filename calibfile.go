@@ -0,0 +1,219 @@
+package adatft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Version des Kalibrierungsfiles, welche von WriteConfigFile geschrieben
+	// wird. Files ohne Versionsangabe (Version 0) stammen aus aelteren
+	// Versionen dieses Packages und werden weiterhin gelesen.
+	CalibVersion = 2
+)
+
+var (
+	// Name des Kalibrierungsfiles, falls die Identitaet des Geraetes nicht
+	// bekannt ist (und fuer Files aus aelteren Versionen).
+	calibDataFile = "TouchCalib.json"
+)
+
+var (
+	ErrCalibVersion = errors.New("calibration: unsupported file version")
+	ErrCalibData    = errors.New("calibration: invalid calibration data")
+)
+
+// Mit DeviceID wird ein Touchscreen eindeutig identifiziert: Board enthaelt
+// die Seriennummer des Rechners (RaspberryPi), Controller den Typ und die
+// Revision des Touch-Controllers. Damit koennen die Kalibrierungsdaten
+// mehrerer Geraete im gleichen Konfigurationsverzeichnis abgelegt werden.
+type DeviceID struct {
+	Board      string
+	Controller string
+}
+
+func (id DeviceID) String() string {
+	return id.Board + "/" + id.Controller
+}
+
+// Liefert den Namen des Kalibrierungsfiles fuer das Geraet id. Ist id
+// leer, wird der Name des bisherigen, geraeteunabhaengigen Files
+// geliefert.
+func CalibFileName(id DeviceID) string {
+	if id == (DeviceID{}) {
+		return filepath.Join(confDir, calibDataFile)
+	}
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-':
+			return r
+		}
+		return '_'
+	}, id.Board+"_"+id.Controller)
+	return filepath.Join(confDir, "TouchCalib-"+key+".json")
+}
+
+// Ermittelt die Seriennummer des Rechners. Auf einem RaspberryPi ist sie im
+// Device-Tree (bzw. in /proc/cpuinfo) abgelegt, auf anderen Systemen wird
+// die Machine-ID verwendet.
+func boardID() string {
+	const serialFile = "/sys/firmware/devicetree/base/serial-number"
+
+	if data, err := os.ReadFile(serialFile); err == nil {
+		if id := strings.Trim(string(data), "\x00 \n"); id != "" {
+			return id
+		}
+	}
+	if fh, err := os.Open("/proc/cpuinfo"); err == nil {
+		defer fh.Close()
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			key, val, ok := strings.Cut(scanner.Text(), ":")
+			if ok && strings.TrimSpace(key) == "Serial" {
+				return strings.TrimSpace(val)
+			}
+		}
+	}
+	if data, err := os.ReadFile("/etc/machine-id"); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	return "unknown"
+}
+
+// Inhalt des Kalibrierungsfiles. Alle Positionen beziehen sich auf das
+// Koordinatensystem des Bildschirms in der Rotation Rotate000 (d.h. der
+// nativen Ausrichtung des Panels), dessen Groesse in Width und Height
+// abgelegt ist; die Abbildung fuer die uebrigen Rotationen wird daraus
+// berechnet. Fehlen Model, RefPoints, Coeff oder die Groesse (Files aus
+// aelteren Versionen), werden sie beim Lesen ergaenzt bzw. berechnet.
+type CalibData struct {
+	Version       int
+	Device        DeviceID
+	Model         CalibModel
+	Width, Height int            `json:",omitempty"`
+	RefPoints     []RefPointType `json:",omitempty"`
+	RawPosList    []TouchRawPos
+	PosList       []TouchPos
+	Coeff         []float64 `json:",omitempty"`
+	// Aufloesung des ADC bei der Kalibrierung (siehe TouchConfig).
+	ADCBits int `json:",omitempty"`
+	// Rohwerte von Z bei leichtem und festem Druck sowie das Format des
+	// Z-Wertes bei deren Messung (siehe CalibratePressure).
+	RawZLight, RawZFirm uint8 `json:",omitempty"`
+	FractionZ           int   `json:",omitempty"`
+}
+
+// Liest die Konfiguration aus dem Default-File.
+func ReadCalibData() *CalibData {
+	fileName := filepath.Join(confDir, calibDataFile)
+	return ReadCalibDataFile(fileName)
+}
+
+// Liest die Konfiguration aus dem angegebenen File. Der Pfad kann absolut
+// oder relativ angegeben werden. Als Dateiformat wird JSON verwendet.
+func ReadCalibDataFile(fileName string) *CalibData {
+	d, err := LoadCalibDataFile(fileName)
+	if err != nil {
+		log.Fatalf("Couldn't read calibration file %s: %v", fileName, err)
+	}
+	return d
+}
+
+// Wie ReadCalibDataFile, liefert bei Fehlern (auch bei ungueltigen Daten,
+// siehe Validate) jedoch einen Fehler anstatt das Programm abzubrechen.
+func LoadCalibDataFile(fileName string) (*CalibData, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	d := &CalibData{}
+	if err = json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCalibData, err)
+	}
+	if len(d.RefPoints) == 0 {
+		d.RefPoints = make([]RefPointType, len(d.RawPosList))
+		for i := range d.RefPoints {
+			d.RefPoints[i] = RefPointType(i)
+		}
+	}
+	if err = d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Prueft die Kalibrierungsdaten auf Vollstaendigkeit und Plausibilitaet.
+// Insbesondere duerfen die Referenzpunkte nicht entartet sein (z.B. alle auf
+// einer Geraden liegen), da sonst keine Abbildung berechnet werden kann.
+func (d *CalibData) Validate() error {
+	if d.Version < 0 || d.Version > CalibVersion {
+		return fmt.Errorf("%w: %d (max. %d)", ErrCalibVersion, d.Version,
+			CalibVersion)
+	}
+	if d.Model < 0 || d.Model >= NumCalibModels {
+		return ErrCalibModel
+	}
+	n := len(d.RawPosList)
+	if len(d.PosList) != n || len(d.RefPoints) != n {
+		return fmt.Errorf("%w: %d raw positions, %d positions, %d ids",
+			ErrCalibData, n, len(d.PosList), len(d.RefPoints))
+	}
+	if n < d.Model.minPoints() {
+		return fmt.Errorf("%w: model %v needs %d, got %d", ErrCalibPoints,
+			d.Model, d.Model.minPoints(), n)
+	}
+	// Die Groesse darf nur in Files ohne Versionsangabe fehlen.
+	if d.Width < 0 || d.Height < 0 ||
+		(d.Version > 0 && (d.Width == 0 || d.Height == 0)) {
+		return fmt.Errorf("%w: size %dx%d", ErrCalibData, d.Width, d.Height)
+	}
+	if d.ADCBits != 0 && d.ADCBits != 10 && d.ADCBits != 12 {
+		return fmt.Errorf("%w: ADC resolution %d", ErrCalibData, d.ADCBits)
+	}
+	if d.FractionZ < 0 || d.FractionZ > 7 {
+		return fmt.Errorf("%w: fraction z %d", ErrCalibData, d.FractionZ)
+	}
+	if (d.RawZLight != 0 || d.RawZFirm != 0) && d.RawZLight == d.RawZFirm {
+		return fmt.Errorf("%w: pressure range %d-%d", ErrCalibData,
+			d.RawZLight, d.RawZFirm)
+	}
+	var seen [MaxRefPoints]bool
+	for i, id := range d.RefPoints {
+		if id >= MaxRefPoints || seen[id] {
+			return fmt.Errorf("%w: reference point %v", ErrCalibData, id)
+		}
+		seen[id] = true
+		pos := d.PosList[i]
+		if math.IsNaN(pos.X) || math.IsNaN(pos.Y) || pos.X < 0 || pos.Y < 0 ||
+			(d.Width > 0 && pos.X >= float64(d.Width)) ||
+			(d.Height > 0 && pos.Y >= float64(d.Height)) {
+			return fmt.Errorf("%w: position %v of %v outside the screen",
+				ErrCalibData, pos, id)
+		}
+	}
+	if len(d.Coeff) > 0 {
+		if len(d.Coeff) != d.Model.numCoeff() {
+			return fmt.Errorf("%w: %d coefficients for model %v",
+				ErrCalibData, len(d.Coeff), d.Model)
+		}
+		for _, c := range d.Coeff {
+			if math.IsNaN(c) || math.IsInf(c, 0) {
+				return fmt.Errorf("%w: invalid coefficient", ErrCalibData)
+			}
+		}
+	}
+	if _, err := fitModel(d.Model, d.RawPosList, d.PosList); err != nil {
+		return err
+	}
+	return nil
+}
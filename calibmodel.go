@@ -0,0 +1,320 @@
+package adatft
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Mit CalibModel wird bestimmt, mit welchem mathematischen Modell die
+// Koordinaten des Touchscreens auf Bildschirmkoordinaten abgebildet werden.
+// Die Koeffizienten werden jeweils nach der Methode der kleinsten Quadrate
+// aus den Referenzpunkten berechnet.
+type CalibModel int
+
+const (
+	// Beide Achsen werden unabhaengig voneinander linear abgebildet (X aus
+	// RawX, Y aus RawY). Dies entspricht dem urspruenglichen Verfahren und
+	// wird fuer Kalibrierungsdateien ohne Angabe des Modells verwendet.
+	CalibLinear CalibModel = iota
+	// Affine Abbildung (6 Koeffizienten, analog zu tslib). Beruecksichtigt
+	// Verschiebung, Skalierung, Scherung und Rotation zwischen Touchfolie
+	// und Bildschirm. Benoetigt mindestens 3 Referenzpunkte. Der siebte
+	// Koeffizient von tslib ist ein gemeinsamer Divisor, welcher nur fuer
+	// die Rechnung mit ganzen Zahlen benoetigt wird; da die Koeffizienten
+	// hier als float64 vorliegen, sind sie bereits durch ihn geteilt. Beim
+	// Import und Export im pointercal-Format wird er umgerechnet (siehe
+	// WritePointercal).
+	CalibAffine
+	// Bilineare Abbildung (8 Koeffizienten). Kann zusaetzlich eine
+	// trapezfoermige Verzerrung korrigieren. Benoetigt mindestens 4
+	// Referenzpunkte.
+	CalibBilinear
+	// Perspektivische Abbildung (Homographie, 8 Koeffizienten). Benoetigt
+	// mindestens 4 Referenzpunkte.
+	CalibPerspective
+	NumCalibModels
+)
+
+func (cm CalibModel) String() string {
+	switch cm {
+	case CalibLinear:
+		return "Linear"
+	case CalibAffine:
+		return "Affine"
+	case CalibBilinear:
+		return "Bilinear"
+	case CalibPerspective:
+		return "Perspective"
+	default:
+		return "(unknown calibration model)"
+	}
+}
+
+func (cm *CalibModel) Set(s string) error {
+	switch s {
+	case "Linear":
+		*cm = CalibLinear
+	case "Affine":
+		*cm = CalibAffine
+	case "Bilinear":
+		*cm = CalibBilinear
+	case "Perspective":
+		*cm = CalibPerspective
+	default:
+		return errors.New("Unknown calibration model: " + s)
+	}
+	return nil
+}
+
+// Damit wird das Modell im Kalibrierungsfile als Text abgelegt.
+func (cm CalibModel) MarshalText() ([]byte, error) {
+	return []byte(cm.String()), nil
+}
+
+func (cm *CalibModel) UnmarshalText(text []byte) error {
+	return cm.Set(string(text))
+}
+
+// Liefert die minimale Anzahl Referenzpunkte, welche fuer das Modell
+// benoetigt wird.
+func (cm CalibModel) minPoints() int {
+	switch cm {
+	case CalibLinear:
+		return 2
+	case CalibAffine:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Liefert die Anzahl Koeffizienten des Modells.
+func (cm CalibModel) numCoeff() int {
+	switch cm {
+	case CalibLinear, CalibAffine:
+		return 6
+	default:
+		return 8
+	}
+}
+
+var (
+	ErrCalibModel      = errors.New("calibration: unknown model")
+	ErrCalibPoints     = errors.New("calibration: not enough reference points")
+	ErrCalibDegenerate = errors.New("calibration: degenerate reference points")
+)
+
+// Berechnet die Koeffizienten des Modells cm aus den Rohdaten raw und den
+// zugehoerigen Bildschirmpositionen pos. Die Koeffizienten bilden
+// Rohdaten auf Bildschirmkoordinaten ab:
+//
+//   - CalibLinear, CalibAffine:
+//     x = c0 + c1*rx + c2*ry, y = c3 + c4*rx + c5*ry
+//     (bei CalibLinear sind c2 und c4 immer 0).
+//   - CalibBilinear:
+//     x = c0 + c1*rx + c2*ry + c3*rx*ry, y = c4 + c5*rx + c6*ry + c7*rx*ry
+//   - CalibPerspective:
+//     x = (c0 + c1*rx + c2*ry) / (1 + c6*rx + c7*ry),
+//     y = (c3 + c4*rx + c5*ry) / (1 + c6*rx + c7*ry)
+func fitModel(cm CalibModel, raw []TouchRawPos, pos []TouchPos) ([]float64,
+	error) {
+	if cm < 0 || cm >= NumCalibModels {
+		return nil, ErrCalibModel
+	}
+	n := len(raw)
+	if n < cm.minPoints() || len(pos) != n {
+		return nil, fmt.Errorf("%w: model %v needs %d, got %d",
+			ErrCalibPoints, cm, cm.minPoints(), n)
+	}
+
+	switch cm {
+	case CalibLinear:
+		ax := make([][]float64, n)
+		ay := make([][]float64, n)
+		bx := make([]float64, n)
+		by := make([]float64, n)
+		for i := range raw {
+			ax[i] = []float64{1, float64(raw[i].RawX)}
+			ay[i] = []float64{1, float64(raw[i].RawY)}
+			bx[i], by[i] = pos[i].X, pos[i].Y
+		}
+		cx, err := solveLeastSquares(ax, bx)
+		if err != nil {
+			return nil, err
+		}
+		cy, err := solveLeastSquares(ay, by)
+		if err != nil {
+			return nil, err
+		}
+		return []float64{cx[0], cx[1], 0, cy[0], 0, cy[1]}, nil
+
+	case CalibAffine, CalibBilinear:
+		a := make([][]float64, n)
+		bx := make([]float64, n)
+		by := make([]float64, n)
+		for i := range raw {
+			rx, ry := float64(raw[i].RawX), float64(raw[i].RawY)
+			if cm == CalibAffine {
+				a[i] = []float64{1, rx, ry}
+			} else {
+				a[i] = []float64{1, rx, ry, rx * ry}
+			}
+			bx[i], by[i] = pos[i].X, pos[i].Y
+		}
+		cx, err := solveLeastSquares(a, bx)
+		if err != nil {
+			return nil, err
+		}
+		cy, err := solveLeastSquares(a, by)
+		if err != nil {
+			return nil, err
+		}
+		return append(cx, cy...), nil
+
+	default:
+		// Die Homographie wird linearisiert (DLT): aus
+		// x*(1 + c6*rx + c7*ry) = c0 + c1*rx + c2*ry entsteht eine lineare
+		// Gleichung in den 8 Koeffizienten (analog fuer y).
+		a := make([][]float64, 2*n)
+		b := make([]float64, 2*n)
+		for i := range raw {
+			rx, ry := float64(raw[i].RawX), float64(raw[i].RawY)
+			x, y := pos[i].X, pos[i].Y
+			a[2*i] = []float64{1, rx, ry, 0, 0, 0, -x * rx, -x * ry}
+			a[2*i+1] = []float64{0, 0, 0, 1, rx, ry, -y * rx, -y * ry}
+			b[2*i], b[2*i+1] = x, y
+		}
+		return solveLeastSquares(a, b)
+	}
+}
+
+// Wendet das Modell cm mit den Koeffizienten c auf die Rohdaten (rx, ry) an.
+func evalModel(cm CalibModel, c []float64, rx, ry float64) (x, y float64,
+	err error) {
+	switch cm {
+	case CalibLinear, CalibAffine:
+		x = c[0] + c[1]*rx + c[2]*ry
+		y = c[3] + c[4]*rx + c[5]*ry
+	case CalibBilinear:
+		x = c[0] + c[1]*rx + c[2]*ry + c[3]*rx*ry
+		y = c[4] + c[5]*rx + c[6]*ry + c[7]*rx*ry
+	case CalibPerspective:
+		w := 1 + c[6]*rx + c[7]*ry
+		if math.Abs(w) < 1e-12 {
+			return 0, 0, ErrCalibDegenerate
+		}
+		x = (c[0] + c[1]*rx + c[2]*ry) / w
+		y = (c[3] + c[4]*rx + c[5]*ry) / w
+	default:
+		return 0, 0, ErrCalibModel
+	}
+	return x, y, nil
+}
+
+// Liefert die Koeffizienten des Modells cm fuer Rohdaten, welche um den
+// Faktor f skaliert sind (z.B. nach einem Wechsel der Aufloesung des ADC).
+// Die Abbildung auf den Bildschirm bleibt dabei unveraendert.
+func scaleModel(cm CalibModel, c []float64, f float64) []float64 {
+	s := slices.Clone(c)
+	switch cm {
+	case CalibLinear, CalibAffine:
+		s[1], s[2], s[4], s[5] = c[1]/f, c[2]/f, c[4]/f, c[5]/f
+	case CalibBilinear:
+		s[1], s[2], s[5], s[6] = c[1]/f, c[2]/f, c[5]/f, c[6]/f
+		s[3], s[7] = c[3]/(f*f), c[7]/(f*f)
+	case CalibPerspective:
+		for _, i := range []int{1, 2, 4, 5, 6, 7} {
+			s[i] = c[i] / f
+		}
+	}
+	return s
+}
+
+// Loest das (ueberbestimmte) Gleichungssystem a*x = b nach der Methode der
+// kleinsten Quadrate. Verwendet wird eine QR-Zerlegung mit Householder-
+// Spiegelungen; die Spalten werden vorgaengig normiert, da die Rohdaten
+// des Touchscreens (und deren Produkte) sehr unterschiedliche
+// Groessenordnungen aufweisen.
+func solveLeastSquares(a [][]float64, b []float64) ([]float64, error) {
+	rows := len(a)
+	if rows == 0 {
+		return nil, ErrCalibPoints
+	}
+	cols := len(a[0])
+	if rows < cols {
+		return nil, ErrCalibPoints
+	}
+
+	// Kopien anlegen, damit a und b unveraendert bleiben.
+	q := make([][]float64, rows)
+	for i := range a {
+		q[i] = append([]float64(nil), a[i]...)
+	}
+	r := append([]float64(nil), b...)
+
+	scale := make([]float64, cols)
+	for j := 0; j < cols; j++ {
+		for i := 0; i < rows; i++ {
+			scale[j] = math.Max(scale[j], math.Abs(q[i][j]))
+		}
+		if scale[j] == 0.0 {
+			return nil, ErrCalibDegenerate
+		}
+		for i := 0; i < rows; i++ {
+			q[i][j] /= scale[j]
+		}
+	}
+
+	for k := 0; k < cols; k++ {
+		var norm float64
+		for i := k; i < rows; i++ {
+			norm = math.Hypot(norm, q[i][k])
+		}
+		if norm < 1e-10 {
+			return nil, ErrCalibDegenerate
+		}
+		if q[k][k] > 0 {
+			norm = -norm
+		}
+		// Householder-Vektor v = x - norm*e_k, abgelegt in Spalte k.
+		q[k][k] -= norm
+		vv := 0.0
+		for i := k; i < rows; i++ {
+			vv += q[i][k] * q[i][k]
+		}
+		for j := k + 1; j < cols; j++ {
+			s := 0.0
+			for i := k; i < rows; i++ {
+				s += q[i][k] * q[i][j]
+			}
+			s = 2 * s / vv
+			for i := k; i < rows; i++ {
+				q[i][j] -= s * q[i][k]
+			}
+		}
+		s := 0.0
+		for i := k; i < rows; i++ {
+			s += q[i][k] * r[i]
+		}
+		s = 2 * s / vv
+		for i := k; i < rows; i++ {
+			r[i] -= s * q[i][k]
+		}
+		q[k][k] = norm
+	}
+
+	x := make([]float64, cols)
+	for k := cols - 1; k >= 0; k-- {
+		s := r[k]
+		for j := k + 1; j < cols; j++ {
+			s -= q[k][j] * x[j]
+		}
+		x[k] = s / q[k][k]
+	}
+	for j := range x {
+		x[j] /= scale[j]
+	}
+	return x, nil
+}
@@ -0,0 +1,111 @@
+package adatft
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Abbildungen von Rohdaten auf Bildschirmkoordinaten, welche vom jeweiligen
+// Modell exakt wiedergegeben werden koennen.
+var (
+	modelTestMaps = [NumCalibModels]func(rx, ry float64) (float64, float64){
+		CalibLinear: func(rx, ry float64) (float64, float64) {
+			return 260.0 - 0.07*rx, -10.0 + 0.095*ry
+		},
+		CalibAffine: func(rx, ry float64) (float64, float64) {
+			return 260.0 - 0.07*rx + 0.004*ry, -10.0 + 0.003*rx + 0.095*ry
+		},
+		CalibBilinear: func(rx, ry float64) (float64, float64) {
+			return 260.0 - 0.07*rx + 0.004*ry + 2e-6*rx*ry,
+				-10.0 + 0.003*rx + 0.095*ry - 1e-6*rx*ry
+		},
+		CalibPerspective: func(rx, ry float64) (float64, float64) {
+			w := 1.0 + 2e-5*rx - 1e-5*ry
+			return (260.0 - 0.07*rx + 0.004*ry) / w,
+				(-10.0 + 0.003*rx + 0.095*ry) / w
+		},
+	}
+)
+
+func modelTestPoints(f func(rx, ry float64) (float64, float64),
+	n int) ([]TouchRawPos, []TouchPos) {
+	raw := make([]TouchRawPos, 0, n*n)
+	pos := make([]TouchPos, 0, n*n)
+	for i := range n {
+		for j := range n {
+			r := TouchRawPos{RawX: uint16(400 + i*3200/(n-1)),
+				RawY: uint16(350 + j*3300/(n-1))}
+			x, y := f(float64(r.RawX), float64(r.RawY))
+			raw = append(raw, r)
+			pos = append(pos, TouchPos{X: x, Y: y})
+		}
+	}
+	return raw, pos
+}
+
+func TestFitModel(t *testing.T) {
+	testRand := rand.New(rand.NewSource(4_711))
+	for cm := CalibLinear; cm < NumCalibModels; cm++ {
+		raw, pos := modelTestPoints(modelTestMaps[cm], 3)
+		coeff, err := fitModel(cm, raw, pos)
+		if err != nil {
+			t.Fatalf("%v: %v", cm, err)
+		}
+		if len(coeff) != cm.numCoeff() {
+			t.Errorf("%v: %d coefficients, want %d", cm, len(coeff),
+				cm.numCoeff())
+		}
+		for range 100 {
+			rx := 400.0 + 3200.0*testRand.Float64()
+			ry := 350.0 + 3300.0*testRand.Float64()
+			x, y, err := evalModel(cm, coeff, rx, ry)
+			if err != nil {
+				t.Fatalf("%v: %v", cm, err)
+			}
+			wx, wy := modelTestMaps[cm](rx, ry)
+			if math.Hypot(x-wx, y-wy) > 1e-6 {
+				t.Errorf("%v: (%.0f, %.0f) -> (%.3f, %.3f), want (%.3f, %.3f)",
+					cm, rx, ry, x, y, wx, wy)
+				break
+			}
+		}
+	}
+}
+
+// Das affine Modell muss eine Scherung der Touchfolie korrigieren, welche
+// das lineare Modell nicht abbilden kann.
+func TestFitModelSkew(t *testing.T) {
+	raw, pos := modelTestPoints(modelTestMaps[CalibAffine], 3)
+	worst := [2]float64{}
+	for i, cm := range []CalibModel{CalibLinear, CalibAffine} {
+		coeff, err := fitModel(cm, raw, pos)
+		if err != nil {
+			t.Fatalf("%v: %v", cm, err)
+		}
+		for j, r := range raw {
+			x, y, _ := evalModel(cm, coeff, float64(r.RawX), float64(r.RawY))
+			worst[i] = max(worst[i], math.Hypot(x-pos[j].X, y-pos[j].Y))
+		}
+	}
+	if worst[0] < 1.0 || worst[1] > 1e-6 {
+		t.Errorf("max. error linear %.3f, affine %.3f", worst[0], worst[1])
+	}
+}
+
+func TestFitModelErrors(t *testing.T) {
+	raw, pos := modelTestPoints(modelTestMaps[CalibAffine], 2)
+	if _, err := fitModel(CalibPerspective, raw[:3], pos[:3]); !errors.Is(err,
+		ErrCalibPoints) {
+		t.Errorf("perspective with 3 points: got %v", err)
+	}
+	line := []TouchRawPos{{100, 100, 0}, {200, 200, 0}, {300, 300, 0}}
+	if _, err := fitModel(CalibAffine, line, pos[:3]); !errors.Is(err,
+		ErrCalibDegenerate) {
+		t.Errorf("collinear points: got %v", err)
+	}
+	if _, err := RefPoints(6); !errors.Is(err, ErrCalibPoints) {
+		t.Errorf("6 reference points accepted")
+	}
+}
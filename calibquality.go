@@ -0,0 +1,160 @@
+package adatft
+
+import (
+	"math"
+	"sync"
+)
+
+// CalibQuality enthaelt die Abweichungen (Residuen) der kalibrierten
+// Abbildung an den Referenzpunkten, d.h. den Abstand in Pixeln zwischen der
+// Position eines Referenzpunktes und der Position, welche die Abbildung aus
+// dessen Rohdaten berechnet. Aussagekraeftig sind die Werte nur, wenn mehr
+// Referenzpunkte verwendet wurden als das Modell mindestens benoetigt
+// (z.B. 5 oder 9 Punkte beim affinen Modell), da die Abbildung die Punkte
+// sonst exakt trifft.
+type CalibQuality struct {
+	// Residuen pro Referenzpunkt (gleiche Reihenfolge wie RefPoints).
+	Residuals []float64
+	// Mittlere, quadratisch gemittelte und maximale Abweichung.
+	Mean, RMS, Max float64
+	// Referenzpunkt mit der groessten Abweichung.
+	Worst RefPointType
+}
+
+// Berechnet die Abweichungen der Abbildung an den Referenzpunkten (siehe
+// CalibQuality). Da alle Referenzpunkte im Koordinatensystem von Rotate000
+// abgelegt sind, wird auch die Abweichung dort berechnet; sie ist von der
+// Rotation unabhaengig.
+func (d *DistortedPlane) Quality() (q CalibQuality, err error) {
+	if len(d.Coeff) != d.Model.numCoeff() {
+		return q, ErrCalibModel
+	}
+	q.Residuals = make([]float64, len(d.RawPosList))
+	for i, raw := range d.RawPosList {
+		x, y, err := evalModel(d.Model, d.Coeff, float64(raw.RawX),
+			float64(raw.RawY))
+		if err != nil {
+			return q, err
+		}
+		r := math.Hypot(x-d.PosList[i].X, y-d.PosList[i].Y)
+		q.Residuals[i] = r
+		q.Mean += r
+		q.RMS += r * r
+		if r > q.Max || i == 0 {
+			q.Max = r
+			q.Worst = d.RefPoints[i]
+		}
+	}
+	if n := float64(len(q.Residuals)); n > 0 {
+		q.Mean /= n
+		q.RMS = math.Sqrt(q.RMS / n)
+	}
+	return q, nil
+}
+
+// Mit einem DriftDetector kann im laufenden Betrieb erkannt werden, ob sich
+// die Kalibrierung des Touchscreens verschoben hat (z.B. durch Alterung oder
+// Temperatur). Dazu meldet die Applikation mit AddTap jeweils die Position
+// eines bekannten Zieles (z.B. die Mitte eines Buttons) und die Position,
+// an welcher der Touchscreen gedrueckt wurde. Ueberschreitet die mittlere
+// Verschiebung der letzten Taps den Schwellwert, gilt die Kalibrierung als
+// verschoben und OnDrift wird aufgerufen. Die Methoden koennen aus
+// beliebigen Go-Routinen aufgerufen werden.
+type DriftDetector struct {
+	// Mittlere Verschiebung (in Pixeln), ab welcher die Kalibrierung als
+	// verschoben gilt.
+	Threshold float64
+	// Anzahl Taps, ueber welche gemittelt wird (mindestens 1). Wird der
+	// Wert verkleinert, werden beim naechsten Tap die aeltesten verworfen.
+	Window int
+	// Minimale Anzahl Taps, bevor eine Verschiebung gemeldet wird.
+	MinSamples int
+	// Taps, welche weiter als MaxDistance (in Pixeln) vom Ziel entfernt
+	// sind, werden ignoriert (vermutlich wurde ein anderes Ziel gemeint).
+	MaxDistance float64
+	// Wird (einmalig, bis zum naechsten Reset) aufgerufen, sobald eine
+	// Verschiebung erkannt wird.
+	OnDrift func(dx, dy float64)
+
+	mutex   sync.Mutex
+	offsets []TouchPos
+	drifted bool
+}
+
+// Erstellt einen neuen DriftDetector mit dem Schwellwert threshold (in
+// Pixeln), welcher ueber die letzten window Taps mittelt.
+func NewDriftDetector(threshold float64, window int) *DriftDetector {
+	dd := &DriftDetector{}
+	dd.Threshold = threshold
+	dd.Window = max(1, window)
+	dd.MinSamples = max(1, window/2)
+	dd.MaxDistance = 30.0
+	return dd
+}
+
+// Meldet einen Tap an der Position pos auf das Ziel target. Retourniert
+// true, falls die Kalibrierung (inkl. diesem Tap) als verschoben gilt.
+func (dd *DriftDetector) AddTap(target, pos TouchPos) bool {
+	dx, dy := pos.X-target.X, pos.Y-target.Y
+	if dd.MaxDistance > 0 && math.Hypot(dx, dy) > dd.MaxDistance {
+		return dd.Drifted()
+	}
+
+	dd.mutex.Lock()
+	// Die Taps sind vom aeltesten zum neusten abgelegt; was nicht mehr
+	// ins Fenster passt, wird vorne entfernt.
+	dd.offsets = append(dd.offsets, TouchPos{X: dx, Y: dy})
+	if n := len(dd.offsets) - max(1, dd.Window); n > 0 {
+		dd.offsets = append(dd.offsets[:0], dd.offsets[n:]...)
+	}
+	mx, my, n := dd.offset()
+	notify := false
+	if !dd.drifted && n >= dd.MinSamples &&
+		math.Hypot(mx, my) > dd.Threshold {
+		dd.drifted = true
+		notify = dd.OnDrift != nil
+	}
+	drifted := dd.drifted
+	dd.mutex.Unlock()
+
+	// Der Callback wird ausserhalb des Locks aufgerufen, damit er selber
+	// wieder Methoden des Detektors verwenden kann.
+	if notify {
+		dd.OnDrift(mx, my)
+	}
+	return drifted
+}
+
+// Liefert die mittlere Verschiebung der gesammelten Taps sowie deren Anzahl.
+func (dd *DriftDetector) Offset() (dx, dy float64, n int) {
+	dd.mutex.Lock()
+	defer dd.mutex.Unlock()
+	return dd.offset()
+}
+
+func (dd *DriftDetector) offset() (dx, dy float64, n int) {
+	n = len(dd.offsets)
+	if n == 0 {
+		return 0.0, 0.0, 0
+	}
+	for _, off := range dd.offsets {
+		dx += off.X
+		dy += off.Y
+	}
+	return dx / float64(n), dy / float64(n), n
+}
+
+// Liefert true, falls eine Verschiebung erkannt wurde.
+func (dd *DriftDetector) Drifted() bool {
+	dd.mutex.Lock()
+	defer dd.mutex.Unlock()
+	return dd.drifted
+}
+
+// Verwirft alle gesammelten Taps (z.B. nach einer neuen Kalibrierung).
+func (dd *DriftDetector) Reset() {
+	dd.mutex.Lock()
+	defer dd.mutex.Unlock()
+	dd.offsets = dd.offsets[:0]
+	dd.drifted = false
+}
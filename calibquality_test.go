@@ -0,0 +1,101 @@
+package adatft
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalibQuality(t *testing.T) {
+	raw, pos := modelTestPoints(modelTestMaps[CalibBilinear], 3)
+	plane := &DistortedPlane{Width: 240, Height: 320}
+	ids, _ := RefPoints(9)
+	for i, id := range ids {
+		plane.SetRefPoint(id, raw[i], pos[i])
+	}
+	var rms [NumCalibModels]float64
+	for cm := CalibLinear; cm < NumCalibModels; cm++ {
+		plane.Model = cm
+		if err := plane.Compute(); err != nil {
+			t.Fatalf("%v: %v", cm, err)
+		}
+		q, err := plane.Quality()
+		if err != nil {
+			t.Fatalf("%v: %v", cm, err)
+		}
+		if len(q.Residuals) != len(ids) || q.Mean > q.RMS || q.RMS > q.Max {
+			t.Errorf("%v: inconsistent quality %+v", cm, q)
+		}
+		rms[cm] = q.RMS
+	}
+	// Die Daten stammen aus einer bilinearen Abbildung: dieses Modell muss
+	// sie exakt wiedergeben, die einfacheren Modelle nicht.
+	if rms[CalibBilinear] > 1e-6 || rms[CalibAffine] < 0.1 ||
+		rms[CalibLinear] < rms[CalibAffine] {
+		t.Errorf("unexpected residuals: %v", rms)
+	}
+}
+
+func TestDriftDetector(t *testing.T) {
+	var notified int
+	var ndx, ndy float64
+
+	dd := NewDriftDetector(4.0, 8)
+	dd.OnDrift = func(dx, dy float64) {
+		notified++
+		ndx, ndy = dx, dy
+	}
+	target := TouchPos{X: 100, Y: 100}
+	// Kleine, zufaellig verteilte Abweichungen: keine Verschiebung.
+	for i := range 16 {
+		s := float64(2*(i%2) - 1)
+		if dd.AddTap(target, TouchPos{X: 100 + 2*s, Y: 100 - s}) {
+			t.Fatalf("drift detected after %d taps", i+1)
+		}
+	}
+	// Weit entfernte Taps werden ignoriert.
+	dd.AddTap(target, TouchPos{X: 200, Y: 200})
+	if _, _, n := dd.Offset(); n != 8 {
+		t.Errorf("window holds %d taps, want 8", n)
+	}
+	// Systematische Verschiebung um (6, -3).
+	for range 8 {
+		dd.AddTap(target, TouchPos{X: 106, Y: 97})
+	}
+	if !dd.Drifted() || notified != 1 {
+		t.Fatalf("drifted %v, notified %d", dd.Drifted(), notified)
+	}
+	if math.Hypot(ndx, ndy) <= 4.0 {
+		t.Errorf("reported offset (%.1f, %.1f)", ndx, ndy)
+	}
+	if dx, dy, _ := dd.Offset(); math.Abs(dx-6) > 1e-6 ||
+		math.Abs(dy+3) > 1e-6 {
+		t.Errorf("mean offset (%.1f, %.1f), want (6, -3)", dx, dy)
+	}
+	dd.Reset()
+	if dd.Drifted() {
+		t.Errorf("drift not reset")
+	}
+}
+
+// Ein DriftDetector ohne Konstruktor (Window 0) und ein nachtraeglich
+// verkleinertes Fenster duerfen nicht zu einem Absturz fuehren.
+func TestDriftDetectorWindow(t *testing.T) {
+	target := TouchPos{X: 100, Y: 100}
+	dd := &DriftDetector{Threshold: 5}
+	dd.AddTap(target, TouchPos{X: 102, Y: 100})
+	dd.AddTap(target, TouchPos{X: 110, Y: 100})
+	if dx, _, n := dd.Offset(); n != 1 || dx != 10 {
+		t.Errorf("window 0: got offset %.1f over %d taps", dx, n)
+	}
+
+	dd = NewDriftDetector(5, 8)
+	for i := range 8 {
+		dd.AddTap(target, TouchPos{X: 100 + float64(i), Y: 100})
+	}
+	dd.Window = 2
+	dd.AddTap(target, TouchPos{X: 110, Y: 100})
+	if dx, _, n := dd.Offset(); n != 2 || dx != 8.5 {
+		t.Errorf("window 2: got offset %.1f over %d taps, want 8.5 over 2",
+			dx, n)
+	}
+}
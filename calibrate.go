@@ -0,0 +1,386 @@
+package adatft
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"time"
+)
+
+// Mit CalibOptions wird der Ablauf der interaktiven Kalibrierung (siehe
+// Calibrate) gesteuert.
+type CalibOptions struct {
+	// Modell, mit welchem die Abbildung berechnet wird (siehe CalibModel).
+	Model CalibModel
+	// Anzahl Referenzpunkte: 3, 4, 5 oder 9 (siehe RefPoints). Das Modell
+	// bestimmt die minimale Anzahl.
+	Points int
+	// Abstand der Referenzpunkte vom Bildschirmrand in Pixeln.
+	Margin int
+	// Minimale Anzahl Messwerte, welche pro Referenzpunkt gesammelt werden
+	// muessen (ein Druck auf den Touchscreen liefert in der Regel mehrere
+	// Messwerte).
+	Samples int
+	// Maximale Standardabweichung der Messwerte eines Referenzpunktes (in
+	// Einheiten des Touchscreens). Ist sie groesser, muss der Punkt erneut
+	// gedrueckt werden.
+	MaxSpread float64
+	// Maximale relative Abweichung zwischen gegenueberliegenden Seiten des
+	// durch die Referenzpunkte gebildeten Vierecks.
+	MaxSkew float64
+	// Anzahl Versuche pro Referenzpunkt.
+	Retries int
+	// Anzahl Testpunkte, welche nach der Kalibrierung zur Ueberpruefung
+	// angezeigt werden (0 bedeutet: keine Ueberpruefung).
+	VerifyPoints int
+	// Maximal zulaessige Abweichung (in Pixeln) bei der Ueberpruefung.
+	MaxError float64
+	// Maximale Wartezeit auf einen Druck pro Referenz- oder Testpunkt.
+	Timeout time.Duration
+	// Datei, in welche die Kalibrierungsdaten geschrieben werden. Ist der
+	// Name leer, wird das File des Geraetes (siehe CalibFileName) im
+	// Konfigurationsverzeichnis verwendet.
+	FileName string
+}
+
+var (
+	// Empfohlene Einstellungen fuer die Kalibrierung.
+	DefaultCalibOptions = CalibOptions{
+		Model:        CalibAffine,
+		Points:       5,
+		Margin:       20,
+		Samples:      5,
+		MaxSpread:    20.0,
+		MaxSkew:      0.15,
+		Retries:      3,
+		VerifyPoints: 3,
+		MaxError:     8.0,
+		Timeout:      30 * time.Second,
+	}
+
+	// Farben fuer die Darstellung der Kalibrierungspunkte.
+	calibBackColor   = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	calibTargetColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	calibRetryColor  = color.RGBA{0xff, 0x40, 0x40, 0xff}
+	calibVerifyColor = color.RGBA{0x40, 0xff, 0x40, 0xff}
+)
+
+var (
+	ErrCalibTimeout = errors.New("calibration: no touch within timeout")
+	ErrCalibSpread  = errors.New("calibration: touch samples too scattered")
+	ErrCalibInvalid = errors.New("calibration: inconsistent reference points")
+	ErrCalibVerify  = errors.New("calibration: verification failed")
+)
+
+// Fuehrt die interaktive Kalibrierung des Touchscreens durch. Dazu werden
+// auf dem Display dsp nacheinander Fadenkreuze an den Positionen der
+// Referenzpunkte (siehe RefPoints, Anzahl gemaess opts.Points) angezeigt,
+// welche vom Benutzer gedrueckt werden muessen. Pro Referenzpunkt werden die
+// Rohdaten gemittelt und auf ihre Streuung ueberprueft. Anschliessend wird
+// die Konsistenz der Punkte geprueft, die Abbildung gemaess opts.Model
+// berechnet und (optional) mit Testpunkten verifiziert. Verlaeuft alles
+// erfolgreich, werden die Daten mit WriteConfigFile gespeichert und von tch
+// ab sofort verwendet. Kann das File nicht geschrieben werden, wird die neue
+// Kalibrierung zusammen mit dem Fehler retourniert, von tch aber nicht
+// uebernommen. Waehrend der Kalibrierung sind der minimale Druck (siehe
+// TouchConfig.MinPressure) und die Filterkette (siehe SetFilters)
+// ausgeschaltet; die Abonnenten (siehe Subscribe) erhalten keine Events.
+//
+// Die Rotation wird von tch uebernommen und muss mit derjenigen von dsp
+// uebereinstimmen.
+func Calibrate(dsp *Display, tch *Touch, opts CalibOptions) (*DistortedPlane,
+	error) {
+	ids, err := RefPoints(opts.Points)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Points < opts.Model.minPoints() {
+		return nil, fmt.Errorf("%w: model %v needs %d", ErrCalibPoints,
+			opts.Model, opts.Model.minPoints())
+	}
+
+	rect := dsp.Bounds()
+	cur := tch.Plane()
+	plane := &DistortedPlane{}
+	plane.Rot = cur.Rot
+	plane.Device = tch.device
+	plane.ADCBits = tch.Config().ADCBits
+	plane.Model = opts.Model
+	plane.Width, plane.Height = rect.Dx(), rect.Dy()
+	if plane.Rot == Rotate090 || plane.Rot == Rotate270 {
+		plane.Width, plane.Height = rect.Dy(), rect.Dx()
+	}
+	plane.SetZRange(cur.RawZmin, cur.RawZmax, cur.Zmin, cur.Zmax)
+	plane.RawZLight, plane.RawZFirm = cur.RawZLight, cur.RawZFirm
+	plane.FractionZ = cur.FractionZ
+
+	// Die Messwerte werden ungefiltert benoetigt: weder duerfen leichte
+	// Beruehrungen verworfen noch die Positionen veraendert werden.
+	defer tch.suspendFilters()()
+	// Alle Events muessen in EventQ landen, auch diejenigen im Bereich
+	// eines Abonnenten.
+	defer tch.router.suspend()()
+
+	fb := dsp.Framebuffer()
+	rawPosList := make([]TouchRawPos, len(ids))
+	for i, id := range ids {
+		pos := refPointPos(id, rect, opts.Margin)
+		if rawPosList[i], err = calibPoint(dsp, fb, tch, pos,
+			opts); err != nil {
+			return nil, fmt.Errorf("%v: %w", id, err)
+		}
+		plane.SetRefPoint(id, rawPosList[i], plane.ToNative(pos))
+	}
+	clearScreen(dsp, fb)
+	if err = checkRefPoints(ids, rawPosList, opts.MaxSkew); err != nil {
+		return nil, err
+	}
+	if err = plane.Compute(); err != nil {
+		return nil, err
+	}
+
+	if err = verifyPlane(dsp, fb, tch, plane, opts); err != nil {
+		return plane, err
+	}
+
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = CalibFileName(tch.device)
+	}
+	if err = plane.WriteConfigFile(fileName); err != nil {
+		return plane, err
+	}
+	tch.setPlane(*plane)
+	return plane, nil
+}
+
+// Liefert die Bildschirmposition des Referenzpunktes id auf einem
+// Bildschirm der Groesse rect, mit dem Abstand margin vom Rand.
+func refPointPos(id RefPointType, rect image.Rectangle, margin int) TouchPos {
+	x0, y0 := float64(rect.Min.X+margin), float64(rect.Min.Y+margin)
+	x1, y1 := float64(rect.Max.X-1-margin), float64(rect.Max.Y-1-margin)
+	xm, ym := math.Round((x0+x1)/2), math.Round((y0+y1)/2)
+	switch id {
+	case RefTopRight:
+		return TouchPos{X: x1, Y: y0}
+	case RefBottomRight:
+		return TouchPos{X: x1, Y: y1}
+	case RefBottomLeft:
+		return TouchPos{X: x0, Y: y1}
+	case RefCenter:
+		return TouchPos{X: xm, Y: ym}
+	case RefTopCenter:
+		return TouchPos{X: xm, Y: y0}
+	case RefRightCenter:
+		return TouchPos{X: x1, Y: ym}
+	case RefBottomCenter:
+		return TouchPos{X: xm, Y: y1}
+	case RefLeftCenter:
+		return TouchPos{X: x0, Y: ym}
+	}
+	return TouchPos{X: x0, Y: y0}
+}
+
+// Zeigt an der Position pos ein Fadenkreuz an und liefert den Mittelwert der
+// Rohdaten, welche beim Druecken gesammelt werden. Ist die Streuung zu gross,
+// wird der Vorgang (mit einem roten Fadenkreuz) wiederholt.
+func calibPoint(dsp *Display, fb *Framebuffer, tch *Touch, pos TouchPos,
+	opts CalibOptions) (TouchRawPos, error) {
+	var rawPos TouchRawPos
+	var spread float64
+	var err error
+
+	col := calibTargetColor
+	for try := 0; try < max(1, opts.Retries); try++ {
+		clearScreen(dsp, fb)
+		drawCrosshair(fb, pos, col)
+		dsp.Flush()
+		rawPos, spread, err = collectSamples(tch, opts)
+		if err != nil {
+			return rawPos, err
+		}
+		if spread <= opts.MaxSpread {
+			return rawPos, nil
+		}
+		log.Printf("Samples too scattered (%.1f > %.1f), please retry",
+			spread, opts.MaxSpread)
+		col = calibRetryColor
+	}
+	return rawPos, ErrCalibSpread
+}
+
+// Wartet auf einen Druck auf den Touchscreen und sammelt alle Rohdaten bis
+// zum Loslassen. Retourniert werden der Mittelwert sowie die Streuung
+// (Standardabweichung des Abstandes zum Mittelwert).
+func collectSamples(tch *Touch, opts CalibOptions) (TouchRawPos, float64,
+	error) {
+	var samples []TouchRawPos
+	var sx, sy, sz float64
+
+	// Alte Events (z.B. vom vorangehenden Punkt) werden verworfen.
+	for len(tch.EventQ) > 0 {
+		<-tch.EventQ
+	}
+	timeout := time.After(opts.Timeout)
+	for {
+		select {
+		case ev := <-tch.EventQ:
+			switch ev.Type {
+			case PenPress:
+				samples = samples[:0]
+				fallthrough
+			case PenDrag:
+				samples = append(samples, ev.TouchRawPos)
+			case PenRelease:
+				if len(samples) < max(1, opts.Samples) {
+					log.Printf("Only %d samples, please press longer",
+						len(samples))
+					continue
+				}
+				for _, s := range samples {
+					sx += float64(s.RawX)
+					sy += float64(s.RawY)
+					sz += float64(s.RawZ)
+				}
+				n := float64(len(samples))
+				mean := TouchRawPos{
+					RawX: uint16(math.Round(sx / n)),
+					RawY: uint16(math.Round(sy / n)),
+					RawZ: uint8(math.Round(sz / n)),
+				}
+				var v float64
+				for _, s := range samples {
+					dx := float64(s.RawX) - sx/n
+					dy := float64(s.RawY) - sy/n
+					v += dx*dx + dy*dy
+				}
+				return mean, math.Sqrt(v / n), nil
+			}
+		case <-timeout:
+			return TouchRawPos{}, 0.0, ErrCalibTimeout
+		}
+	}
+}
+
+// Prueft, ob die Eckpunkte unter den Referenzpunkten ids (mit den Rohdaten
+// raw) ein plausibles Viereck bilden: die Seiten duerfen nicht entartet sein,
+// gegenueberliegende Seiten muessen in etwa gleich lang sein und das Viereck
+// muss konvex sein. Bei nur drei Ecken wird geprueft, dass sie nicht auf
+// einer Geraden liegen.
+func checkRefPoints(ids []RefPointType, raw []TouchRawPos,
+	maxSkew float64) error {
+	var corner [4]TouchRawPos
+	var numCorners int
+
+	for i, id := range ids {
+		if id <= RefBottomLeft {
+			corner[id] = raw[i]
+			numCorners++
+		}
+	}
+	dist := func(a, b RefPointType) float64 {
+		dx := float64(corner[a].RawX) - float64(corner[b].RawX)
+		dy := float64(corner[a].RawY) - float64(corner[b].RawY)
+		return math.Hypot(dx, dy)
+	}
+	cross := func(a, b, c RefPointType) float64 {
+		p0, p1, p2 := corner[a], corner[b], corner[c]
+		return (float64(p1.RawX)-float64(p0.RawX))*
+			(float64(p2.RawY)-float64(p1.RawY)) -
+			(float64(p1.RawY)-float64(p0.RawY))*
+				(float64(p2.RawX)-float64(p1.RawX))
+	}
+
+	if numCorners < 4 {
+		top := dist(RefTopLeft, RefTopRight)
+		right := dist(RefTopRight, RefBottomRight)
+		if min(top, right) < 1.0 ||
+			math.Abs(cross(RefTopLeft, RefTopRight, RefBottomRight)) <
+				0.1*top*right {
+			return fmt.Errorf("%w: degenerate triangle", ErrCalibInvalid)
+		}
+		return nil
+	}
+
+	top := dist(RefTopLeft, RefTopRight)
+	bottom := dist(RefBottomLeft, RefBottomRight)
+	left := dist(RefTopLeft, RefBottomLeft)
+	right := dist(RefTopRight, RefBottomRight)
+	if min(top, bottom, left, right) < 1.0 {
+		return fmt.Errorf("%w: degenerate quadrilateral", ErrCalibInvalid)
+	}
+	if d := math.Abs(top-bottom) / max(top, bottom); d > maxSkew {
+		return fmt.Errorf("%w: top/bottom differ by %.0f%%", ErrCalibInvalid,
+			100.0*d)
+	}
+	if d := math.Abs(left-right) / max(left, right); d > maxSkew {
+		return fmt.Errorf("%w: left/right differ by %.0f%%", ErrCalibInvalid,
+			100.0*d)
+	}
+	// Das Viereck muss konvex sein, d.h. alle Ecken muessen im gleichen
+	// Drehsinn durchlaufen werden. Andernfalls wurden Punkte vertauscht.
+	var sign float64
+	for i := range RefPointType(4) {
+		c := cross(i, (i+1)%4, (i+2)%4)
+		if sign == 0.0 {
+			sign = c
+		}
+		if c*sign <= 0.0 {
+			return fmt.Errorf("%w: quadrilateral not convex", ErrCalibInvalid)
+		}
+	}
+	return nil
+}
+
+// Zeigt nacheinander die Testpunkte an und vergleicht die Position, welche
+// die neue Abbildung plane liefert, mit der Position des Testpunktes.
+func verifyPlane(dsp *Display, fb *Framebuffer, tch *Touch,
+	plane *DistortedPlane, opts CalibOptions) error {
+	rect := dsp.Bounds()
+	w, h := float64(rect.Dx()), float64(rect.Dy())
+	targets := []TouchPos{
+		{X: w / 2, Y: h / 2},
+		{X: w / 4, Y: 3 * h / 4},
+		{X: 3 * w / 4, Y: h / 4},
+		{X: w / 4, Y: h / 4},
+		{X: 3 * w / 4, Y: 3 * h / 4},
+	}
+	for i := 0; i < min(opts.VerifyPoints, len(targets)); i++ {
+		target := targets[i]
+		clearScreen(dsp, fb)
+		drawCrosshair(fb, target, calibVerifyColor)
+		dsp.Flush()
+		rawPos, _, err := collectSamples(tch, opts)
+		if err != nil {
+			return err
+		}
+		pos, _ := plane.Transform(rawPos)
+		if d := math.Hypot(pos.X-target.X, pos.Y-target.Y); d > opts.MaxError {
+			clearScreen(dsp, fb)
+			return fmt.Errorf("%w: target %v, got %v (%.1f px)",
+				ErrCalibVerify, target, pos, d)
+		}
+	}
+	clearScreen(dsp, fb)
+	return nil
+}
+
+func clearScreen(dsp *Display, fb *Framebuffer) {
+	fb.Fill(fb.Bounds(), calibBackColor)
+	dsp.Flush()
+}
+
+// Zeichnet ein Fadenkreuz mit einem kleinen Quadrat in der Mitte.
+func drawCrosshair(fb *Framebuffer, pos TouchPos, c color.Color) {
+	const size, box = 10, 3
+	x, y := int(math.Round(pos.X)), int(math.Round(pos.Y))
+	fb.Fill(image.Rect(x-size, y, x+size+1, y+1), c)
+	fb.Fill(image.Rect(x, y-size, x+1, y+size+1), c)
+	fb.Fill(image.Rect(x-box, y-box, x+box+1, y-box+1), c)
+	fb.Fill(image.Rect(x-box, y+box, x+box+1, y+box+1), c)
+	fb.Fill(image.Rect(x-box, y-box, x-box+1, y+box+1), c)
+	fb.Fill(image.Rect(x+box, y-box, x+box+1, y+box+1), c)
+}
@@ -0,0 +1,272 @@
+package adatft
+
+import (
+	"errors"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	calibRawList = []TouchRawPos{
+		{RawX: 3700, RawY: 400}, {RawX: 3650, RawY: 3600},
+		{RawX: 500, RawY: 3650}, {RawX: 450, RawY: 350},
+	}
+)
+
+// Liefert die Rohdaten, welche ein (leicht verdrehter) Touchscreen an der
+// Position pos (Rotate000, 240x320 Pixel) liefern wuerde.
+func calibTestRaw(pos TouchPos) TouchRawPos {
+	return TouchRawPos{
+		RawX: uint16(math.Round(3800.0 - 14.0*pos.X + 0.5*pos.Y)),
+		RawY: uint16(math.Round(300.0 + 0.4*pos.X + 10.5*pos.Y)),
+	}
+}
+
+// Die Kalibrierung muss in jeder Rotation und mit jedem Modell geschrieben
+// und wieder gelesen werden koennen, ohne dass sich die Abbildung
+// veraendert. Ausserdem muss die Abbildung in jeder Rotation die
+// Referenzpunkte treffen (das lineare Modell nur ungefaehr, da es die
+// Verdrehung des Touchscreens nicht beruecksichtigt).
+func TestCalibWriteRead(t *testing.T) {
+	rect := image.Rect(0, 0, 240, 320)
+	for rot := Rotate000; rot <= Rotate270; rot++ {
+		r := rect
+		if rot == Rotate090 || rot == Rotate270 {
+			r = image.Rect(0, 0, rect.Dy(), rect.Dx())
+		}
+		for cm := CalibLinear; cm < NumCalibModels; cm++ {
+			plane := &DistortedPlane{Rot: rot, Model: cm,
+				Width: rect.Dx(), Height: rect.Dy()}
+			ids, _ := RefPoints(9)
+			for _, id := range ids {
+				pos := plane.ToNative(refPointPos(id, r, 20))
+				plane.SetRefPoint(id, calibTestRaw(pos), pos)
+			}
+			if err := plane.Compute(); err != nil {
+				t.Fatalf("%v/%v: %v", rot, cm, err)
+			}
+			fileName := filepath.Join(t.TempDir(), calibDataFile)
+			if err := plane.WriteConfigFile(fileName); err != nil {
+				t.Fatal(err)
+			}
+
+			tol := 1.0
+			if cm == CalibLinear {
+				tol = 8.0
+			}
+			read := &DistortedPlane{}
+			read.ReadConfigFile(fileName, rot)
+			for _, id := range ids {
+				want := refPointPos(id, r, 20)
+				pos, err := read.Transform(calibTestRaw(read.ToNative(want)))
+				if err != nil {
+					t.Fatalf("%v/%v: %v", rot, cm, err)
+				}
+				if d := math.Hypot(pos.X-want.X, pos.Y-want.Y); d > tol {
+					t.Errorf("%v/%v, %v: got (%.1f, %.1f), want (%.1f, %.1f)",
+						rot, cm, id, pos.X, pos.Y, want.X, want.Y)
+				}
+			}
+		}
+	}
+}
+
+// Kalibrierungsfiles aelterer Versionen (vier Punkte, ohne Modell und
+// Koeffizienten) muessen weiterhin gelesen werden koennen.
+func TestCalibReadLegacy(t *testing.T) {
+	const legacy = `{
+  "RawPosList": [
+    {"RawX": 3700, "RawY": 400, "RawZ": 0},
+    {"RawX": 500, "RawY": 400, "RawZ": 0},
+    {"RawX": 500, "RawY": 3600, "RawZ": 0},
+    {"RawX": 3700, "RawY": 3600, "RawZ": 0}
+  ],
+  "PosList": [
+    {"X": 20, "Y": 20, "Z": 0},
+    {"X": 219, "Y": 20, "Z": 0},
+    {"X": 219, "Y": 299, "Z": 0},
+    {"X": 20, "Y": 299, "Z": 0}
+  ]
+}`
+	fileName := filepath.Join(t.TempDir(), calibDataFile)
+	if err := os.WriteFile(fileName, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plane := &DistortedPlane{}
+	plane.ReadConfigFile(fileName, Rotate000)
+	if plane.Model != CalibLinear || plane.Width != 240 ||
+		plane.Height != 320 || len(plane.RefPoints) != 4 {
+		t.Fatalf("read %+v", plane)
+	}
+	pos, _ := plane.Transform(TouchRawPos{RawX: 500, RawY: 3600})
+	if math.Abs(pos.X-219) > 1e-6 || math.Abs(pos.Y-299) > 1e-6 {
+		t.Errorf("got (%.1f, %.1f), want (219, 299)", pos.X, pos.Y)
+	}
+}
+
+// Wie in frueheren Versionen werden nur drei Ecken verwendet; eine
+// abweichende vierte Ecke (RefBottomRight) veraendert die Abbildung nicht.
+func TestCalibReadLegacyThreePoints(t *testing.T) {
+	const legacy = `{
+  "RawPosList": [
+    {"RawX": 3700, "RawY": 400, "RawZ": 0},
+    {"RawX": 500, "RawY": 400, "RawZ": 0},
+    {"RawX": 560, "RawY": 3500, "RawZ": 0},
+    {"RawX": 3700, "RawY": 3600, "RawZ": 0}
+  ],
+  "PosList": [
+    {"X": 20, "Y": 20, "Z": 0},
+    {"X": 219, "Y": 20, "Z": 0},
+    {"X": 219, "Y": 299, "Z": 0},
+    {"X": 20, "Y": 299, "Z": 0}
+  ]
+}`
+	fileName := filepath.Join(t.TempDir(), calibDataFile)
+	if err := os.WriteFile(fileName, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plane := &DistortedPlane{}
+	if err := plane.LoadConfigFile(fileName, Rotate000); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		raw  TouchRawPos
+		x, y float64
+	}{
+		{TouchRawPos{RawX: 3700, RawY: 400}, 20, 20},
+		{TouchRawPos{RawX: 500, RawY: 400}, 219, 20},
+		{TouchRawPos{RawX: 3700, RawY: 3600}, 20, 299},
+		{TouchRawPos{RawX: 2100, RawY: 2000}, 119.5, 159.5},
+	} {
+		pos, _ := plane.Transform(tc.raw)
+		if math.Abs(pos.X-tc.x) > 1e-6 || math.Abs(pos.Y-tc.y) > 1e-6 {
+			t.Errorf("%v: got (%.2f, %.2f), want (%.2f, %.2f)", tc.raw,
+				pos.X, pos.Y, tc.x, tc.y)
+		}
+	}
+}
+
+func TestCheckRefPoints(t *testing.T) {
+	ids, _ := RefPoints(4)
+	if err := checkRefPoints(ids, calibRawList, 0.15); err != nil {
+		t.Errorf("valid points rejected: %v", err)
+	}
+	swapped := append([]TouchRawPos(nil), calibRawList...)
+	swapped[RefTopRight], swapped[RefBottomRight] =
+		swapped[RefBottomRight], swapped[RefTopRight]
+	if err := checkRefPoints(ids, swapped, 0.15); !errors.Is(err,
+		ErrCalibInvalid) {
+		t.Errorf("swapped points accepted")
+	}
+	degenerate := append([]TouchRawPos(nil), calibRawList...)
+	degenerate[RefTopRight] = degenerate[RefTopLeft]
+	if err := checkRefPoints(ids, degenerate, 0.15); !errors.Is(err,
+		ErrCalibInvalid) {
+		t.Errorf("degenerate points accepted")
+	}
+	ids, _ = RefPoints(3)
+	if err := checkRefPoints(ids, calibRawList[:3], 0.15); err != nil {
+		t.Errorf("valid triangle rejected: %v", err)
+	}
+	line := []TouchRawPos{{100, 100, 0}, {2000, 2000, 0}, {3900, 3900, 0}}
+	if err := checkRefPoints(ids, line, 0.15); !errors.Is(err,
+		ErrCalibInvalid) {
+		t.Errorf("collinear points accepted")
+	}
+}
+
+// Fehlerhafte oder entartete Kalibrierungsdaten muessen beim Lesen erkannt
+// werden.
+func TestCalibValidate(t *testing.T) {
+	valid := func() *CalibData {
+		return &CalibData{
+			Version: CalibVersion, Model: CalibAffine,
+			Width: 240, Height: 320,
+			RefPoints:  []RefPointType{RefTopLeft, RefTopRight, RefBottomRight},
+			RawPosList: calibRawList[:3],
+			PosList:    []TouchPos{{X: 20, Y: 20}, {X: 219, Y: 20}, {X: 219, Y: 299}},
+		}
+	}
+	testList := []struct {
+		name   string
+		modify func(d *CalibData)
+		err    error
+	}{
+		{"valid", func(d *CalibData) {}, nil},
+		{"version", func(d *CalibData) { d.Version = CalibVersion + 1 },
+			ErrCalibVersion},
+		{"model", func(d *CalibData) { d.Model = NumCalibModels }, ErrCalibModel},
+		{"length", func(d *CalibData) { d.PosList = d.PosList[:2] }, ErrCalibData},
+		{"points", func(d *CalibData) { d.Model = CalibPerspective },
+			ErrCalibPoints},
+		{"size", func(d *CalibData) { d.Width = 0 }, ErrCalibData},
+		{"duplicate", func(d *CalibData) { d.RefPoints[2] = RefTopLeft },
+			ErrCalibData},
+		{"outside", func(d *CalibData) { d.PosList[1].X = 240 }, ErrCalibData},
+		{"coeff", func(d *CalibData) { d.Coeff = []float64{1, 2, 3} },
+			ErrCalibData},
+		{"degenerate", func(d *CalibData) {
+			d.RawPosList = []TouchRawPos{{100, 100, 0}, {200, 200, 0},
+				{300, 300, 0}}
+		}, ErrCalibDegenerate},
+	}
+	for _, test := range testList {
+		d := valid()
+		test.modify(d)
+		err := d.Validate()
+		if (test.err == nil && err != nil) ||
+			(test.err != nil && !errors.Is(err, test.err)) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// LoadConfigFile darf die Abbildung bei einem Fehler nicht veraendern.
+	fileName := filepath.Join(t.TempDir(), calibDataFile)
+	if err := os.WriteFile(fileName, []byte(`{"Version": 99}`),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	plane := &DistortedPlane{Model: CalibAffine}
+	if err := plane.LoadConfigFile(fileName, Rotate000); !errors.Is(err,
+		ErrCalibVersion) || plane.Model != CalibAffine {
+		t.Errorf("got %v, plane %+v", err, plane)
+	}
+}
+
+func TestCalibFileName(t *testing.T) {
+	id := DeviceID{Board: "10000000 a1b2c3d4", Controller: "STMPE610-0811-03"}
+	want := "TouchCalib-10000000_a1b2c3d4_STMPE610-0811-03.json"
+	if got := filepath.Base(CalibFileName(id)); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := filepath.Base(CalibFileName(DeviceID{})); got != calibDataFile {
+		t.Errorf("got %s, want %s", got, calibDataFile)
+	}
+}
+
+// Kann das File nicht geschrieben werden, muss WriteConfigFile einen Fehler
+// liefern (und darf das Programm nicht abbrechen).
+func TestCalibWriteError(t *testing.T) {
+	plane := &DistortedPlane{}
+	fileName := filepath.Join(t.TempDir(), "missing", calibDataFile)
+	if err := plane.WriteConfigFile(fileName); err == nil {
+		t.Errorf("writing to %s: expected an error", fileName)
+	}
+}
+
+// NumRefPoints muss wie in frueheren Versionen die Anzahl der Ecken
+// bezeichnen; alle Referenzpunkte bis MaxRefPoints haben einen Namen.
+func TestRefPointNames(t *testing.T) {
+	if NumRefPoints != 4 {
+		t.Errorf("NumRefPoints: got %d, want 4", NumRefPoints)
+	}
+	for id := RefTopLeft; id < MaxRefPoints; id++ {
+		var got RefPointType
+		if err := got.Set(id.String()); err != nil || got != id {
+			t.Errorf("%v: got %v, %v", id, got, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package adatft
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Liefert das Kalibrierungsfile dieses Touchscreens: bevorzugt wird das
+// File des Geraetes (siehe CalibFileName), danach das
+// geraeteunabhaengige File.
+func (tch *Touch) calibFileName() string {
+	fileName := CalibFileName(tch.device)
+	if _, err := os.Stat(fileName); err != nil {
+		fileName = CalibFileName(DeviceID{})
+	}
+	return fileName
+}
+
+// Liest die Kalibrierung erneut aus dem Kalibrierungsfile (siehe
+// OpenTouch) und verwendet sie ab sofort fuer alle Events. Damit kann
+// eine laufende Applikation eine Kalibrierung uebernehmen, welche von
+// einem anderen Programm (z.B. tftcalib) erstellt wurde. Bei einem Fehler
+// bleibt die bisherige Kalibrierung in Gebrauch.
+func (tch *Touch) ReloadCalibration() error {
+	plane := tch.Plane()
+	fileName := tch.calibFileName()
+	if err := plane.LoadConfigFile(fileName, plane.Rot); err != nil {
+		return err
+	}
+	if plane.Device != (DeviceID{}) && plane.Device != tch.device {
+		log.Printf("Calibration data in %s is for device %v, not %v",
+			fileName, plane.Device, tch.device)
+	}
+	plane.Device = tch.device
+	tc := tch.Config()
+	plane.setZFormat(tc.FractionZ, tc.rawZMax())
+	plane.setXYFormat(tc.ADCBits)
+	tch.setPlane(plane)
+	return nil
+}
+
+// Prueft im Abstand von interval, ob das Kalibrierungsfile geaendert (oder
+// neu erstellt) wurde, und laedt die Kalibrierung in diesem Fall neu
+// (siehe ReloadCalibration). Eine laufende Ueberwachung wird dabei ersetzt;
+// mit interval 0 wird sie beendet.
+func (tch *Touch) WatchCalibration(interval time.Duration) {
+	tch.planeMutex.Lock()
+	defer tch.planeMutex.Unlock()
+	if tch.watchStop != nil {
+		close(tch.watchStop)
+		tch.watchStop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	tch.watchStop = make(chan struct{})
+	go tch.watchCalibration(interval, tch.watchStop)
+}
+
+// Merkmale eines Files, an welchen eine Aenderung erkannt wird.
+type calibFileState struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+func (tch *Touch) calibFileState() calibFileState {
+	state := calibFileState{name: tch.calibFileName()}
+	if fi, err := os.Stat(state.name); err == nil {
+		state.modTime, state.size = fi.ModTime(), fi.Size()
+	}
+	return state
+}
+
+func (tch *Touch) watchCalibration(interval time.Duration,
+	stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := tch.calibFileState()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			state := tch.calibFileState()
+			if state == last || state.modTime.IsZero() {
+				continue
+			}
+			last = state
+			if err := tch.ReloadCalibration(); err != nil {
+				log.Printf("Couldn't reload calibration from %s: %v",
+					state.name, err)
+				continue
+			}
+			log.Printf("Calibration reloaded from %s", state.name)
+		}
+	}
+}
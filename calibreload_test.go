@@ -0,0 +1,84 @@
+package adatft
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Verwendet fuer die Dauer des Tests ein leeres Verzeichnis fuer die
+// Konfigurationsfiles.
+func tempConfDir(t *testing.T) {
+	t.Helper()
+	oldConfDir := confDir
+	confDir = t.TempDir()
+	t.Cleanup(func() { confDir = oldConfDir })
+}
+
+// Schreibt eine Kalibrierung, welche den Touchscreen in X-Richtung um den
+// Faktor scale gestaucht abbildet.
+func writeReloadCalib(t *testing.T, tch *Touch, scale float64) {
+	t.Helper()
+	plane := tch.Plane()
+	plane.Model = CalibAffine
+	plane.PosList = append([]TouchPos(nil), plane.PosList...)
+	for i := range plane.PosList {
+		plane.PosList[i].X *= scale
+	}
+	if err := plane.Compute(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plane.WriteConfigFile(CalibFileName(tch.device)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadCalibration(t *testing.T) {
+	tempConfDir(t)
+	tch := newTestTouch(eventQueueSize)
+	raw := TouchRawPos{RawX: 2048, RawY: 2048}
+	before, _ := tch.transform(raw)
+
+	if err := tch.ReloadCalibration(); err == nil {
+		t.Errorf("reload without calibration file succeeded")
+	}
+	writeReloadCalib(t, tch, 1.0)
+	if err := tch.ReloadCalibration(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := tch.transform(raw)
+	if math.Abs(after.X-before.X) > 0.5 || math.Abs(after.Y-before.Y) > 0.5 {
+		t.Errorf("got %v, want %v", after, before)
+	}
+	if p := tch.Plane(); p.Model != CalibAffine || p.Device != tch.device {
+		t.Errorf("model %v, device %v", p.Model, p.Device)
+	}
+}
+
+func TestWatchCalibration(t *testing.T) {
+	tempConfDir(t)
+	tch := newTestTouch(eventQueueSize)
+	raw := TouchRawPos{RawX: 2048, RawY: 2048}
+	before, _ := tch.transform(raw)
+
+	tch.WatchCalibration(5 * time.Millisecond)
+	defer tch.WatchCalibration(0)
+	time.Sleep(20 * time.Millisecond)
+	writeReloadCalib(t, tch, 1.0)
+	// Beim Schreiben der zweiten Kalibrierung muss sich die Zeit oder die
+	// Groesse des Files aendern.
+	time.Sleep(20 * time.Millisecond)
+	writeReloadCalib(t, tch, 0.9)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		pos, _ := tch.transform(raw)
+		if math.Abs(pos.X-0.9*before.X) < 0.5 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	pos, _ := tch.transform(raw)
+	t.Errorf("calibration not reloaded: got %v, want x %.1f", pos,
+		0.9*before.X)
+}
@@ -0,0 +1,116 @@
+// Mit diesem Programm wird der Touchscreen kalibriert. Auf dem Display
+// erscheinen nacheinander Fadenkreuze, welche moeglichst genau gedrueckt
+// werden muessen. Anschliessend werden einige Testpunkte angezeigt, mit
+// welchen die Kalibrierung ueberprueft wird. Ist alles in Ordnung, werden
+// die Daten im Konfigurationsverzeichnis abgelegt (TouchCalib-<Geraet>.json).
+//
+// Das Programm muss mit dem gleichen Build-Tag fuer das Pixelformat
+// erstellt werden wie die Applikationen, z.B.:
+//
+//	go build -tags pixfmt565 ./cmd/tftcalib
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/stefan-muehlebach/adatft"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// Fuehrt die Kalibrierung durch und liefert den Exit-Code des Programms.
+// Im Fehlerfall wird nicht log.Fatalf verwendet, damit Display und
+// Touchscreen in jedem Fall geschlossen werden.
+func run() int {
+	var rot adatft.RotationType = adatft.Rotate000
+	var opts adatft.CalibOptions = adatft.DefaultCalibOptions
+	var pointercal, xorgConf string
+	var pressure bool
+
+	flag.Var(&rot, "rotation", "display rotation (Rotate000, Rotate090, "+
+		"Rotate180, Rotate270)")
+	flag.Var(&opts.Model, "model", "calibration model (Linear, Affine, "+
+		"Bilinear, Perspective)")
+	flag.IntVar(&opts.Points, "points", opts.Points,
+		"number of reference points (3, 4, 5 or 9)")
+	flag.IntVar(&opts.Margin, "margin", opts.Margin,
+		"distance of the reference points from the border (pixel)")
+	flag.IntVar(&opts.VerifyPoints, "verify", opts.VerifyPoints,
+		"number of test points after calibration (0: no verification)")
+	flag.Float64Var(&opts.MaxError, "maxerr", opts.MaxError,
+		"max. allowed error during verification (pixel)")
+	flag.StringVar(&opts.FileName, "file", "",
+		"calibration file (default: per-device file in config dir)")
+	flag.BoolVar(&pressure, "pressure", false,
+		"also calibrate the pressure (light and firm press)")
+	flag.StringVar(&pointercal, "pointercal", "",
+		"also export the calibration as tslib pointercal file")
+	flag.StringVar(&xorgConf, "xorg", "",
+		"also export the calibration as xorg.conf.d snippet")
+	flag.Parse()
+
+	disp := adatft.OpenDisplay(rot)
+	touch := adatft.OpenTouch(rot)
+	defer disp.Close()
+	defer touch.Close()
+
+	plane, err := adatft.Calibrate(disp, touch, opts)
+	if err != nil {
+		log.Printf("Calibration failed: %v", err)
+		return 1
+	}
+	fmt.Printf("Calibration successful (%v, device %v):\n", plane.Model,
+		plane.Device)
+	for i, id := range plane.RefPoints {
+		fmt.Printf("  %-12v: %v -> %v\n", id, plane.RawPosList[i],
+			plane.PosList[i])
+	}
+	fmt.Printf("  Coefficients: %v\n", plane.Coeff)
+	if q, err := plane.Quality(); err == nil {
+		fmt.Printf("  Residuals   : mean %.2f, rms %.2f, max %.2f (%v) pixel\n",
+			q.Mean, q.RMS, q.Max, q.Worst)
+	}
+
+	if pressure {
+		if plane, err = adatft.CalibratePressure(disp, touch,
+			opts); err != nil {
+			log.Printf("Pressure calibration failed: %v", err)
+			return 1
+		}
+		fmt.Printf("  Pressure    : light %d, firm %d (fraction z %d)\n",
+			plane.RawZLight, plane.RawZFirm, plane.FractionZ)
+	}
+
+	if pointercal != "" {
+		if err := plane.WritePointercalFile(pointercal); err != nil {
+			log.Printf("Couldn't write %s: %v", pointercal, err)
+			return 1
+		}
+	}
+	if xorgConf != "" {
+		if err := writeXorgConf(plane, xorgConf); err != nil {
+			log.Printf("Couldn't write %s: %v", xorgConf, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// Schreibt die Kalibrierung plane als xorg.conf.d-Abschnitt in das File
+// fileName.
+func writeXorgConf(plane *adatft.DistortedPlane, fileName string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = plane.WriteXorgConf(fh, "stmpe-ts"); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
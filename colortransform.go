@@ -0,0 +1,144 @@
+package adatft
+
+import (
+	"math"
+)
+
+// Mit einer ColorTransform werden die Farben eines Bildes beim Konvertieren
+// in das Format des TFT korrigiert (z.B. wenn das Display hinter einer
+// getoenten Scheibe montiert ist). Pro Farbkanal wird eine Tabelle mit 256
+// Eintraegen verwendet, welche jeden 8-Bit Wert auf einen neuen Wert
+// abbildet. Ist Gray gesetzt, wird das Bild vor dem Nachschlagen in den
+// Tabellen in Graustufen umgewandelt.
+type ColorTransform struct {
+	R, G, B [256]uint8
+	Gray    bool
+}
+
+// Mit ColorParams koennen die Tabellen einer ColorTransform ueber die
+// gebraeuchlichen Parameter berechnet werden. Der Nullwert jedes Feldes
+// steht fuer "keine Veraenderung".
+type ColorParams struct {
+	// Helligkeit im Bereich [-1, 1]; 0 bedeutet unveraendert.
+	Brightness float64
+	// Kontrast als Faktor; 0 und 1 bedeuten unveraendert.
+	Contrast float64
+	// Gamma-Korrektur; 0 und 1 bedeuten unveraendert. Werte > 1 hellen die
+	// Mitteltoene auf, Werte < 1 dunkeln sie ab.
+	Gamma float64
+	// Farbtemperatur in Kelvin, auf welche der Weisspunkt verschoben werden
+	// soll; 0 und 6500 bedeuten unveraendert.
+	Temperature float64
+	// Zusaetzliche Verstaerkung pro Farbkanal (R, G, B); 0 bedeutet
+	// unveraendert.
+	Gain [3]float64
+	// Bild in Graustufen darstellen.
+	Gray bool
+}
+
+const (
+	// Farbtemperatur, welche als neutral betrachtet wird (D65).
+	neutralTemperature = 6500.0
+)
+
+// Erstellt eine neue ColorTransform, welche die Farben unveraendert laesst.
+func NewColorTransform() *ColorTransform {
+	ct := &ColorTransform{}
+	for i := range 256 {
+		ct.R[i] = uint8(i)
+		ct.G[i] = uint8(i)
+		ct.B[i] = uint8(i)
+	}
+	return ct
+}
+
+// Berechnet die Tabellen einer ColorTransform aus den Parametern in p.
+func NewColorTransformParams(p ColorParams) *ColorTransform {
+	ct := &ColorTransform{Gray: p.Gray}
+	contrast := p.Contrast
+	if contrast == 0.0 {
+		contrast = 1.0
+	}
+	gamma := p.Gamma
+	if gamma == 0.0 {
+		gamma = 1.0
+	}
+	temp := colorTemperature(p.Temperature)
+	luts := [3]*[256]uint8{&ct.R, &ct.G, &ct.B}
+	for ch, lut := range luts {
+		gain := temp[ch]
+		if p.Gain[ch] != 0.0 {
+			gain *= p.Gain[ch]
+		}
+		for i := range 256 {
+			v := float64(i) / 255.0
+			v = (v-0.5)*contrast + 0.5 + p.Brightness
+			v = math.Max(0.0, math.Min(1.0, v))
+			v = math.Pow(v, 1.0/gamma) * gain
+			lut[i] = uint8(math.Round(255.0 * math.Max(0.0, math.Min(1.0, v))))
+		}
+	}
+	return ct
+}
+
+// Nachtmodus: der Blauanteil wird stark reduziert (Farbtemperatur von
+// ca. 2700 K) und das Bild etwas abgedunkelt.
+func NightModeTransform() *ColorTransform {
+	return NewColorTransformParams(ColorParams{
+		Temperature: 2700.0,
+		Brightness:  -0.1,
+	})
+}
+
+// Stellt das Bild in Graustufen dar.
+func GrayscaleTransform() *ColorTransform {
+	return NewColorTransformParams(ColorParams{Gray: true})
+}
+
+// Liefert die Faktoren fuer Rot, Gruen und Blau, mit denen der Weisspunkt
+// von neutralTemperature auf die Farbtemperatur kelvin verschoben wird.
+// Verwendet wird die Naeherung von Tanner Helland, welche fuer den Bereich
+// von 1000 K bis 40000 K brauchbare Werte liefert.
+func colorTemperature(kelvin float64) (f [3]float64) {
+	if kelvin == 0.0 || kelvin == neutralTemperature {
+		return [3]float64{1.0, 1.0, 1.0}
+	}
+	ref := kelvinToRGB(neutralTemperature)
+	val := kelvinToRGB(kelvin)
+	for i := range f {
+		f[i] = val[i] / ref[i]
+	}
+	return f
+}
+
+func kelvinToRGB(kelvin float64) (c [3]float64) {
+	t := math.Max(1000.0, math.Min(40000.0, kelvin)) / 100.0
+	if t <= 66.0 {
+		c[0] = 255.0
+		c[1] = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		c[0] = 329.698727446 * math.Pow(t-60.0, -0.1332047592)
+		c[1] = 288.1221695283 * math.Pow(t-60.0, -0.0755148492)
+	}
+	switch {
+	case t >= 66.0:
+		c[2] = 255.0
+	case t <= 19.0:
+		c[2] = 0.0
+	default:
+		c[2] = 138.5177312231*math.Log(t-10.0) - 305.0447927307
+	}
+	for i := range c {
+		c[i] = math.Max(0.0, math.Min(255.0, c[i])) / 255.0
+	}
+	return c
+}
+
+// Wendet die Transformation auf die Farbwerte r, g und b an.
+func (ct *ColorTransform) apply(r, g, b uint8) (uint8, uint8, uint8) {
+	if ct.Gray {
+		y := uint8((77*uint32(r) + 150*uint32(g) + 29*uint32(b)) >> 8)
+		r, g, b = y, y, y
+	}
+	return ct.R[r], ct.G[g], ct.B[b]
+}
@@ -0,0 +1,77 @@
+package adatft
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestColorTransformIdentity(t *testing.T) {
+	ident := NewColorTransform()
+	params := NewColorTransformParams(ColorParams{})
+	if *ident != *params {
+		t.Errorf("neutral parameters don't yield the identity")
+	}
+}
+
+func TestColorTransformNightMode(t *testing.T) {
+	ct := NightModeTransform()
+	r, g, b := ct.apply(0xff, 0xff, 0xff)
+	t.Logf("white in night mode: (%d, %d, %d)", r, g, b)
+	if !(b < g && g < r) {
+		t.Errorf("night mode should reduce blue most; got (%d, %d, %d)",
+			r, g, b)
+	}
+}
+
+// Die Farbkorrektur muss von Convert angewendet werden, sowohl mit als
+// auch ohne Dithering.
+func TestConvertColorTransform(t *testing.T) {
+	src := uniformRGBA(16, 16, color.RGBA{0xc0, 0x40, 0x80, 0xff})
+	for _, mode := range []DitherType{DitherNone, DitherBayer4} {
+		img := NewILIImage(src.Rect)
+		img.conv = &convSettings{}
+		img.conv.update(func(cp *convParams) {
+			cp.ct = GrayscaleTransform()
+			cp.setDither(mode, false)
+		})
+		img.Convert(src)
+		r, g, b, _ := img.ILIColorAt(3, 3).RGBA()
+		r, g, b = r>>8, g>>8, b>>8
+		t.Logf("%v: (%d, %d, %d)", mode, r, g, b)
+		if max(r, g, b)-min(r, g, b) > 8 {
+			t.Errorf("%v: expected gray, got (%d, %d, %d)", mode, r, g, b)
+		}
+	}
+}
+
+// Die Farbkorrektur darf waehrend laufender Konvertierungen gewechselt
+// werden, ohne dass ein Bild mit einer halb kopierten Tabelle entsteht
+// (mit -race pruefen).
+func TestSetColorTransformConcurrent(t *testing.T) {
+	src := uniformRGBA(16, 16, color.RGBA{0xc0, 0x40, 0x80, 0xff})
+	dsp := &Display{conv: &convSettings{}}
+	img := NewILIImage(src.Rect)
+	img.conv = dsp.conv
+	gray := GrayscaleTransform()
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				dsp.SetColorTransform(gray)
+			} else {
+				dsp.SetColorTransform(nil)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		img.Convert(src)
+		r0, g0, b0, _ := img.ILIColorAt(0, 0).RGBA()
+		r1, g1, b1, _ := img.ILIColorAt(15, 15).RGBA()
+		if r0 != r1 || g0 != g1 || b0 != b1 {
+			t.Fatalf("conversion mixed two color transforms")
+		}
+	}
+	<-done
+}
@@ -3,6 +3,8 @@ package adatft
 import (
 	"errors"
 	"image"
+	"sync"
+	"time"
 
 	"periph.io/x/conn/v3/physic"
 
@@ -46,6 +48,12 @@ type Display struct {
 	syncImg, activeImg *ILIImage
 	quitQ              chan bool
 	rect               image.Rectangle
+	conv               *convSettings
+	fb                 *Framebuffer
+	// Schuetzt activeImg (das Abbild des aktuellen Bildschirminhalts) und
+	// die SPI-Verbindung vor gleichzeitigen Zugriffen durch den Displayer
+	// und die synchronen Draw-Methoden.
+	mutex sync.Mutex
 }
 
 // OpenDisplay initialisiert die Hardware, damit ein Zeichnen auf dem TFT
@@ -68,12 +76,16 @@ func OpenDisplay(rot RotationType) *Display {
 	}
 
 	dsp.rect = image.Rect(0, 0, Width, Height)
+	dsp.conv = &convSettings{}
 	for i := 0; i < numBuffers; i++ {
 		img := NewILIImage(dsp.rect)
+		img.conv = dsp.conv
 		dsp.imgChan[toConv] <- img
 	}
 	dsp.syncImg = NewILIImage(dsp.rect)
+	dsp.syncImg.conv = dsp.conv
 	dsp.activeImg = NewILIImage(dsp.rect)
+	dsp.activeImg.conv = dsp.conv
 	dsp.sendImage(dsp.activeImg)
 
 	dsp.quitQ = make(chan bool)
@@ -99,25 +111,116 @@ func (dsp *Display) Bounds() image.Rectangle {
 	return dsp.rect
 }
 
+// Mit SetDither wird das Verfahren bestimmt, mit welchem die Bilder beim
+// Konvertieren in das Format des TFT gerastert werden. Mit stable=true wird
+// die Fehlerdiffusion (DitherFloydSteinberg) auf kleine Kacheln beschraenkt,
+// so dass sich bei Animationen die statischen Bereiche nicht veraendern
+// (und damit auch nicht neu zum Display gesendet werden muessen). Die
+// geordneten Verfahren (DitherBayer4, DitherBayer8) sind immer stabil.
+// Die Einstellung gilt fuer alle nachfolgenden Aufrufe von Draw und DrawSync.
+// SetDither kann auch waehrend laufender Konvertierungen aufgerufen werden.
+func (dsp *Display) SetDither(mode DitherType, stable bool) {
+	dsp.conv.update(func(cp *convParams) {
+		cp.setDither(mode, stable)
+	})
+}
+
+// Hinterlegt eine Farbkorrektur, welche bei allen nachfolgenden Aufrufen von
+// Draw und DrawSync waehrend der Konvertierung angewendet wird (d.h. ohne
+// zusaetzlichen Durchgang ueber das Bild). Die Tabellen werden kopiert,
+// spaetere Aenderungen an ct haben also keinen Einfluss. Mit ct=nil wird
+// die Farbkorrektur wieder ausgeschaltet. Wie SetDither kann auch diese
+// Methode waehrend laufender Konvertierungen aufgerufen werden; ein Bild
+// wird immer vollstaendig mit der alten oder der neuen Korrektur
+// konvertiert.
+func (dsp *Display) SetColorTransform(ct *ColorTransform) {
+	dsp.conv.update(func(cp *convParams) {
+		if ct == nil {
+			cp.ct = nil
+			return
+		}
+		ctCopy := *ct
+		cp.ct = &ctCopy
+	})
+}
+
 // Damit wird das Bild img auf dem Bildschirm dargestellt. Die Darstellung
 // erfolgt synchron, d.h. die Methode wartet so lange, bis alle Bilddaten
 // zum TFT gesendet wurden. Wichtig: img muss ein image.RGBA-Typ sein!
 func (dsp *Display) DrawSync(img image.Image) error {
+	dsp.mutex.Lock()
+	defer dsp.mutex.Unlock()
+	defer latency.submit()()
 	dsp.syncImg.Convert(img.(*image.RGBA))
 	rect := dsp.activeImg.Diff(dsp.syncImg)
 	dsp.sendImage(dsp.syncImg.SubImage(rect).(*ILIImage))
 	dsp.activeImg, dsp.syncImg = dsp.syncImg, dsp.activeImg
+	dsp.syncFramebuffer(rect)
+	return nil
+}
+
+// Stellt das bereits konvertierte Bild img so auf dem Bildschirm dar, dass
+// die linke obere Ecke von img bei at zu liegen kommt. Es findet weder eine
+// Konvertierung noch eine Differenzbildung statt - die Bilddaten werden
+// direkt in das entsprechende Fenster des TFT gesendet. Damit eignet sich
+// diese Methode fuer vorgaengig konvertierte Icons oder zwischengespeicherte
+// Bildschirme. Die Darstellung erfolgt synchron. Ein allfaelliger
+// Framebuffer wird im betroffenen Bereich nachgefuehrt (siehe Framebuffer).
+func (dsp *Display) DrawILI(img *ILIImage, at image.Point) error {
+	defer latency.submit()()
+	dstRect := img.Rect.Sub(img.Rect.Min).Add(at).Intersect(dsp.rect)
+	if dstRect.Empty() {
+		return nil
+	}
+	srcRect := dstRect.Sub(at).Add(img.Rect.Min)
+	srcImg := img.SubImage(srcRect).(*ILIImage)
+	blitImg := &ILIImage{
+		Rect:   dstRect,
+		Stride: srcImg.Stride,
+		Pix:    srcImg.Pix,
+	}
+
+	dsp.mutex.Lock()
+	defer dsp.mutex.Unlock()
+	dsp.activeImg.copyRect(dstRect, srcImg, srcRect.Min)
+	dsp.sendImage(blitImg)
+	dsp.syncFramebuffer(dstRect)
+	return nil
+}
+
+// Stellt den Ausschnitt r des Bildes img auf dem Bildschirm dar. Die
+// Applikation gibt mit r selber an, welcher Bereich sich veraendert hat,
+// daher wird nur dieser Bereich konvertiert und ohne Differenzbildung zum
+// TFT gesendet. Die Darstellung erfolgt synchron und ein allfaelliger
+// Framebuffer wird im Bereich r nachgefuehrt. Wichtig: img muss ein
+// image.RGBA-Typ sein!
+func (dsp *Display) DrawRegion(img image.Image, r image.Rectangle) error {
+	defer latency.submit()()
+	src := img.(*image.RGBA)
+	r = r.Intersect(src.Rect).Intersect(dsp.rect)
+	if r.Empty() {
+		return nil
+	}
+
+	dsp.mutex.Lock()
+	defer dsp.mutex.Unlock()
+	dsp.activeImg.Convert(src.SubImage(r).(*image.RGBA))
+	dsp.sendImage(dsp.activeImg.SubImage(r).(*ILIImage))
+	dsp.syncFramebuffer(r)
 	return nil
 }
 
 // Damit wird das Bild img auf dem Bildschirm dargestellt. Die Darstellung
 // erfolgt asynchron, d.h. die Methode wartet nur, bis das Bild konvertiert
-// wurde. Wichtig: img muss ein image.RGBA-Typ sein!
+// wurde. Wichtig: img muss ein image.RGBA-Typ sein! Da die Darstellung
+// im Hintergrund erfolgt, wird ein allfaelliger Framebuffer nicht
+// nachgefuehrt; Draw und Framebuffer sollten daher nicht gemischt werden.
 func (dsp *Display) Draw(img image.Image) error {
 	var iliImg *ILIImage
 
 	iliImg = <-dsp.imgChan[toConv]
 	iliImg.Convert(img.(*image.RGBA))
+	iliImg.traces = latency.take()
 	dsp.imgChan[toDisp] <- iliImg
 	return nil
 }
@@ -163,11 +266,18 @@ func (dsp *Display) displayer() {
 		if img, ok = <-dsp.imgChan[toDisp]; !ok {
 			break
 		}
+		dsp.mutex.Lock()
+		// Ein Bild ohne Differenz ist bereits dargestellt; die Messungen
+		// der Latenz werden trotzdem abgeschlossen.
+		traces := img.traces
+		img.traces = nil
 		rect = dsp.activeImg.Diff(img)
 		if !rect.Empty() {
 			dsp.sendImage(img.SubImage(rect).(*ILIImage))
 			dsp.activeImg, img = img, dsp.activeImg
 		}
+		latency.displayed(traces, time.Now())
+		dsp.mutex.Unlock()
 		dsp.imgChan[toConv] <- img
 	}
 	close(dsp.imgChan[toConv])
@@ -0,0 +1,93 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Erstellt ein Display ohne Hardware (siehe nullDisp) mit schwarzem
+// Bildschirminhalt.
+func newNullDisplay() *Display {
+	rect := image.Rect(0, 0, 320, 240)
+	return &Display{dspi: nullDisp{}, rect: rect,
+		activeImg: NewILIImage(rect), syncImg: NewILIImage(rect)}
+}
+
+// Prueft, ob der Bildschirminhalt und der Framebuffer von dsp im Bereich r
+// die Farbe in und ausserhalb davon die Farbe out aufweisen.
+func checkScreen(t *testing.T, dsp *Display, r image.Rectangle,
+	in, out color.Color) {
+	t.Helper()
+	for y := dsp.rect.Min.Y; y < dsp.rect.Max.Y; y++ {
+		for x := dsp.rect.Min.X; x < dsp.rect.Max.X; x++ {
+			want := ILIModel.Convert(out)
+			if image.Pt(x, y).In(r) {
+				want = ILIModel.Convert(in)
+			}
+			if got := dsp.activeImg.ILIColorAt(x, y); got != want {
+				t.Fatalf("screen at (%d,%d): got %v, want %v", x, y, got,
+					want)
+			}
+			if got := dsp.fb.img.ILIColorAt(x, y); got != want {
+				t.Fatalf("framebuffer at (%d,%d): got %v, want %v", x, y,
+					got, want)
+			}
+		}
+	}
+}
+
+func TestDrawILI(t *testing.T) {
+	dsp := newNullDisplay()
+	fb := dsp.Framebuffer()
+	red := color.RGBA{0xff, 0, 0, 0xff}
+
+	// Ein Ausschnitt eines groesseren Bildes (Rect.Min ungleich (0,0)).
+	icons := NewILIImage(image.Rect(0, 0, 40, 20))
+	icons.Fill(image.Rect(20, 0, 40, 20), red)
+	icon := icons.SubImage(image.Rect(20, 0, 40, 20)).(*ILIImage)
+	if err := dsp.DrawILI(icon, image.Pt(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	checkScreen(t, dsp, image.Rect(100, 50, 120, 70), red, color.Black)
+
+	// Am Rand des Bildschirms wird das Bild abgeschnitten.
+	if err := dsp.DrawILI(icon, image.Pt(310, 230)); err != nil {
+		t.Fatal(err)
+	}
+	if got := dsp.activeImg.ILIColorAt(315, 235); got != ILIModel.Convert(red) {
+		t.Errorf("clipped icon: got %v", got)
+	}
+	if got := fb.img.ILIColorAt(315, 235); got != ILIModel.Convert(red) {
+		t.Errorf("clipped icon in framebuffer: got %v", got)
+	}
+	if len(fb.Dirty()) != 0 {
+		t.Errorf("dirty after DrawILI: %v", fb.Dirty())
+	}
+}
+
+func TestDrawRegion(t *testing.T) {
+	dsp := newNullDisplay()
+	fb := dsp.Framebuffer()
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+
+	// Nur der Bereich r wird uebernommen, auch wenn img groesser ist.
+	src := uniformRGBA(320, 240, blue)
+	r := image.Rect(30, 40, 130, 90)
+	if err := dsp.DrawRegion(src, r); err != nil {
+		t.Fatal(err)
+	}
+	checkScreen(t, dsp, r, blue, color.Black)
+	if len(fb.Dirty()) != 0 {
+		t.Errorf("dirty after DrawRegion: %v", fb.Dirty())
+	}
+}
+
+func BenchmarkDrawILI(b *testing.B) {
+	dsp := newNullDisplay()
+	img := NewILIImage(dsp.rect)
+	img.Convert(uniformRGBA(320, 240, color.RGBA{0x80, 0x40, 0x20, 0xff}))
+	for b.Loop() {
+		dsp.DrawILI(img, image.Point{0, 0})
+	}
+}
@@ -2,11 +2,28 @@ package adatft
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 )
 
+const (
+	// Groesster Wert, welchen der Touchscreen fuer RawX und RawY liefert
+	// (12 Bit).
+	touchRawMax = 1<<12 - 1
+)
+
+// Die Referenzpunkte fuer die Kalibrierung. Die ersten vier Punkte liegen
+// in den Ecken, dann folgen die Mitte des Bildschirms und die Mitten der
+// vier Seiten. Fuer eine Kalibrierung mit n Punkten werden immer die ersten
+// n Punkte dieser Liste verwendet (siehe RefPoints).
+//
+// NumRefPoints bezeichnet wie in frueheren Versionen die Anzahl der
+// Eckpunkte; die Anzahl aller Referenzpunkte ist MaxRefPoints.
 type RefPointType uint8
 
 const (
@@ -14,7 +31,16 @@ const (
 	RefTopRight
 	RefBottomRight
 	RefBottomLeft
-	NumRefPoints
+	RefCenter
+	RefTopCenter
+	RefRightCenter
+	RefBottomCenter
+	RefLeftCenter
+	MaxRefPoints
+)
+
+const (
+	NumRefPoints = RefCenter
 )
 
 func (pt RefPointType) String() string {
@@ -27,39 +53,54 @@ func (pt RefPointType) String() string {
 		return "BottomRight"
 	case RefBottomLeft:
 		return "BottomLeft"
+	case RefCenter:
+		return "Center"
+	case RefTopCenter:
+		return "TopCenter"
+	case RefRightCenter:
+		return "RightCenter"
+	case RefBottomCenter:
+		return "BottomCenter"
+	case RefLeftCenter:
+		return "LeftCenter"
 	}
 	return "(unknow reference point)"
 }
 
-var (
-	calibDataFile = "TouchCalib.json"
-)
-
-type CalibData struct {
-	RawPosList [NumRefPoints]TouchRawPos
-	PosList    [NumRefPoints]TouchPos
+func (pt *RefPointType) Set(s string) error {
+	for id := RefTopLeft; id < MaxRefPoints; id++ {
+		if id.String() == s {
+			*pt = id
+			return nil
+		}
+	}
+	return errors.New("Unknown reference point: " + s)
 }
 
-// Liest die Konfiguration aus dem Default-File.
-func ReadCalibData() *CalibData {
-	fileName := filepath.Join(confDir, calibDataFile)
-	return ReadCalibDataFile(fileName)
+// Die Referenzpunkte werden im Kalibrierungsfile mit ihrem Namen abgelegt.
+func (pt RefPointType) MarshalText() ([]byte, error) {
+	return []byte(pt.String()), nil
 }
 
-// Liest die Konfiguration aus dem angegebenen File. Der Pfad kann absolut
-// oder relativ angegeben werden. Als Dateiformat wird JSON verwendet.
-func ReadCalibDataFile(fileName string) *CalibData {
-	var data []byte
-	var err error
+func (pt *RefPointType) UnmarshalText(text []byte) error {
+	return pt.Set(string(text))
+}
 
-	d := &CalibData{}
-	if data, err = os.ReadFile(fileName); err != nil {
-		log.Fatalf("Couldn't read %s: %v", fileName, err)
+// Liefert die Referenzpunkte fuer eine Kalibrierung mit n Punkten. Unterstuetzt
+// werden 3 (drei Ecken), 4 (alle Ecken), 5 (Ecken und Mitte) und 9 Punkte
+// (zusaetzlich die Mitten der Seiten).
+func RefPoints(n int) ([]RefPointType, error) {
+	switch n {
+	case 3, 4, 5, 9:
+	default:
+		return nil, fmt.Errorf("%w: %d reference points not supported",
+			ErrCalibPoints, n)
 	}
-	if err = json.Unmarshal(data, d); err != nil {
-		log.Fatalf("Couldn't unmarshal calibration file: %v", err)
+	ids := make([]RefPointType, n)
+	for i := range ids {
+		ids[i] = RefPointType(i)
 	}
-	return d
+	return ids, nil
 }
 
 // Der Touchscreen hat ein eigenes Koordinatensystem, welches mit den Pixel-
@@ -69,28 +110,74 @@ func ReadCalibDataFile(fileName string) *CalibData {
 // die jeweiligen Koordinaten-Achsen müssen nicht parallel zueinander sein.
 //
 // Für die Konvertierung der Touchscreen-Koordinaten in Bildschirm-Koordinaten
-// wird der Datentyp DistortedPlane verwendet.
+// wird der Datentyp DistortedPlane verwendet. Die Abbildung (siehe
+// CalibModel) wird immer fuer den Bildschirm in der Rotation Rotate000
+// berechnet; die Positionen in PosList beziehen sich daher auf dieses
+// Koordinatensystem (der Groesse Width x Height). Erst das Resultat wird
+// gemaess Rot gedreht.
+//
+// Das i-te Element von RawPosList und PosList gehoert zum Referenzpunkt
+// RefPoints[i].
 type DistortedPlane struct {
-	Rot					   RotationType
-	RawPosList             [NumRefPoints]TouchRawPos
-	PosList                [NumRefPoints]TouchPos
-	RawZmin, RawZmax       uint8
-	Zmin, Zmax             float64
-//	m1, m2, n1, n2, o1, o2 float64
-//	ax, ay                 float64
+	Rot              RotationType
+	Device           DeviceID
+	Model            CalibModel
+	Width, Height    int
+	RefPoints        []RefPointType
+	RawPosList       []TouchRawPos
+	PosList          []TouchPos
+	Coeff            []float64
+	ADCBits          int
+	RawZmin, RawZmax uint8
+	Zmin, Zmax       float64
+	// Kalibrierung des Drucks (siehe CalibratePressure).
+	RawZLight, RawZFirm uint8
+	FractionZ           int
+}
+
+// Schreibt die aktuelle Konfiguration in das Default-File.
+func (d *DistortedPlane) WriteConfig() error {
+	fileName := filepath.Join(confDir, calibDataFile)
+	return d.WriteConfigFile(fileName)
 }
 
 // Schreibt die aktuelle Konfiguration in das angegebene File. Der Pfad kann
 // absolut oder relativ angegeben werden. Als Dateiformat wird JSON verwendet.
-func (d *DistortedPlane) WriteConfigFile(fileName string) {
-	data, err := json.MarshalIndent(d, "", "  ")
-	if err != nil {
-		log.Fatal(err)
+// Kann das File nicht geschrieben werden, bleibt ein bestehendes File
+// unveraendert und der Fehler wird retourniert.
+func (d *DistortedPlane) WriteConfigFile(fileName string) error {
+	calibData := &CalibData{
+		Version:    CalibVersion,
+		Device:     d.Device,
+		Model:      d.Model,
+		Width:      d.Width,
+		Height:     d.Height,
+		RefPoints:  d.RefPoints,
+		RawPosList: d.RawPosList,
+		PosList:    d.PosList,
+		Coeff:      d.Coeff,
+		ADCBits:    d.ADCBits,
+		RawZLight:  d.RawZLight,
+		RawZFirm:   d.RawZFirm,
+		FractionZ:  d.FractionZ,
 	}
-	err = os.WriteFile(fileName, data, 0644)
+	data, err := json.MarshalIndent(calibData, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// Das File wird zuerst unter einem temporaeren Namen geschrieben und
+	// dann umbenannt, damit laufende Applikationen (siehe
+	// Touch.WatchCalibration) nie ein halb geschriebenes File lesen.
+	tmpName := fileName + ".tmp"
+	if err = os.WriteFile(tmpName, data, 0644); err != nil {
+		os.Remove(tmpName)
+		return err
 	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
 }
 
 // Liest die Konfiguration aus dem Default-File.
@@ -101,36 +188,161 @@ func (d *DistortedPlane) ReadConfig(rot RotationType) {
 
 // Liest die Konfiguration aus dem angegebenen File. Der Pfad kann absolut
 // oder relativ angegeben werden. Als Dateiformat wird JSON verwendet.
+// Enthaelt das File keine (passenden) Koeffizienten, werden sie aus den
+// Referenzpunkten berechnet.
 func (d *DistortedPlane) ReadConfigFile(fileName string, rot RotationType) {
-	off := int(rot)
-	calibData := ReadCalibDataFile(fileName)
-	d.Rot = rot
-	d.PosList = calibData.PosList
-	switch rot {
-	case Rotate090, Rotate270:
-		d.PosList[1].X, d.PosList[3].Y = d.PosList[3].Y, d.PosList[1].X
-		d.PosList[2].X, d.PosList[2].Y = d.PosList[2].Y, d.PosList[2].X
+	if err := d.LoadConfigFile(fileName, rot); err != nil {
+		log.Fatalf("Couldn't read calibration file %s: %v", fileName, err)
+	}
+}
+
+// Wie ReadConfigFile, liefert bei fehlerhaften Daten jedoch einen Fehler
+// anstatt das Programm abzubrechen. In diesem Fall bleibt d unveraendert.
+func (d *DistortedPlane) LoadConfigFile(fileName string,
+	rot RotationType) error {
+	calibData, err := LoadCalibDataFile(fileName)
+	if err != nil {
+		return err
 	}
-	for i := range NumRefPoints {
-		d.RawPosList[i] = calibData.RawPosList[(int(i)+off)%int(NumRefPoints)]
+	plane := *d
+	plane.Rot = rot
+	plane.Device = calibData.Device
+	plane.Model = calibData.Model
+	plane.Width, plane.Height = calibData.Width, calibData.Height
+	plane.RefPoints = calibData.RefPoints
+	plane.RawPosList = calibData.RawPosList
+	plane.PosList = calibData.PosList
+	plane.Coeff = calibData.Coeff
+	plane.ADCBits = calibData.ADCBits
+	plane.RawZLight, plane.RawZFirm = calibData.RawZLight, calibData.RawZFirm
+	plane.FractionZ = calibData.FractionZ
+	if plane.Width == 0 || plane.Height == 0 {
+		plane.Width, plane.Height = plane.estimateSize()
 	}
+	if len(plane.Coeff) != plane.Model.numCoeff() {
+		if calibData.Version == 0 {
+			err = plane.computeLegacy()
+		} else {
+			err = plane.Compute()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	*d = plane
+	return nil
+}
 
-	//log.Printf("posList   : %+v\n", d.PosList)
-	//log.Printf("rawPosList: %+v\n", d.RawPosList)
+// Liefert die Groesse des Bildschirms in der Rotation Rotate000, falls
+// diese im Kalibrierungsfile fehlt. Ist das Display bereits geoeffnet, wird
+// dessen Groesse verwendet, ansonsten wird angenommen, dass die
+// Referenzpunkte symmetrisch zum Rand liegen. Ohne Referenzpunkte wird die
+// Groesse des ILI9341 angenommen.
+func (d *DistortedPlane) estimateSize() (w, h int) {
+	if Width > 0 && Height > 0 {
+		if d.Rot == Rotate090 || d.Rot == Rotate270 {
+			return Height, Width
+		}
+		return Width, Height
+	}
+	if len(d.PosList) == 0 {
+		return 240, 320
+	}
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range d.PosList {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	return int(math.Round(minX + maxX + 1)), int(math.Round(minY + maxY + 1))
 }
 
+// Setzt eine Abbildung, welche den gesamten Wertebereich des Touchscreens
+// (12 Bit) auf den Bildschirm abbildet. Sie wird verwendet, solange keine
+// Kalibrierung vorhanden ist und ist entsprechend ungenau.
+func (d *DistortedPlane) setDefault(rot RotationType) {
+	d.Rot = rot
+	d.Model = CalibLinear
+	d.PosList = nil
+	d.Width, d.Height = d.estimateSize()
+	w, h := float64(d.Width-1), float64(d.Height-1)
+	d.RefPoints, _ = RefPoints(4)
+	d.RawPosList = []TouchRawPos{{0, 0, 0}, {touchRawMax, 0, 0},
+		{touchRawMax, touchRawMax, 0}, {0, touchRawMax, 0}}
+	d.PosList = []TouchPos{{0, 0, 0}, {w, 0, 0}, {w, h, 0}, {0, h, 0}}
+	d.Coeff = []float64{0, w / touchRawMax, 0, 0, 0, h / touchRawMax}
+}
+
+// Setzt (oder ersetzt) den Referenzpunkt id. Die Position pos bezieht sich
+// auf den Bildschirm in der Rotation Rotate000 (siehe ToNative). Die
+// Abbildung muss anschliessend mit Compute neu berechnet werden.
 func (d *DistortedPlane) SetRefPoint(id RefPointType, rawPos TouchRawPos,
 	pos TouchPos) {
-	d.RawPosList[id] = rawPos
-	d.PosList[id] = pos
+	for i, refId := range d.RefPoints {
+		if refId == id {
+			d.RawPosList[i] = rawPos
+			d.PosList[i] = pos
+			return
+		}
+	}
+	d.RefPoints = append(d.RefPoints, id)
+	d.RawPosList = append(d.RawPosList, rawPos)
+	d.PosList = append(d.PosList, pos)
 }
 
+// Setzt die Referenzpunkte RefTopLeft, RefTopRight, ... (in dieser
+// Reihenfolge, siehe RefPoints) auf einmal. Fuer die Positionen gilt das
+// gleiche wie bei SetRefPoint.
 func (d *DistortedPlane) SetRefPoints(rawPosList []TouchRawPos,
 	posList []TouchPos) {
-	for id := RefTopLeft; id < NumRefPoints; id++ {
-		d.RawPosList[id] = rawPosList[id]
-		d.PosList[id] = posList[id]
+	d.RefPoints = d.RefPoints[:0]
+	d.RawPosList = d.RawPosList[:0]
+	d.PosList = d.PosList[:0]
+	for i := range min(len(rawPosList), len(posList)) {
+		d.SetRefPoint(RefPointType(i), rawPosList[i], posList[i])
+	}
+}
+
+// Berechnet die Koeffizienten der Abbildung gemaess Model aus den aktuellen
+// Referenzpunkten.
+func (d *DistortedPlane) Compute() error {
+	coeff, err := fitModel(d.Model, d.RawPosList, d.PosList)
+	if err != nil {
+		return err
+	}
+	d.Coeff = coeff
+	return nil
+}
+
+// Berechnet die Koeffizienten fuer ein File ohne Versionsangabe so, wie es
+// fruehere Versionen dieses Packages getan haben: X wird linear aus RawX der
+// Ecken RefTopLeft und RefTopRight abgebildet, Y aus RawY von RefTopLeft
+// und RefBottomLeft. Die Ecke RefBottomRight wird nicht verwendet. Damit
+// bleibt die Abbildung bestehender Geraete unveraendert.
+func (d *DistortedPlane) computeLegacy() error {
+	var raw [NumRefPoints]TouchRawPos
+	var pos [NumRefPoints]TouchPos
+	var found [NumRefPoints]bool
+	for i, id := range d.RefPoints {
+		if id < NumRefPoints {
+			raw[id], pos[id], found[id] = d.RawPosList[i], d.PosList[i], true
+		}
+	}
+	if d.Model != CalibLinear || !found[RefTopLeft] ||
+		!found[RefTopRight] || !found[RefBottomLeft] {
+		return d.Compute()
+	}
+	tl, tr, bl := RefTopLeft, RefTopRight, RefBottomLeft
+	dx := float64(raw[tr].RawX) - float64(raw[tl].RawX)
+	dy := float64(raw[bl].RawY) - float64(raw[tl].RawY)
+	if dx == 0 || dy == 0 {
+		return ErrCalibDegenerate
 	}
+	cx := (pos[tr].X - pos[tl].X) / dx
+	cy := (pos[bl].Y - pos[tl].Y) / dy
+	d.Coeff = []float64{pos[tl].X - cx*float64(raw[tl].RawX), cx, 0,
+		pos[tl].Y - cy*float64(raw[tl].RawY), 0, cy}
+	return nil
 }
 
 func (d *DistortedPlane) SetZRange(rawZmin, rawZmax uint8, zmin, zmax float64) {
@@ -138,26 +350,84 @@ func (d *DistortedPlane) SetZRange(rawZmin, rawZmax uint8, zmin, zmax float64) {
 	d.Zmin, d.Zmax = zmin, zmax
 }
 
-func (d *DistortedPlane) Transform(rawPos TouchRawPos) (pos TouchPos, err error) {
+// Rechnet die Position pos aus dem Koordinatensystem der Rotation Rot in
+// dasjenige von Rotate000 um.
+func (d *DistortedPlane) ToNative(pos TouchPos) TouchPos {
+	w, h := float64(d.Width-1), float64(d.Height-1)
+	switch d.Rot {
+	case Rotate090:
+		pos.X, pos.Y = w-pos.Y, pos.X
+	case Rotate180:
+		pos.X, pos.Y = w-pos.X, h-pos.Y
+	case Rotate270:
+		pos.X, pos.Y = pos.Y, h-pos.X
+	}
+	return pos
+}
+
+// Rechnet die Position pos aus dem Koordinatensystem von Rotate000 in
+// dasjenige der Rotation Rot um (Umkehrung von ToNative).
+func (d *DistortedPlane) FromNative(pos TouchPos) TouchPos {
+	w, h := float64(d.Width-1), float64(d.Height-1)
 	switch d.Rot {
-	case Rotate000, Rotate180:
-		pos.X = Map(float64(rawPos.RawX),
-			float64(d.RawPosList[0].RawX), float64(d.RawPosList[1].RawX),
-			d.PosList[0].X, d.PosList[1].X)
-		pos.Y = Map(float64(rawPos.RawY),
-			float64(d.RawPosList[0].RawY), float64(d.RawPosList[3].RawY),
-			d.PosList[0].Y, d.PosList[3].Y)
-	case Rotate090, Rotate270:
-		pos.X = Map(float64(rawPos.RawY),
-			float64(d.RawPosList[0].RawY), float64(d.RawPosList[1].RawY),
-			d.PosList[0].X, d.PosList[1].X)
-		pos.Y = Map(float64(rawPos.RawX),
-			float64(d.RawPosList[0].RawX), float64(d.RawPosList[3].RawX),
-			d.PosList[0].Y, d.PosList[3].Y)
+	case Rotate090:
+		pos.X, pos.Y = pos.Y, w-pos.X
+	case Rotate180:
+		pos.X, pos.Y = w-pos.X, h-pos.Y
+	case Rotate270:
+		pos.X, pos.Y = h-pos.Y, pos.X
+	}
+	return pos
+}
+
+// Liefert den Bildschirm in der Rotation Rot.
+func (d *DistortedPlane) Bounds() image.Rectangle {
+	if d.Rot == Rotate090 || d.Rot == Rotate270 {
+		return image.Rect(0, 0, d.Height, d.Width)
+	}
+	return image.Rect(0, 0, d.Width, d.Height)
+}
+
+// Passt die Kalibrierung an die Aufloesung adcBits des ADC an. Im
+// 10-Bit-Modus umfassen die Rohdaten fuer X und Y nur einen Viertel des
+// Bereichs im 12-Bit-Modus; Rohdaten und Koeffizienten werden daher um den
+// Faktor 1<<(adcBits-ADCBits) skaliert. Ist die Aufloesung der Kalibrierung
+// unbekannt (ADCBits 0), bleibt sie unveraendert.
+func (d *DistortedPlane) setXYFormat(adcBits int) {
+	if d.ADCBits == 0 || d.ADCBits == adcBits {
+		return
+	}
+	f := math.Ldexp(1.0, adcBits-d.ADCBits)
+	rawMax := math.Ldexp(1.0, adcBits) - 1
+	scale := func(raw uint16) uint16 {
+		return uint16(min(rawMax, math.Round(float64(raw)*f)))
+	}
+	rawPosList := make([]TouchRawPos, len(d.RawPosList))
+	for i, rawPos := range d.RawPosList {
+		rawPos.RawX, rawPos.RawY = scale(rawPos.RawX), scale(rawPos.RawY)
+		rawPosList[i] = rawPos
+	}
+	d.RawPosList = rawPosList
+	if len(d.Coeff) == d.Model.numCoeff() {
+		d.Coeff = scaleModel(d.Model, d.Coeff, f)
+	}
+	d.ADCBits = adcBits
+}
+
+func (d *DistortedPlane) Transform(rawPos TouchRawPos) (pos TouchPos, err error) {
+	if len(d.Coeff) != d.Model.numCoeff() {
+		return pos, ErrCalibModel
+	}
+	pos.X, pos.Y, err = evalModel(d.Model, d.Coeff, float64(rawPos.RawX),
+		float64(rawPos.RawY))
+	if err != nil {
+		return pos, err
 	}
+	pos = d.FromNative(pos)
 	pos.Z = Map(float64(rawPos.RawZ),
 		float64(d.RawZmin), float64(d.RawZmax),
 		d.Zmin, d.Zmax)
+	pos.Z = max(min(d.Zmin, d.Zmax), min(max(d.Zmin, d.Zmax), pos.Z))
 	//log.Printf("(%d, %d) -> (%.0f, %.0f) (%d)\n",
 	//	rawPos.RawX, rawPos.RawY, pos.X, pos.Y, pos.Z)
 	return pos, nil
@@ -0,0 +1,321 @@
+package adatft
+
+import (
+	"errors"
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+// Beim Konvertieren eines RGBA-Bildes in das Format des TFT-Chips gehen
+// je nach Pixelformat 2 oder 3 Bit pro Farbkanal verloren. Bei Verlaeufen
+// und Fotos fuehrt das zu deutlich sichtbaren Farbstufen (Banding). Mit
+// Dithering kann dieser Effekt stark vermindert werden. Mit DitherType wird
+// das Verfahren bestimmt, welches von Convert verwendet wird.
+type DitherType int
+
+const (
+	// Kein Dithering: die niederwertigen Bits werden einfach abgeschnitten.
+	DitherNone DitherType = iota
+	// Geordnetes Dithering mit einer 4x4 Bayer-Matrix.
+	DitherBayer4
+	// Geordnetes Dithering mit einer 8x8 Bayer-Matrix.
+	DitherBayer8
+	// Fehlerdiffusion nach Floyd-Steinberg.
+	DitherFloydSteinberg
+	NumDitherTypes
+)
+
+func (dt DitherType) String() string {
+	switch dt {
+	case DitherNone:
+		return "DitherNone"
+	case DitherBayer4:
+		return "DitherBayer4"
+	case DitherBayer8:
+		return "DitherBayer8"
+	case DitherFloydSteinberg:
+		return "DitherFloydSteinberg"
+	default:
+		return "(unknown dither type)"
+	}
+}
+
+func (dt *DitherType) Set(s string) error {
+	switch s {
+	case "DitherNone":
+		*dt = DitherNone
+	case "DitherBayer4":
+		*dt = DitherBayer4
+	case "DitherBayer8":
+		*dt = DitherBayer8
+	case "DitherFloydSteinberg":
+		*dt = DitherFloydSteinberg
+	default:
+		return errors.New("Unknown dither type: " + s)
+	}
+	return nil
+}
+
+const (
+	// Kantenlaenge der Kacheln, auf welche die Fehlerdiffusion im stabilen
+	// Modus beschraenkt wird. Die Kacheln sind immer an den absoluten
+	// Bildschirmkoordinaten ausgerichtet.
+	ditherTileSize = 8
+)
+
+// In convParams werden alle Einstellungen abgelegt, welche beim
+// Konvertieren eines RGBA-Bildes beruecksichtigt werden sollen. Ein Wert
+// dieses Typs wird nach der Veroeffentlichung (siehe convSettings) nicht
+// mehr veraendert.
+type convParams struct {
+	dither DitherType
+	stable bool
+	// Farbkorrektur, welche vor dem Dithering angewendet wird (siehe
+	// Display.SetColorTransform). Ist ct nil, werden die Farben nicht
+	// veraendert.
+	ct *ColorTransform
+	// Vorberechnete Offsets fuer das geordnete Dithering, je eine Matrix
+	// pro Farbkanal. Die Kantenlaenge der Matrix steht in bayerSize.
+	bayerSize int
+	bayerOff  [3][]uint8
+}
+
+// Ein Display teilt einen Wert dieses Typs mit allen seinen Bildpuffern.
+// Jede Aenderung erzeugt eine neue Kopie der Einstellungen, welche atomar
+// ausgetauscht wird. Damit arbeitet eine laufende Konvertierung immer mit
+// einem konsistenten Satz von Einstellungen, auch wenn diese gleichzeitig
+// (z.B. mit Display.SetDither) geaendert werden.
+type convSettings struct {
+	mutex  sync.Mutex
+	params atomic.Pointer[convParams]
+}
+
+// Liefert die aktuellen Einstellungen oder nil, falls keine gesetzt sind.
+func (cs *convSettings) load() *convParams {
+	if cs == nil {
+		return nil
+	}
+	return cs.params.Load()
+}
+
+// Veraendert eine Kopie der aktuellen Einstellungen mit f und
+// veroeffentlicht diese anschliessend.
+func (cs *convSettings) update(f func(cp *convParams)) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	var cp convParams
+	if old := cs.params.Load(); old != nil {
+		cp = *old
+	}
+	f(&cp)
+	cs.params.Store(&cp)
+}
+
+// Liefert true, falls die Konvertierung nicht auf dem schnellen Weg (ohne
+// jegliche Nachbearbeitung) erfolgen kann.
+func (cp *convParams) active() bool {
+	return cp != nil && (cp.dither != DitherNone || cp.ct != nil)
+}
+
+// Setzt das Verfahren fuer das Dithering und berechnet allenfalls die
+// Offset-Tabellen neu.
+func (cp *convParams) setDither(mode DitherType, stable bool) {
+	cp.dither = mode
+	cp.stable = stable
+	switch mode {
+	case DitherBayer4:
+		cp.initBayer(4)
+	case DitherBayer8:
+		cp.initBayer(8)
+	}
+}
+
+// Berechnet die Offsets fuer das geordnete Dithering. Da Convert die
+// niederwertigen Bits abschneidet (und nicht rundet), liegen die Offsets
+// im Bereich [0, step), wobei step der Abstand zweier darstellbarer Werte
+// im jeweiligen Farbkanal ist. Die Tabellen werden immer neu angelegt, da
+// die bisherigen noch von einer laufenden Konvertierung verwendet werden
+// koennen.
+func (cp *convParams) initBayer(n int) {
+	matrix := bayerMatrix(n)
+	masks := [3]uint8{redMask, greenMask, blueMask}
+	cp.bayerSize = n
+	for ch, mask := range masks {
+		step := int(^mask) + 1
+		cp.bayerOff[ch] = make([]uint8, n*n)
+		for i, b := range matrix {
+			cp.bayerOff[ch][i] = uint8(((2*b + 1) * step) / (2 * n * n))
+		}
+	}
+}
+
+// Erzeugt rekursiv eine Bayer-Matrix der Kantenlaenge n (n muss eine
+// Zweierpotenz sein). Die Werte liegen im Bereich [0, n*n).
+func bayerMatrix(n int) []int {
+	if n == 1 {
+		return []int{0}
+	}
+	h := n / 2
+	sub := bayerMatrix(h)
+	m := make([]int, n*n)
+	for y := 0; y < h; y++ {
+		for x := 0; x < h; x++ {
+			v := 4 * sub[y*h+x]
+			m[y*n+x] = v
+			m[y*n+x+h] = v + 2
+			m[(y+h)*n+x] = v + 3
+			m[(y+h)*n+x+h] = v + 1
+		}
+	}
+	return m
+}
+
+// Konvertiert das Bild src unter Beruecksichtigung der Einstellungen in cp.
+// Wird von Convert aufgerufen, falls Dithering oder eine Farbkorrektur
+// aktiviert ist.
+func (p *ILIImage) convertParams(src *image.RGBA, cp *convParams) {
+	switch cp.dither {
+	case DitherNone:
+		p.convertColor(src, cp)
+	case DitherBayer4, DitherBayer8:
+		p.convertOrdered(src, cp)
+	case DitherFloydSteinberg:
+		p.convertDiffusion(src, cp)
+	}
+}
+
+// Konvertierung ohne Dithering aber mit Farbkorrektur.
+func (p *ILIImage) convertColor(src *image.RGBA, cp *convParams) {
+	var srcBaseIdx, srcIdx, dstBaseIdx, dstIdx int
+
+	srcBaseIdx = 0
+	dstBaseIdx = p.PixOffset(src.Rect.Min.X, src.Rect.Min.Y)
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		srcIdx = srcBaseIdx
+		dstIdx = dstBaseIdx
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			s := src.Pix[srcIdx : srcIdx+3 : srcIdx+3]
+			d := p.Pix[dstIdx : dstIdx+bytesPerPixel : dstIdx+bytesPerPixel]
+			r, g, b := cp.ct.apply(s[0], s[1], s[2])
+			putRGB(d, r, g, b)
+			srcIdx += 4
+			dstIdx += bytesPerPixel
+		}
+		srcBaseIdx += src.Stride
+		dstBaseIdx += p.Stride
+	}
+}
+
+// Liefert die Farbwerte r, g und b, allenfalls korrigiert durch die in cp
+// hinterlegte Farbkorrektur.
+func (cp *convParams) color(r, g, b uint8) (uint8, uint8, uint8) {
+	if cp.ct == nil {
+		return r, g, b
+	}
+	return cp.ct.apply(r, g, b)
+}
+
+// Geordnetes Dithering. Die Matrix ist an den absoluten Koordinaten
+// ausgerichtet, daher liefert ein unveraendertes Pixel immer den gleichen
+// Wert - dieses Verfahren ist also von Natur aus zeitlich stabil.
+func (p *ILIImage) convertOrdered(src *image.RGBA, cp *convParams) {
+	var srcBaseIdx, srcIdx, dstBaseIdx, dstIdx int
+
+	n := cp.bayerSize
+	offR, offG, offB := cp.bayerOff[0], cp.bayerOff[1], cp.bayerOff[2]
+
+	srcBaseIdx = 0
+	dstBaseIdx = p.PixOffset(src.Rect.Min.X, src.Rect.Min.Y)
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		srcIdx = srcBaseIdx
+		dstIdx = dstBaseIdx
+		row := (y % n) * n
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			s := src.Pix[srcIdx : srcIdx+3 : srcIdx+3]
+			d := p.Pix[dstIdx : dstIdx+bytesPerPixel : dstIdx+bytesPerPixel]
+			i := row + x%n
+			r, g, b := cp.color(s[0], s[1], s[2])
+			putRGB(d, addSat(r, offR[i])&redMask, addSat(g, offG[i])&greenMask,
+				addSat(b, offB[i])&blueMask)
+			srcIdx += 4
+			dstIdx += bytesPerPixel
+		}
+		srcBaseIdx += src.Stride
+		dstBaseIdx += p.Stride
+	}
+}
+
+// Fehlerdiffusion nach Floyd-Steinberg. Im normalen Modus wird die Zeile
+// abwechselnd von links nach rechts und von rechts nach links abgearbeitet
+// (serpentine). Im stabilen Modus (cp.stable) wird der Fehler nur innerhalb
+// von Kacheln der Groesse ditherTileSize verteilt. Damit wirkt sich die
+// Aenderung eines Pixels nur auf seine eigene Kachel aus und statische
+// Bereiche einer Animation bleiben unveraendert.
+func (p *ILIImage) convertDiffusion(src *image.RGBA, cp *convParams) {
+	w := src.Rect.Dx()
+	// Fehlerpuffer fuer die aktuelle und die naechste Zeile, mit je einem
+	// zusaetzlichen Element links und rechts, damit keine Randabfragen
+	// noetig sind.
+	cur := make([][3]int32, w+2)
+	nxt := make([][3]int32, w+2)
+	masks := [3]int32{int32(redMask), int32(greenMask), int32(blueMask)}
+
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		dir := 1
+		xStart, xEnd := 0, w
+		if !cp.stable && (y-src.Rect.Min.Y)%2 == 1 {
+			dir = -1
+			xStart, xEnd = w-1, -1
+		}
+		sameTileY := !cp.stable || (y+1)%ditherTileSize != 0
+		srcRow := (y - src.Rect.Min.Y) * src.Stride
+		dstRow := p.PixOffset(src.Rect.Min.X, y)
+		for i := xStart; i != xEnd; i += dir {
+			x := src.Rect.Min.X + i
+			s := src.Pix[srcRow+4*i : srcRow+4*i+3 : srcRow+4*i+3]
+			dstIdx := dstRow + i*bytesPerPixel
+			d := p.Pix[dstIdx : dstIdx+bytesPerPixel : dstIdx+bytesPerPixel]
+
+			// Die Nachbarn, auf welche der Fehler verteilt werden darf.
+			fwd, back := true, true
+			if cp.stable {
+				fwd = (x+dir)/ditherTileSize == x/ditherTileSize
+				back = (x-dir)/ditherTileSize == x/ditherTileSize
+			}
+
+			var c, q [3]uint8
+			c[0], c[1], c[2] = cp.color(s[0], s[1], s[2])
+			for ch := 0; ch < 3; ch++ {
+				v := int32(c[ch]) + cur[i+1][ch]/16
+				v = max(0, min(255, v))
+				q[ch] = uint8(v & masks[ch])
+				e := v - int32(q[ch])
+				if fwd {
+					cur[i+1+dir][ch] += 7 * e
+				}
+				if sameTileY {
+					if back {
+						nxt[i+1-dir][ch] += 3 * e
+					}
+					nxt[i+1][ch] += 5 * e
+					if fwd {
+						nxt[i+1+dir][ch] += 1 * e
+					}
+				}
+			}
+			putRGB(d, q[0], q[1], q[2])
+		}
+		cur, nxt = nxt, cur
+		clear(nxt)
+	}
+}
+
+// Addiert zwei Bytes und begrenzt das Resultat auf 255.
+func addSat(a, b uint8) uint8 {
+	s := uint16(a) + uint16(b)
+	if s > 0xff {
+		return 0xff
+	}
+	return uint8(s)
+}
@@ -0,0 +1,111 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Erstellt ein RGBA-Bild der Groesse w x h, welches vollstaendig mit der
+// Farbe c gefuellt ist.
+func uniformRGBA(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// Berechnet den Mittelwert des Rot-Kanals (8 Bit) ueber das ganze Bild.
+func meanRed(img *ILIImage) float64 {
+	var sum float64
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			r, _, _, _ := img.ILIColorAt(x, y).RGBA()
+			sum += float64(r >> 8)
+		}
+	}
+	return sum / float64(img.Rect.Dx()*img.Rect.Dy())
+}
+
+func TestBayerMatrix(t *testing.T) {
+	for _, n := range []int{4, 8} {
+		seen := make(map[int]bool)
+		for _, v := range bayerMatrix(n) {
+			if v < 0 || v >= n*n || seen[v] {
+				t.Fatalf("bayerMatrix(%d): invalid or duplicate value %d", n, v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+// Bei einer Flaeche mit einer nicht darstellbaren Farbe muss der Mittelwert
+// nach dem Dithering deutlich naeher am Original liegen als ohne.
+func TestDitherMean(t *testing.T) {
+	src := uniformRGBA(32, 32, color.RGBA{0x85, 0x85, 0x85, 0xff})
+	want := float64(0x85)
+
+	plain := NewILIImage(src.Rect)
+	plain.Convert(src)
+	errPlain := want - meanRed(plain)
+
+	for mode := DitherBayer4; mode < NumDitherTypes; mode++ {
+		img := NewILIImage(src.Rect)
+		img.conv = &convSettings{}
+		img.conv.update(func(cp *convParams) { cp.setDither(mode, false) })
+		img.Convert(src)
+		got := meanRed(img)
+		t.Logf("%v: mean %.2f, want %.2f (plain: %.2f)", mode, got, want,
+			want-errPlain)
+		if d := want - got; d > 1.0 || d < -1.0 {
+			t.Errorf("%v: mean %.2f too far from %.2f", mode, got, want)
+		}
+	}
+}
+
+// Im stabilen Modus darf sich die Aenderung eines Pixels nur innerhalb
+// seiner eigenen Kachel auswirken.
+func TestDitherStable(t *testing.T) {
+	src := uniformRGBA(32, 32, color.RGBA{0x85, 0x43, 0x27, 0xff})
+	imgA := NewILIImage(src.Rect)
+	imgA.conv = &convSettings{}
+	imgA.conv.update(func(cp *convParams) {
+		cp.setDither(DitherFloydSteinberg, true)
+	})
+	imgB := NewILIImage(src.Rect)
+	imgB.conv = imgA.conv
+
+	imgA.Convert(src)
+	src.SetRGBA(9, 9, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	imgB.Convert(src)
+
+	rect := imgA.Diff(imgB)
+	tile := image.Rect(8, 8, 16, 16)
+	if !rect.In(tile) {
+		t.Errorf("diff %v exceeds tile %v", rect, tile)
+	}
+}
+
+// Das Dithering darf waehrend laufender Konvertierungen umgestellt werden
+// (mit -race pruefen).
+func TestDitherConcurrent(t *testing.T) {
+	src := uniformRGBA(32, 32, color.RGBA{0x85, 0x43, 0x27, 0xff})
+	dsp := &Display{conv: &convSettings{}}
+	img := NewILIImage(src.Rect)
+	img.conv = dsp.conv
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dsp.SetDither(DitherType(i%int(NumDitherTypes)), i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		img.Convert(src)
+	}
+	<-done
+}
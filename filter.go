@@ -0,0 +1,248 @@
+package adatft
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ein TouchFilter bearbeitet die Messwerte des Touchscreens, bevor sie als
+// Events in die Queue gestellt werden. Mehrere Filter bilden eine Kette
+// (analog zu den Modulen von tslib, siehe Touch.SetFilters): jeder Messwert
+// durchlaeuft die Filter in der angegebenen Reihenfolge. Filter erhalten nur
+// Events vom Typ PenPress und PenDrag; beim Loslassen wird Reset aufgerufen.
+type TouchFilter interface {
+	// Bearbeitet das Event ev (i.d.R. dessen Position TouchPos). Liefert
+	// Filter false, wird das Event verworfen und von den nachfolgenden
+	// Filtern nicht mehr gesehen.
+	Filter(ev *PenEvent) bool
+	// Setzt den internen Zustand des Filters zurueck.
+	Reset()
+}
+
+var (
+	ErrFilterSpec = errors.New("touch filter: invalid specification")
+)
+
+// Verwirft Messwerte mit zu geringem (oder zu hohem) Druck. Damit werden
+// Beruehrungen unterdrueckt, welche vom Touchscreen nur unzuverlaessig
+// erkannt werden. Der Druck ist der kalibrierte Wert TouchPos.Z im Bereich
+// [0,1].
+type PressureFilter struct {
+	Min, Max float64
+}
+
+func (f *PressureFilter) Filter(ev *PenEvent) bool {
+	return ev.Z >= f.Min && (f.Max <= 0.0 || ev.Z <= f.Max)
+}
+
+func (f *PressureFilter) Reset() {}
+
+// Ersetzt die Position durch den Median der letzten Depth Messwerte (fuer X
+// und Y getrennt). Damit werden einzelne Ausreisser entfernt.
+type MedianFilter struct {
+	Depth  int
+	xs, ys []float64
+}
+
+func (f *MedianFilter) Filter(ev *PenEvent) bool {
+	f.xs = append(f.xs, ev.X)
+	f.ys = append(f.ys, ev.Y)
+	// Auch nach einer Verkleinerung von Depth werden nur die neusten
+	// Messwerte behalten.
+	if n := len(f.xs) - max(1, f.Depth); n > 0 {
+		f.xs = append(f.xs[:0], f.xs[n:]...)
+		f.ys = append(f.ys[:0], f.ys[n:]...)
+	}
+	ev.X, ev.Y = median(f.xs), median(f.ys)
+	return true
+}
+
+func (f *MedianFilter) Reset() {
+	f.xs, f.ys = f.xs[:0], f.ys[:0]
+}
+
+func median(vals []float64) float64 {
+	s := slices.Clone(vals)
+	slices.Sort(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2.0
+}
+
+// Glaettet die Position mit einem IIR-Filter (exponentieller Mittelwert),
+// solange sie sich nur wenig veraendert. Bewegt sich die Position um mehr
+// als Delta Pixel, wird sie direkt uebernommen, damit schnelle Bewegungen
+// nicht verzoegert werden. Mit Alpha (0 < Alpha <= 1) wird das Gewicht des
+// neuen Messwertes festgelegt.
+type DejitterFilter struct {
+	Delta, Alpha float64
+	last         TouchPos
+	valid        bool
+}
+
+func (f *DejitterFilter) Filter(ev *PenEvent) bool {
+	if f.valid && math.Hypot(ev.X-f.last.X, ev.Y-f.last.Y) <= f.Delta {
+		ev.X = f.last.X + f.Alpha*(ev.X-f.last.X)
+		ev.Y = f.last.Y + f.Alpha*(ev.Y-f.last.Y)
+	}
+	f.last, f.valid = ev.TouchPos, true
+	return true
+}
+
+func (f *DejitterFilter) Reset() {
+	f.valid = false
+}
+
+// Verwirft einzelne Messwerte, welche weiter als Delta Pixel vom vorher-
+// gehenden entfernt sind (Spikes). Liegt der naechste Messwert jedoch nahe
+// beim verworfenen, handelt es sich um eine echte (schnelle) Bewegung und
+// der Messwert wird akzeptiert.
+type VarianceFilter struct {
+	Delta            float64
+	last, pending    TouchPos
+	valid, isPending bool
+}
+
+func (f *VarianceFilter) Filter(ev *PenEvent) bool {
+	near := func(a, b TouchPos) bool {
+		return math.Hypot(a.X-b.X, a.Y-b.Y) <= f.Delta
+	}
+	switch {
+	case !f.valid, near(ev.TouchPos, f.last),
+		f.isPending && near(ev.TouchPos, f.pending):
+		f.last, f.valid, f.isPending = ev.TouchPos, true, false
+		return true
+	}
+	f.pending, f.isPending = ev.TouchPos, true
+	return false
+}
+
+func (f *VarianceFilter) Reset() {
+	f.valid, f.isPending = false, false
+}
+
+// Verwirft Messwerte, welche ausserhalb von Bounds oder naeher als Margin
+// Pixel an deren Rand liegen. Am Rand sind resistive Touchscreens oft sehr
+// ungenau.
+type EdgeFilter struct {
+	Bounds image.Rectangle
+	Margin float64
+}
+
+func (f *EdgeFilter) Filter(ev *PenEvent) bool {
+	return ev.X >= float64(f.Bounds.Min.X)+f.Margin &&
+		ev.X <= float64(f.Bounds.Max.X-1)-f.Margin &&
+		ev.Y >= float64(f.Bounds.Min.Y)+f.Margin &&
+		ev.Y <= float64(f.Bounds.Max.Y-1)-f.Margin
+}
+
+func (f *EdgeFilter) Reset() {}
+
+// Verwirft PenDrag-Events, deren Position sich um weniger als MinDist
+// Pixel vom zuletzt weitergegebenen Event unterscheidet. Damit erzeugt ein
+// ruhender Finger keine Flut von Drag-Events.
+type MoveFilter struct {
+	MinDist float64
+	last    TouchPos
+}
+
+func (f *MoveFilter) Filter(ev *PenEvent) bool {
+	if ev.Type == PenDrag &&
+		math.Hypot(ev.X-f.last.X, ev.Y-f.last.Y) < f.MinDist {
+		return false
+	}
+	f.last = ev.TouchPos
+	return true
+}
+
+func (f *MoveFilter) Reset() {
+	f.last = TouchPos{}
+}
+
+// Erstellt eine Filterkette aus einer textuellen Beschreibung, analog zur
+// Konfiguration von tslib (ts.conf). Jede Zeile (oder jedes durch ';'
+// getrennte Element) beschreibt einen Filter mit seinen Parametern, z.B.
+//
+//	pthres min=0.1
+//	variance delta=30
+//	median depth=5
+//	dejitter delta=10 alpha=0.3
+//	edge margin=4
+//	move dist=2
+//
+// Fuer den Filter 'edge' wird der Bildschirm bounds verwendet. Leere Zeilen
+// und Kommentare (beginnend mit '#') werden ignoriert.
+func ParseTouchFilters(spec string, bounds image.Rectangle) ([]TouchFilter,
+	error) {
+	var filters []TouchFilter
+
+	spec = strings.ReplaceAll(spec, ";", "\n")
+	for _, line := range strings.Split(spec, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		params := make(map[string]float64)
+		for _, p := range fields[1:] {
+			key, val, ok := strings.Cut(p, "=")
+			v, err := strconv.ParseFloat(val, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("%w: parameter '%s' of %s",
+					ErrFilterSpec, p, fields[0])
+			}
+			params[key] = v
+		}
+		param := func(key string, def float64) float64 {
+			if v, ok := params[key]; ok {
+				delete(params, key)
+				return v
+			}
+			return def
+		}
+
+		var f TouchFilter
+		switch fields[0] {
+		case "pthres":
+			f = &PressureFilter{Min: param("min", 0.0), Max: param("max", 0.0)}
+		case "median":
+			depth := int(param("depth", 3))
+			if depth < 1 {
+				return nil, fmt.Errorf("%w: median depth %d", ErrFilterSpec,
+					depth)
+			}
+			f = &MedianFilter{Depth: depth}
+		case "dejitter":
+			alpha := param("alpha", 0.3)
+			if alpha <= 0.0 || alpha > 1.0 {
+				return nil, fmt.Errorf("%w: dejitter alpha %g", ErrFilterSpec,
+					alpha)
+			}
+			f = &DejitterFilter{Delta: param("delta", 10.0), Alpha: alpha}
+		case "variance":
+			f = &VarianceFilter{Delta: param("delta", 30.0)}
+		case "edge":
+			f = &EdgeFilter{Bounds: bounds, Margin: param("margin", 0.0)}
+		case "move":
+			f = &MoveFilter{MinDist: param("dist", 1.0)}
+		default:
+			return nil, fmt.Errorf("%w: unknown filter %s", ErrFilterSpec,
+				fields[0])
+		}
+		for key := range params {
+			return nil, fmt.Errorf("%w: unknown parameter '%s' of %s",
+				ErrFilterSpec, key, fields[0])
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
@@ -0,0 +1,182 @@
+package adatft
+
+import (
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+// Laesst die Positionen in pos durch die Filterkette filters laufen und
+// liefert die weitergegebenen Events (das erste Event ist ein PenPress).
+func runFilters(filters []TouchFilter, pos []TouchPos) []PenEvent {
+	var out []PenEvent
+
+	for _, f := range filters {
+		f.Reset()
+	}
+	for i, p := range pos {
+		ev := PenEvent{Type: PenDrag, TouchPos: p}
+		if i == 0 {
+			ev.Type = PenPress
+		}
+		accepted := true
+		for _, f := range filters {
+			if !f.Filter(&ev) {
+				accepted = false
+				break
+			}
+		}
+		if accepted {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestPressureFilter(t *testing.T) {
+	f := &PressureFilter{Min: 0.2}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 1, Z: 0.1},
+		{X: 2, Z: 0.5}, {X: 3, Z: 0.15}, {X: 4, Z: 0.9}})
+	if len(out) != 2 || out[0].X != 2 || out[1].X != 4 {
+		t.Errorf("got %v", out)
+	}
+}
+
+func TestMedianFilter(t *testing.T) {
+	f := &MedianFilter{Depth: 3}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 10, Y: 10},
+		{X: 11, Y: 10}, {X: 100, Y: 90}, {X: 12, Y: 11}, {X: 13, Y: 11}})
+	for _, ev := range out {
+		if ev.X > 20 || ev.Y > 20 {
+			t.Errorf("spike not removed: %v", out)
+			break
+		}
+	}
+}
+
+// Wird Depth verkleinert, darf der Puffer nicht weiter wachsen.
+func TestMedianFilterDepth(t *testing.T) {
+	f := &MedianFilter{Depth: 5}
+	runFilters([]TouchFilter{f}, []TouchPos{{X: 1}, {X: 2}, {X: 3},
+		{X: 4}, {X: 5}})
+	f.Depth = 2
+	for i := range 10 {
+		ev := PenEvent{Type: PenDrag, TouchPos: TouchPos{X: float64(10 + i)}}
+		f.Filter(&ev)
+		if len(f.xs) > 2 || len(f.ys) > 2 {
+			t.Fatalf("buffer holds %d values, want 2", len(f.xs))
+		}
+	}
+	ev := PenEvent{Type: PenDrag, TouchPos: TouchPos{X: 30}}
+	if f.Filter(&ev); ev.X != 24.5 {
+		t.Errorf("got %g, want median 24.5 of the last two", ev.X)
+	}
+}
+
+func TestDejitterFilter(t *testing.T) {
+	f := &DejitterFilter{Delta: 5, Alpha: 0.5}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 10}, {X: 12},
+		{X: 50}})
+	if out[1].X != 11 {
+		t.Errorf("jitter not smoothed: %v", out[1].X)
+	}
+	if out[2].X != 50 {
+		t.Errorf("fast movement smoothed: %v", out[2].X)
+	}
+}
+
+func TestVarianceFilter(t *testing.T) {
+	f := &VarianceFilter{Delta: 10}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 10}, {X: 12},
+		{X: 80}, {X: 14}, {X: 60}, {X: 62}, {X: 64}})
+	want := []float64{10, 12, 14, 62, 64}
+	if len(out) != len(want) {
+		t.Fatalf("got %v, want X = %v", out, want)
+	}
+	for i, ev := range out {
+		if ev.X != want[i] {
+			t.Errorf("event %d: X = %v, want %v", i, ev.X, want[i])
+		}
+	}
+}
+
+func TestEdgeFilter(t *testing.T) {
+	f := &EdgeFilter{Bounds: image.Rect(0, 0, 320, 240), Margin: 5}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 2, Y: 100},
+		{X: 100, Y: 100}, {X: 316, Y: 100}, {X: 100, Y: 236}, {X: 314, Y: 234}})
+	if len(out) != 2 || out[0].X != 100 || out[1].X != 314 {
+		t.Errorf("got %v", out)
+	}
+}
+
+func TestMoveFilter(t *testing.T) {
+	f := &MoveFilter{MinDist: 3}
+	out := runFilters([]TouchFilter{f}, []TouchPos{{X: 10}, {X: 11},
+		{X: 12}, {X: 14}, {X: 15}})
+	if len(out) != 2 || out[0].Type != PenPress || out[1].X != 14 {
+		t.Errorf("got %v", out)
+	}
+}
+
+func TestMoveFilterReset(t *testing.T) {
+	f := &MoveFilter{MinDist: 3}
+	runFilters([]TouchFilter{f}, []TouchPos{{X: 10}, {X: 20}})
+	f.Reset()
+	if f.last != (TouchPos{}) {
+		t.Errorf("last position %v kept after Reset", f.last)
+	}
+}
+
+func TestParseTouchFilters(t *testing.T) {
+	spec := `# Filterkette
+pthres min=0.1
+variance delta=30; median depth=5
+dejitter delta=10 alpha=0.3   # Glaettung
+edge margin=4
+move dist=2`
+	filters, err := ParseTouchFilters(spec, image.Rect(0, 0, 320, 240))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 6 {
+		t.Fatalf("got %d filters, want 6", len(filters))
+	}
+	if f, ok := filters[2].(*MedianFilter); !ok || f.Depth != 5 {
+		t.Errorf("filter 2 is %#v", filters[2])
+	}
+	if f, ok := filters[4].(*EdgeFilter); !ok || f.Bounds.Dx() != 320 ||
+		math.Abs(f.Margin-4) > 1e-9 {
+		t.Errorf("filter 4 is %#v", filters[4])
+	}
+	for _, bad := range []string{"blur radius=3", "median size=3",
+		"median depth", "dejitter alpha=2", "median depth=0"} {
+		if _, err := ParseTouchFilters(bad, image.Rectangle{}); !errors.Is(err,
+			ErrFilterSpec) {
+			t.Errorf("%q: got %v", bad, err)
+		}
+	}
+}
+
+// Waehrend einer Kalibrierung muessen alle Messwerte unveraendert
+// durchgelassen werden.
+func TestSuspendFilters(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	tch.SetFilters(&MoveFilter{MinDist: 10})
+	tch.setMinPressure(0.5)
+
+	press := PenEvent{Type: PenPress, TouchPos: TouchPos{X: 10, Z: 0.1}}
+	drag := PenEvent{Type: PenDrag, TouchPos: TouchPos{X: 11, Z: 0.1}}
+	restore := tch.suspendFilters()
+	for _, ev := range []PenEvent{press, drag} {
+		if !tch.filter(&ev) {
+			t.Errorf("suspended: %v dropped", ev.Type)
+		}
+	}
+	restore()
+	for _, ev := range []PenEvent{press, drag} {
+		if tch.filter(&ev) {
+			t.Errorf("restored: %v accepted", ev.Type)
+		}
+	}
+}
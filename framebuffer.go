@@ -0,0 +1,278 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"sync"
+)
+
+const (
+	// Maximale Anzahl von Rechtecken, welche ein Framebuffer als
+	// veraendert verwaltet. Werden es mehr, wird daraus ein einziges,
+	// umschliessendes Rechteck gebildet.
+	maxDirtyRects = 16
+	// Rechtecke, welche weniger als diese Anzahl Pixel auseinander liegen,
+	// werden zu einem Rechteck zusammengefasst.
+	dirtyMergeDist = 4
+)
+
+// Ein Framebuffer ist ein Bild im Format des TFT, in welches die
+// Applikation direkt zeichnen kann (er implementiert draw.Image). Dabei
+// werden alle veraenderten Bereiche festgehalten, so dass mit Display.Flush
+// nur diese zum TFT gesendet werden muessen. Damit entfaellt sowohl die
+// Konvertierung eines RGBA-Bildes als auch die Differenzbildung.
+//
+// Die synchronen Methoden DrawSync, DrawILI und DrawRegion uebertragen
+// den dargestellten Bereich auch in den Framebuffer und entfernen ihn aus
+// den Markierungen (teilweise ueberdeckte Bereiche werden dabei
+// verkleinert). Ein nachfolgendes Flush ueberschreibt diesen Bereich also
+// nicht mit veralteten Daten; was die Applikation dort in den Framebuffer
+// gezeichnet, aber noch nicht gesendet hat, geht verloren. Da diese
+// Methoden aus einer anderen Go-Routine aufgerufen werden koennen, sind
+// alle Methoden des Framebuffers mit einem Mutex geschuetzt (siehe aber
+// Image).
+type Framebuffer struct {
+	mutex sync.Mutex
+	img   *ILIImage
+	dirty []image.Rectangle
+	// Die mit Set veraenderten Pixel, welche noch nicht in dirty
+	// eingetragen sind (siehe markPixel).
+	pending image.Rectangle
+}
+
+func newFramebuffer(r image.Rectangle) *Framebuffer {
+	fb := &Framebuffer{}
+	fb.img = NewILIImage(r)
+	fb.dirty = make([]image.Rectangle, 0, maxDirtyRects)
+	return fb
+}
+
+// ColorModel, Bounds und At werden vom Interface image.Image gefordert.
+func (fb *Framebuffer) ColorModel() color.Model {
+	return ILIModel
+}
+func (fb *Framebuffer) Bounds() image.Rectangle {
+	return fb.img.Rect
+}
+func (fb *Framebuffer) At(x, y int) color.Color {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	return fb.img.ILIColorAt(x, y)
+}
+
+// Set wird von draw.Image gefordert. Das Pixel wird als veraendert
+// markiert. Die generischen Funktionen aus image/draw rufen Set fuer jedes
+// Pixel auf; damit dabei nicht jedes Mal die ganze Liste durchsucht wird,
+// werden benachbarte Pixel zuerst zu einem Rechteck zusammengefasst.
+func (fb *Framebuffer) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(fb.img.Rect)) {
+		return
+	}
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.img.Set(x, y, c)
+	fb.markPixel(x, y)
+}
+
+// Analog zu Set, jedoch mit einem Farbwert, der bereits im Format des TFT
+// vorliegt.
+func (fb *Framebuffer) SetILIColor(x, y int, c ILIColor) {
+	if !(image.Point{x, y}.In(fb.img.Rect)) {
+		return
+	}
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.img.SetILIColor(x, y, c)
+	fb.markPixel(x, y)
+}
+
+// Liefert das Bild, welches den Framebuffer haelt. Wird dieses Bild direkt
+// veraendert, muessen die betroffenen Bereiche mit MarkDirty gemeldet werden.
+// Solche Zugriffe sind nicht geschuetzt und duerfen daher nicht gleichzeitig
+// mit DrawSync, DrawILI, DrawRegion oder Flush erfolgen.
+func (fb *Framebuffer) Image() *ILIImage {
+	return fb.img
+}
+
+// Markiert den Bereich r als veraendert. Ueberlappende oder nahe beieinander
+// liegende Bereiche werden zusammengefasst.
+func (fb *Framebuffer) MarkDirty(r image.Rectangle) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.markDirty(r)
+}
+
+// Erweitert den Bereich pending um das Pixel (x,y). Liegt es zu weit
+// entfernt, wird pending in die Liste eingetragen und ein neuer Bereich
+// begonnen (fb.mutex muss gesperrt sein).
+func (fb *Framebuffer) markPixel(x, y int) {
+	p := image.Rect(x, y, x+1, y+1)
+	if !fb.pending.Empty() && !fb.pending.Inset(-dirtyMergeDist).Overlaps(p) {
+		fb.markDirty(fb.pending)
+		fb.pending = image.Rectangle{}
+	}
+	fb.pending = fb.pending.Union(p)
+}
+
+// Traegt den Bereich pending in die Liste ein (fb.mutex muss gesperrt
+// sein).
+func (fb *Framebuffer) flushPending() {
+	if !fb.pending.Empty() {
+		fb.markDirty(fb.pending)
+		fb.pending = image.Rectangle{}
+	}
+}
+
+// Wie MarkDirty, fb.mutex muss jedoch gesperrt sein.
+func (fb *Framebuffer) markDirty(r image.Rectangle) {
+	r = r.Intersect(fb.img.Rect)
+	if r.Empty() {
+		return
+	}
+	// Der haeufigste Fall (mehrere Pixel im gleichen Bereich) wird als
+	// erstes geprueft.
+	if n := len(fb.dirty); n > 0 && r.In(fb.dirty[n-1]) {
+		return
+	}
+	for {
+		merged := false
+		for i, d := range fb.dirty {
+			if d.Inset(-dirtyMergeDist).Overlaps(r) {
+				r = r.Union(d)
+				fb.dirty = append(fb.dirty[:i], fb.dirty[i+1:]...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			break
+		}
+	}
+	if len(fb.dirty) == maxDirtyRects {
+		for _, d := range fb.dirty {
+			r = r.Union(d)
+		}
+		fb.dirty = fb.dirty[:0]
+	}
+	fb.dirty = append(fb.dirty, r)
+}
+
+// Liefert die Liste der aktuell als veraendert markierten Bereiche.
+func (fb *Framebuffer) Dirty() []image.Rectangle {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.flushPending()
+	return slices.Clone(fb.dirty)
+}
+
+// Entfernt die Rechtecke aus rects aus der Liste der veraenderten Bereiche.
+// Teilweise ueberdeckte Bereiche werden dabei in die nicht ueberdeckten
+// Teile zerlegt. Ist rects leer, wird die Liste vollstaendig geleert
+// (fb.mutex muss gesperrt sein).
+func (fb *Framebuffer) clearDirty(rects []image.Rectangle) {
+	fb.flushPending()
+	if len(rects) == 0 {
+		fb.dirty = fb.dirty[:0]
+		return
+	}
+	dirty := fb.dirty
+	for _, r := range rects {
+		var res []image.Rectangle
+		for _, d := range dirty {
+			res = appendRectDiff(res, d, r)
+		}
+		dirty = res
+	}
+	if len(dirty) > maxDirtyRects {
+		var all image.Rectangle
+		for _, d := range dirty {
+			all = all.Union(d)
+		}
+		dirty = []image.Rectangle{all}
+	}
+	fb.dirty = append(fb.dirty[:0], dirty...)
+}
+
+// Haengt die Teile von d, welche ausserhalb von r liegen, an rs an (bis zu
+// vier Rechtecke: oberhalb, unterhalb, links und rechts von r).
+func appendRectDiff(rs []image.Rectangle, d,
+	r image.Rectangle) []image.Rectangle {
+	r = r.Intersect(d)
+	if r.Empty() {
+		return append(rs, d)
+	}
+	if d.Min.Y < r.Min.Y {
+		rs = append(rs, image.Rect(d.Min.X, d.Min.Y, d.Max.X, r.Min.Y))
+	}
+	if r.Max.Y < d.Max.Y {
+		rs = append(rs, image.Rect(d.Min.X, r.Max.Y, d.Max.X, d.Max.Y))
+	}
+	if d.Min.X < r.Min.X {
+		rs = append(rs, image.Rect(d.Min.X, r.Min.Y, r.Min.X, r.Max.Y))
+	}
+	if r.Max.X < d.Max.X {
+		rs = append(rs, image.Rect(r.Max.X, r.Min.Y, d.Max.X, r.Max.Y))
+	}
+	return rs
+}
+
+// Liefert den Framebuffer dieses Displays. Beim ersten Aufruf wird er mit
+// dem aktuellen Bildschirminhalt erstellt. Alles was in den Framebuffer
+// gezeichnet wird, erscheint erst nach einem Aufruf von Flush auf dem TFT.
+func (dsp *Display) Framebuffer() *Framebuffer {
+	dsp.mutex.Lock()
+	defer dsp.mutex.Unlock()
+	if dsp.fb == nil {
+		dsp.fb = newFramebuffer(dsp.rect)
+		dsp.fb.img.copyRect(dsp.rect, dsp.activeImg, dsp.rect.Min)
+	}
+	return dsp.fb
+}
+
+// Uebernimmt den Bereich r des aktuellen Bildschirminhalts in den
+// Framebuffer, sofern dieser bereits erstellt wurde. dsp.mutex muss
+// gesperrt sein.
+func (dsp *Display) syncFramebuffer(r image.Rectangle) {
+	r = r.Intersect(dsp.rect)
+	if dsp.fb == nil || r.Empty() {
+		return
+	}
+	dsp.fb.mutex.Lock()
+	defer dsp.fb.mutex.Unlock()
+	dsp.fb.img.copyRect(r, dsp.activeImg, r.Min)
+	dsp.fb.clearDirty([]image.Rectangle{r})
+}
+
+// Sendet die veraenderten Bereiche des Framebuffers zum TFT. Ohne Argumente
+// werden alle als veraendert markierten Bereiche gesendet. Werden Rechtecke
+// angegeben, so werden genau diese Bereiche gesendet (unabhaengig davon, ob
+// sie als veraendert markiert sind). Die Darstellung erfolgt synchron.
+func (dsp *Display) Flush(rects ...image.Rectangle) error {
+	dsp.mutex.Lock()
+	defer dsp.mutex.Unlock()
+	defer latency.submit()()
+	if dsp.fb == nil {
+		return nil
+	}
+	dsp.fb.mutex.Lock()
+	defer dsp.fb.mutex.Unlock()
+	dsp.fb.flushPending()
+	sendRects := rects
+	if len(sendRects) == 0 {
+		sendRects = dsp.fb.dirty
+	}
+	for _, r := range sendRects {
+		r = r.Intersect(dsp.rect)
+		if r.Empty() {
+			continue
+		}
+		// Das Abbild des Bildschirminhalts wird nachgefuehrt, damit
+		// nachfolgende Aufrufe von Draw oder DrawSync korrekte Differenzen
+		// ermitteln.
+		dsp.activeImg.copyRect(r, dsp.fb.img, r.Min)
+		dsp.sendImage(dsp.fb.img.SubImage(r).(*ILIImage))
+	}
+	dsp.fb.clearDirty(rects)
+	return nil
+}
@@ -0,0 +1,172 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFramebufferDirty(t *testing.T) {
+	fb := newFramebuffer(image.Rect(0, 0, 320, 240))
+
+	// Eine Linie aus einzelnen Pixeln muss ein einziges Rechteck ergeben.
+	for x := 10; x < 50; x++ {
+		fb.Set(x, 20, color.White)
+	}
+	if len(fb.Dirty()) != 1 || fb.Dirty()[0] != image.Rect(10, 20, 50, 21) {
+		t.Errorf("line: got %v", fb.Dirty())
+	}
+
+	// Ein weit entfernter Bereich wird separat gefuehrt.
+	draw.Draw(fb, image.Rect(200, 200, 210, 210), image.White,
+		image.Point{}, draw.Src)
+	if len(fb.Dirty()) != 2 {
+		t.Errorf("two areas: got %v", fb.Dirty())
+	}
+
+	fb.clearDirty([]image.Rectangle{image.Rect(0, 0, 100, 100)})
+	if len(fb.Dirty()) != 1 || fb.Dirty()[0] != image.Rect(200, 200, 210, 210) {
+		t.Errorf("partial clear: got %v", fb.Dirty())
+	}
+	fb.clearDirty(nil)
+	if len(fb.Dirty()) != 0 {
+		t.Errorf("clear: got %v", fb.Dirty())
+	}
+}
+
+// Bei sehr vielen verstreuten Bereichen wird die Liste auf ein einziges
+// Rechteck reduziert.
+func TestFramebufferDirtyOverflow(t *testing.T) {
+	fb := newFramebuffer(image.Rect(0, 0, 320, 240))
+	for i := 0; i < 2*maxDirtyRects; i++ {
+		fb.Set(10*i, 10*(i%2), color.White)
+	}
+	if len(fb.Dirty()) > maxDirtyRects {
+		t.Errorf("too many dirty rects: %d", len(fb.Dirty()))
+	}
+	var all image.Rectangle
+	for _, r := range fb.Dirty() {
+		all = all.Union(r)
+	}
+	if want := image.Rect(0, 0, 10*(2*maxDirtyRects-1)+1, 11); all != want {
+		t.Errorf("union: got %v, want %v", all, want)
+	}
+}
+
+// Dient als Display-Anbindung fuer Tests, welche ohne Hardware auskommen
+// muessen; alle Daten werden verworfen.
+type nullDisp struct{}
+
+func (nullDisp) Init(rotation byte) (w, h int) { return 320, 240 }
+func (nullDisp) Close()                        {}
+func (nullDisp) Cmd(cmd uint8)                 {}
+func (nullDisp) Data8(val uint8)               {}
+func (nullDisp) Data32(val uint32)             {}
+func (nullDisp) DataArray(buf []byte)          {}
+
+// Was mit DrawILI oder DrawRegion dargestellt wurde, darf von einem
+// nachfolgenden Flush nicht wieder ueberschrieben werden.
+func TestFramebufferSyncDraw(t *testing.T) {
+	dsp := newNullDisplay()
+	fb := dsp.Framebuffer()
+	fb.Fill(image.Rect(0, 0, 100, 100), color.White)
+
+	icon := NewILIImage(image.Rect(0, 0, 20, 20))
+	icon.Fill(icon.Rect, color.RGBA{0xff, 0, 0, 0xff})
+	dsp.DrawILI(icon, image.Pt(10, 10))
+	src := uniformRGBA(320, 240, color.RGBA{0, 0, 0xff, 0xff})
+	dsp.DrawRegion(src, image.Rect(200, 200, 220, 220))
+	fb.Fill(image.Rect(200, 200, 210, 210), color.White)
+	dsp.Flush()
+
+	for _, tc := range []struct {
+		p    image.Point
+		want color.Color
+	}{
+		{image.Pt(15, 15), color.RGBA{0xff, 0, 0, 0xff}},
+		{image.Pt(50, 50), color.White},
+		{image.Pt(205, 205), color.White},
+		{image.Pt(215, 215), color.RGBA{0, 0, 0xff, 0xff}},
+	} {
+		want := ILIModel.Convert(tc.want)
+		if got := dsp.activeImg.ILIColorAt(tc.p.X, tc.p.Y); got != want {
+			t.Errorf("screen at %v: got %v, want %v", tc.p, got, want)
+		}
+		if got := fb.img.ILIColorAt(tc.p.X, tc.p.Y); got != want {
+			t.Errorf("framebuffer at %v: got %v, want %v", tc.p, got, want)
+		}
+	}
+}
+
+// Mit den generischen Funktionen aus image/draw (Set fuer jedes Pixel)
+// entsteht fuer ein Rechteck genau ein Eintrag.
+func TestFramebufferDirtySet(t *testing.T) {
+	fb := newFramebuffer(image.Rect(0, 0, 320, 240))
+	r := image.Rect(20, 30, 120, 80)
+	draw.Draw(fb, r, image.White, image.Point{}, draw.Src)
+	if d := fb.Dirty(); len(d) != 1 || d[0] != r {
+		t.Errorf("got %v, want [%v]", d, r)
+	}
+}
+
+// Ein Bereich, welcher nur teilweise mit DrawRegion ueberschrieben wird,
+// bleibt im uebrigen Teil markiert. Flush darf den neuen Inhalt nicht mit
+// dem alten des Framebuffers ueberschreiben.
+func TestFramebufferPartialSync(t *testing.T) {
+	dsp := newNullDisplay()
+	fb := dsp.Framebuffer()
+	fb.Fill(image.Rect(0, 0, 100, 100), color.White)
+
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	region := image.Rect(50, 50, 150, 150)
+	dsp.DrawRegion(uniformRGBA(320, 240, blue), region)
+	area := 0
+	for _, d := range fb.Dirty() {
+		if d.Overlaps(region) {
+			t.Errorf("dirty rect %v overlaps %v", d, region)
+		}
+		area += d.Dx() * d.Dy()
+	}
+	if want := 100*100 - 50*50; area != want {
+		t.Errorf("dirty area %d, want %d", area, want)
+	}
+
+	dsp.Flush()
+	for _, tc := range []struct {
+		p    image.Point
+		want color.Color
+	}{
+		{image.Pt(25, 25), color.White},
+		{image.Pt(75, 25), color.White},
+		{image.Pt(75, 75), blue},
+		{image.Pt(125, 125), blue},
+	} {
+		got := dsp.activeImg.ILIColorAt(tc.p.X, tc.p.Y)
+		if got != ILIModel.Convert(tc.want) {
+			t.Errorf("screen at %v: got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+}
+
+// DrawSync und DrawRegion duerfen aus einer anderen Go-Routine aufgerufen
+// werden als derjenigen, welche in den Framebuffer zeichnet.
+func TestFramebufferConcurrent(t *testing.T) {
+	dsp := newNullDisplay()
+	fb := dsp.Framebuffer()
+	src := uniformRGBA(320, 240, color.RGBA{0, 0xff, 0, 0xff})
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			dsp.DrawRegion(src, image.Rect(i, i, 100+i, 100+i))
+			dsp.DrawSync(src)
+		}
+		close(done)
+	}()
+	for i := 0; i < 20; i++ {
+		fb.Fill(image.Rect(50, 50, 60+i, 60+i), color.White)
+		fb.Set(200+i, 10, color.White)
+		dsp.Flush()
+	}
+	<-done
+}
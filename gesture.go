@@ -0,0 +1,329 @@
+package adatft
+
+import (
+	"math"
+	"time"
+)
+
+// Dies sind die Gesten, welche von einem GestureRecognizer aus den
+// PenEvents erkannt werden.
+type GestureType uint8
+
+const (
+	// Kurzer Druck ohne nennenswerte Bewegung.
+	GestureTap GestureType = iota
+	// Zweiter Tap kurz nach einem ersten, an etwa der gleichen Stelle. Fuer
+	// den zweiten Tap wird kein GestureTap erzeugt.
+	GestureDoubleTap
+	// Langer Druck ohne nennenswerte Bewegung. Das Loslassen erzeugt danach
+	// keinen Tap mehr.
+	GestureLongPress
+	// Die Position hat sich seit dem Druck um mehr als Slop veraendert.
+	GestureDragStart
+	// Jede weitere Bewegung waehrend eines Drags.
+	GestureDragMove
+	// Loslassen nach einem Drag.
+	GestureDragEnd
+	// Loslassen nach einem schnellen Drag (zusaetzlich zu GestureDragEnd).
+	// Richtung und Geschwindigkeit sind im GestureEvent abgelegt.
+	GestureSwipe
+)
+
+func (gt GestureType) String() string {
+	switch gt {
+	case GestureTap:
+		return "Tap"
+	case GestureDoubleTap:
+		return "DoubleTap"
+	case GestureLongPress:
+		return "LongPress"
+	case GestureDragStart:
+		return "DragStart"
+	case GestureDragMove:
+		return "DragMove"
+	case GestureDragEnd:
+		return "DragEnd"
+	case GestureSwipe:
+		return "Swipe"
+	}
+	return "(unknown gesture)"
+}
+
+// Richtung eines Swipes.
+type SwipeDirection uint8
+
+const (
+	SwipeNone SwipeDirection = iota
+	SwipeLeft
+	SwipeRight
+	SwipeUp
+	SwipeDown
+)
+
+func (sd SwipeDirection) String() string {
+	switch sd {
+	case SwipeNone:
+		return "None"
+	case SwipeLeft:
+		return "Left"
+	case SwipeRight:
+		return "Right"
+	case SwipeUp:
+		return "Up"
+	case SwipeDown:
+		return "Down"
+	}
+	return "(unknown direction)"
+}
+
+// Ein GestureEvent beschreibt eine erkannte Geste.
+type GestureEvent struct {
+	Type GestureType
+	// Aktuelle Position und Position beim Druck auf den Touchscreen.
+	Pos, Start TouchPos
+	// Geschwindigkeit in Pixel pro Sekunde (nur bei Drag und Swipe).
+	VX, VY float64
+	// Richtung (nur bei GestureSwipe).
+	Direction SwipeDirection
+	// Zeitpunkt der Geste und Dauer seit dem Druck.
+	Time     time.Time
+	Duration time.Duration
+}
+
+// Mit GestureConfig werden die Toleranzen und Zeiten der Gestenerkennung
+// eingestellt.
+type GestureConfig struct {
+	// Maximale Bewegung (in Pixeln) fuer Tap und LongPress. Wird sie
+	// ueberschritten, beginnt ein Drag.
+	Slop float64
+	// Maximaler Abstand (in Pixeln) zwischen den beiden Taps eines
+	// DoubleTaps.
+	DoubleTapSlop float64
+	// Maximale Dauer eines Taps.
+	TapTimeout time.Duration
+	// Maximale Zeit zwischen dem Loslassen des ersten und dem Druck des
+	// zweiten Taps eines DoubleTaps.
+	DoubleTapTimeout time.Duration
+	// Minimale Dauer eines LongPress.
+	LongPressTimeout time.Duration
+	// Minimale Geschwindigkeit (Pixel pro Sekunde) und minimale Distanz
+	// (Pixel) fuer einen Swipe.
+	SwipeVelocity float64
+	SwipeDistance float64
+	// Zeitraum vor dem Loslassen, ueber welchen die Geschwindigkeit
+	// ermittelt wird.
+	VelocityWindow time.Duration
+}
+
+var (
+	DefaultGestureConfig = GestureConfig{
+		Slop:             8.0,
+		DoubleTapSlop:    20.0,
+		TapTimeout:       300 * time.Millisecond,
+		DoubleTapTimeout: 300 * time.Millisecond,
+		LongPressTimeout: 600 * time.Millisecond,
+		SwipeVelocity:    300.0,
+		SwipeDistance:    40.0,
+		VelocityWindow:   100 * time.Millisecond,
+	}
+)
+
+// Ein Messwert fuer die Berechnung der Geschwindigkeit.
+type gestureSample struct {
+	pos  TouchPos
+	time time.Time
+}
+
+// Der GestureRecognizer erkennt aus einer Folge von PenEvents die Gesten
+// (siehe GestureType). Die Events werden mit Feed uebergeben; da ein
+// LongPress auch ohne neue Events erkannt werden muss, ist zudem Tick
+// regelmaessig aufzurufen (Run erledigt beides). Ein GestureRecognizer ist
+// nicht fuer die gleichzeitige Verwendung in mehreren Go-Routinen
+// ausgelegt.
+type GestureRecognizer struct {
+	Config GestureConfig
+
+	down, dragging, longPressed bool
+	start                       TouchPos
+	startTime                   time.Time
+	last                        TouchPos
+	samples                     []gestureSample
+	lastTap                     TouchPos
+	lastTapTime                 time.Time
+}
+
+// Erstellt einen neuen GestureRecognizer mit der Konfiguration cfg.
+func NewGestureRecognizer(cfg GestureConfig) *GestureRecognizer {
+	return &GestureRecognizer{Config: cfg}
+}
+
+// Verarbeitet das PenEvent ev und liefert die daraus erkannten Gesten
+// (evtl. keine).
+func (gr *GestureRecognizer) Feed(ev PenEvent) []GestureEvent {
+	var res []GestureEvent
+
+	switch ev.Type {
+	case PenPress:
+		gr.down, gr.dragging, gr.longPressed = true, false, false
+		gr.start, gr.startTime = ev.TouchPos, ev.Time
+		gr.last = ev.TouchPos
+		gr.samples = append(gr.samples[:0], gestureSample{ev.TouchPos,
+			ev.Time})
+
+	case PenDrag:
+		if !gr.down {
+			return nil
+		}
+		res = gr.Tick(ev.Time)
+		gr.last = ev.TouchPos
+		gr.addSample(ev.TouchPos, ev.Time)
+		if !gr.dragging && !gr.longPressed &&
+			gr.dist(ev.TouchPos, gr.start) > gr.Config.Slop {
+			gr.dragging = true
+			res = append(res, gr.event(GestureDragStart, ev.Time))
+		} else if gr.dragging {
+			res = append(res, gr.event(GestureDragMove, ev.Time))
+		}
+
+	case PenRelease:
+		if !gr.down {
+			return nil
+		}
+		res = gr.Tick(ev.Time)
+		gr.down = false
+		switch {
+		case gr.dragging:
+			res = append(res, gr.event(GestureDragEnd, ev.Time))
+			vx, vy := gr.velocity()
+			v := math.Hypot(vx, vy)
+			if v >= gr.Config.SwipeVelocity &&
+				gr.dist(gr.last, gr.start) >= gr.Config.SwipeDistance {
+				swipe := gr.event(GestureSwipe, ev.Time)
+				swipe.Direction = swipeDirection(vx, vy)
+				res = append(res, swipe)
+			}
+		case gr.longPressed:
+		case ev.Time.Sub(gr.startTime) <= gr.Config.TapTimeout:
+			if !gr.lastTapTime.IsZero() &&
+				gr.startTime.Sub(gr.lastTapTime) <= gr.Config.DoubleTapTimeout &&
+				gr.dist(gr.start, gr.lastTap) <= gr.Config.DoubleTapSlop {
+				res = append(res, gr.event(GestureDoubleTap, ev.Time))
+				gr.lastTapTime = time.Time{}
+			} else {
+				res = append(res, gr.event(GestureTap, ev.Time))
+				gr.lastTap, gr.lastTapTime = gr.start, ev.Time
+			}
+		}
+	}
+	return res
+}
+
+// Prueft zum Zeitpunkt now, ob ein LongPress vorliegt.
+func (gr *GestureRecognizer) Tick(now time.Time) []GestureEvent {
+	if gr.down && !gr.dragging && !gr.longPressed &&
+		now.Sub(gr.startTime) >= gr.Config.LongPressTimeout {
+		gr.longPressed = true
+		return []GestureEvent{gr.event(GestureLongPress, now)}
+	}
+	return nil
+}
+
+// Liefert den Zeitpunkt, zu welchem Tick spaetestens aufgerufen werden muss,
+// bzw. den Nullwert, falls kein LongPress anstehen kann.
+func (gr *GestureRecognizer) deadline() time.Time {
+	if gr.down && !gr.dragging && !gr.longPressed {
+		return gr.startTime.Add(gr.Config.LongPressTimeout)
+	}
+	return time.Time{}
+}
+
+// Liest die PenEvents aus in und schreibt die erkannten Gesten nach out,
+// bis in geschlossen wird. Anschliessend wird out geschlossen.
+func (gr *GestureRecognizer) Run(in <-chan PenEvent, out chan<- GestureEvent) {
+	defer close(out)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		var timeout <-chan time.Time
+		if dl := gr.deadline(); !dl.IsZero() {
+			timer.Reset(time.Until(dl))
+			timeout = timer.C
+		}
+		select {
+		case ev, ok := <-in:
+			timer.Stop()
+			if !ok {
+				return
+			}
+			for _, g := range gr.Feed(ev) {
+				out <- g
+			}
+		case now := <-timeout:
+			for _, g := range gr.Tick(now) {
+				out <- g
+			}
+		}
+	}
+}
+
+// Startet die Gestenerkennung auf den Events des Touchscreens und liefert
+// einen Channel mit den erkannten Gesten. Die Events werden dabei aus
+// EventQ gelesen und stehen der Applikation nicht mehr zur Verfuegung.
+func (tch *Touch) Gestures(cfg GestureConfig) <-chan GestureEvent {
+	out := make(chan GestureEvent, eventQueueSize)
+	go NewGestureRecognizer(cfg).Run(tch.EventQ, out)
+	return out
+}
+
+func (gr *GestureRecognizer) event(typ GestureType,
+	t time.Time) GestureEvent {
+	g := GestureEvent{Type: typ, Pos: gr.last, Start: gr.start, Time: t,
+		Duration: t.Sub(gr.startTime)}
+	if typ != GestureTap && typ != GestureDoubleTap &&
+		typ != GestureLongPress {
+		g.VX, g.VY = gr.velocity()
+	}
+	return g
+}
+
+func (gr *GestureRecognizer) dist(a, b TouchPos) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// Haelt den Messwert fest und verwirft alle, welche aelter als
+// VelocityWindow sind (mindestens ein aelterer Wert bleibt erhalten).
+func (gr *GestureRecognizer) addSample(pos TouchPos, t time.Time) {
+	gr.samples = append(gr.samples, gestureSample{pos, t})
+	i := 0
+	for i < len(gr.samples)-2 &&
+		t.Sub(gr.samples[i+1].time) >= gr.Config.VelocityWindow {
+		i++
+	}
+	gr.samples = gr.samples[i:]
+}
+
+// Liefert die Geschwindigkeit ueber die gespeicherten Messwerte.
+func (gr *GestureRecognizer) velocity() (vx, vy float64) {
+	if len(gr.samples) < 2 {
+		return 0.0, 0.0
+	}
+	first, last := gr.samples[0], gr.samples[len(gr.samples)-1]
+	dt := last.time.Sub(first.time).Seconds()
+	if dt <= 0.0 {
+		return 0.0, 0.0
+	}
+	return (last.pos.X - first.pos.X) / dt, (last.pos.Y - first.pos.Y) / dt
+}
+
+func swipeDirection(vx, vy float64) SwipeDirection {
+	if math.Abs(vx) >= math.Abs(vy) {
+		if vx < 0 {
+			return SwipeLeft
+		}
+		return SwipeRight
+	}
+	if vy < 0 {
+		return SwipeUp
+	}
+	return SwipeDown
+}
@@ -0,0 +1,187 @@
+package adatft
+
+import (
+	"testing"
+	"time"
+)
+
+func feedGestures(gr *GestureRecognizer, evs []PenEvent) []GestureType {
+	var res []GestureType
+	for _, ev := range evs {
+		for _, g := range gr.Feed(ev) {
+			res = append(res, g.Type)
+		}
+	}
+	return res
+}
+
+func TestGestureTap(t *testing.T) {
+	testList := []struct {
+		name string
+		evs  []PenEvent
+		want []GestureType
+	}{
+		{"Tap", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenDrag, 103, 102, 0, 50),
+			penEventAt(PenRelease, 103, 102, 0, 100),
+		}, []GestureType{GestureTap}},
+		{"TooLong", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenRelease, 100, 100, 0, 450),
+		}, nil},
+		{"DoubleTap", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenRelease, 100, 100, 0, 80),
+			penEventAt(PenPress, 105, 98, 0, 250),
+			penEventAt(PenRelease, 105, 98, 0, 320),
+		}, []GestureType{GestureTap, GestureDoubleTap}},
+		{"TwoTaps", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenRelease, 100, 100, 0, 80),
+			penEventAt(PenPress, 100, 100, 0, 600),
+			penEventAt(PenRelease, 100, 100, 0, 680),
+		}, []GestureType{GestureTap, GestureTap}},
+		{"TwoTapsApart", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenRelease, 100, 100, 0, 80),
+			penEventAt(PenPress, 200, 100, 0, 200),
+			penEventAt(PenRelease, 200, 100, 0, 280),
+		}, []GestureType{GestureTap, GestureTap}},
+		{"LongPress", []PenEvent{
+			penEventAt(PenPress, 100, 100, 0, 0),
+			penEventAt(PenDrag, 101, 100, 0, 700),
+			penEventAt(PenDrag, 120, 100, 0, 750),
+			penEventAt(PenRelease, 120, 100, 0, 800),
+		}, []GestureType{GestureLongPress}},
+	}
+
+	for _, test := range testList {
+		t.Run(test.name, func(t *testing.T) {
+			gr := NewGestureRecognizer(DefaultGestureConfig)
+			got := feedGestures(gr, test.evs)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGestureLongPressTick(t *testing.T) {
+	gr := NewGestureRecognizer(DefaultGestureConfig)
+	gr.Feed(penEventAt(PenPress, 50, 60, 0, 0))
+	if g := gr.Tick(testT0.Add(500 * time.Millisecond)); len(g) != 0 {
+		t.Fatalf("long press too early: %v", g)
+	}
+	g := gr.Tick(testT0.Add(600 * time.Millisecond))
+	if len(g) != 1 || g[0].Type != GestureLongPress {
+		t.Fatalf("got %v, want long press", g)
+	}
+	if g[0].Pos.X != 50 || g[0].Pos.Y != 60 ||
+		g[0].Duration != 600*time.Millisecond {
+		t.Errorf("long press at %v after %v", g[0].Pos, g[0].Duration)
+	}
+	if g := gr.Tick(testT0.Add(time.Second)); len(g) != 0 {
+		t.Errorf("long press reported twice: %v", g)
+	}
+	if g := gr.Feed(penEventAt(PenRelease, 50, 60, 0, 1100)); len(g) != 0 {
+		t.Errorf("release after long press: %v", g)
+	}
+}
+
+func TestGestureDragSwipe(t *testing.T) {
+	testList := []struct {
+		name    string
+		dx, dy  float64
+		step    int
+		wantDir SwipeDirection
+	}{
+		{"SwipeLeft", -20, 2, 10, SwipeLeft},
+		{"SwipeRight", 20, -3, 10, SwipeRight},
+		{"SwipeUp", 1, -20, 10, SwipeUp},
+		{"SwipeDown", -4, 20, 10, SwipeDown},
+		{"SlowDrag", 10, 0, 100, SwipeNone},
+	}
+
+	for _, test := range testList {
+		t.Run(test.name, func(t *testing.T) {
+			gr := NewGestureRecognizer(DefaultGestureConfig)
+			x, y := 120.0, 160.0
+			evs := []PenEvent{penEventAt(PenPress, x, y, 0, 0)}
+			for i := 1; i <= 5; i++ {
+				x, y = x+test.dx, y+test.dy
+				evs = append(evs, penEventAt(PenDrag, x, y, 0, i*test.step))
+			}
+			evs = append(evs, penEventAt(PenRelease, x, y, 0, 6*test.step))
+
+			var got []GestureEvent
+			for _, ev := range evs {
+				got = append(got, gr.Feed(ev)...)
+			}
+			if got[0].Type != GestureDragStart {
+				t.Fatalf("first gesture is %v", got[0].Type)
+			}
+			for _, g := range got[1:5] {
+				if g.Type != GestureDragMove {
+					t.Fatalf("got %v, want drag move", g.Type)
+				}
+			}
+			if got[5].Type != GestureDragEnd {
+				t.Fatalf("got %v, want drag end", got[5].Type)
+			}
+			if test.wantDir == SwipeNone {
+				if len(got) != 6 {
+					t.Fatalf("unexpected swipe: %v", got[6])
+				}
+				return
+			}
+			if len(got) != 7 || got[6].Type != GestureSwipe {
+				t.Fatalf("no swipe detected: %v", got)
+			}
+			swipe := got[6]
+			if swipe.Direction != test.wantDir {
+				t.Errorf("direction is %v, want %v", swipe.Direction,
+					test.wantDir)
+			}
+			wantVX := test.dx / (float64(test.step) / 1000.0)
+			wantVY := test.dy / (float64(test.step) / 1000.0)
+			if swipe.VX != wantVX || swipe.VY != wantVY {
+				t.Errorf("velocity is (%g,%g), want (%g,%g)", swipe.VX,
+					swipe.VY, wantVX, wantVY)
+			}
+			if swipe.Start.X != 120.0 || swipe.Start.Y != 160.0 ||
+				swipe.Pos.X != x || swipe.Pos.Y != y {
+				t.Errorf("swipe from %v to %v", swipe.Start, swipe.Pos)
+			}
+		})
+	}
+}
+
+func TestGestureRun(t *testing.T) {
+	cfg := DefaultGestureConfig
+	cfg.LongPressTimeout = 20 * time.Millisecond
+	in := make(chan PenEvent)
+	out := make(chan GestureEvent, 10)
+	go NewGestureRecognizer(cfg).Run(in, out)
+
+	in <- PenEvent{Type: PenPress, TouchPos: TouchPos{X: 10, Y: 10},
+		Time: time.Now()}
+	select {
+	case g := <-out:
+		if g.Type != GestureLongPress {
+			t.Errorf("got %v, want long press", g.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no long press reported")
+	}
+	in <- PenEvent{Type: PenRelease, Time: time.Now()}
+	close(in)
+	if g, ok := <-out; ok {
+		t.Errorf("unexpected gesture %v", g.Type)
+	}
+}
@@ -12,6 +12,12 @@ import (
 const (
 	bytesPerPixel       = 2
 	pixfmt        uint8 = 0x05
+
+	// Mit diesen Masken werden die pro Farbkanal effektiv verwendeten Bits
+	// eines 8-Bit Farbwertes ausgewaehlt.
+	redMask   uint8 = 0xF8
+	greenMask uint8 = 0xFC
+	blueMask  uint8 = 0xF8
 )
 
 type ILIColor struct {
@@ -26,6 +32,25 @@ func NewILIColor(r, g, b uint8) ILIColor {
 	return ILIColor{hb, lb}
 }
 
+// Schreibt die Farbwerte r, g und b im 565-Format in den Slice d (welcher
+// mindestens bytesPerPixel lang sein muss).
+func putRGB(d []uint8, r, g, b uint8) {
+	r &= redMask
+	g &= greenMask
+	b &= blueMask
+	d[0] = (r) | (g >> 5)
+	d[1] = (g << 3) | (b >> 3)
+}
+
+// Liest die Farbwerte aus dem Slice d (im 565-Format) und liefert sie als
+// 8-Bit Werte zurueck (analog zu ILIColor.RGBA).
+func getRGB(d []uint8) (r, g, b uint8) {
+	r = d[0] & 0xF8
+	g = ((d[0] & 0x07) << 5) | ((d[1] & 0xE0) >> 3)
+	b = (d[1] << 3) & 0xF8
+	return
+}
+
 func (c ILIColor) RGBA() (r, g, b, a uint32) {
 	r = uint32(c.HB & 0xF8)
 	r |= r << 8
@@ -41,23 +66,7 @@ func iliModel(c color.Color) color.Color {
 	if _, ok := c.(ILIColor); ok {
 		return c
 	}
-	r, g, b, a := c.RGBA()
-	if a == 0xffff {
-		r = (r >> 8)
-		g = (g >> 8)
-		b = (b >> 8)
-		return NewILIColor(uint8(r), uint8(g), uint8(b))
-	}
-	if a == 0x0000 {
-		return NewILIColor(0, 0, 0)
-	}
-	r = (r * 0xffff) / a
-	r = (r >> 8)
-	g = (g * 0xffff) / a
-	g = (g >> 8)
-	b = (b * 0xffff) / a
-	b = (b >> 8)
-	return NewILIColor(uint8(r), uint8(g), uint8(b))
+	return NewILIColor(unpremultiply(c.RGBA()))
 }
 
 var (
@@ -11,6 +11,12 @@ import (
 const (
 	bytesPerPixel       = 3
 	pixfmt        uint8 = 0x06
+
+	// Mit diesen Masken werden die pro Farbkanal effektiv verwendeten Bits
+	// eines 8-Bit Farbwertes ausgewaehlt.
+	redMask   uint8 = 0xFC
+	greenMask uint8 = 0xFC
+	blueMask  uint8 = 0xFC
 )
 
 type ILIColor struct {
@@ -21,6 +27,22 @@ func NewILIColor(r, g, b uint8) ILIColor {
 	return ILIColor{r, g, b}
 }
 
+// Schreibt die Farbwerte r, g und b im 666-Format in den Slice d (welcher
+// mindestens bytesPerPixel lang sein muss). Wie bei NewILIColor werden die
+// Werte unveraendert abgelegt - der Chip ignoriert die beiden niederwertigen
+// Bits.
+func putRGB(d []uint8, r, g, b uint8) {
+	d[0] = r
+	d[1] = g
+	d[2] = b
+}
+
+// Liest die Farbwerte aus dem Slice d (im 666-Format) und liefert sie als
+// 8-Bit Werte zurueck.
+func getRGB(d []uint8) (r, g, b uint8) {
+	return d[0], d[1], d[2]
+}
+
 func (c ILIColor) RGBA() (r, g, b, a uint32) {
 	r = uint32(c.R)
 	r |= r << 8
@@ -36,17 +58,7 @@ func iliModel(c color.Color) color.Color {
 	if _, ok := c.(ILIColor); ok {
 		return c
 	}
-	r, g, b, a := c.RGBA()
-	if a == 0xffff {
-		return ILIColor{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
-	}
-	if a == 0x0000 {
-		return ILIColor{0, 0, 0}
-	}
-	r = (r * 0xffff) / a
-	g = (g * 0xffff) / a
-	b = (b * 0xffff) / a
-	return ILIColor{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+	return NewILIColor(unpremultiply(c.RGBA()))
 }
 
 var (
@@ -0,0 +1,316 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Die Funktionen aus image/draw verwenden fuer Zielbilder, welche sie nicht
+// kennen, den generischen (und langsamen) Weg ueber At und Set. Die
+// Methoden in dieser Datei bieten fuer die haeufigsten Faelle (Fuellen,
+// Kopieren, Skalieren und Zeichnen mit Alpha-Maske) Implementationen an,
+// welche direkt auf den Pixeldaten eines ILIImage arbeiten. Die Semantik
+// entspricht derjenigen von draw.DrawMask: fuer Quellen oder Masken, welche
+// nicht speziell behandelt werden, wird auf draw.DrawMask zurueckgegriffen.
+
+const (
+	// Maximaler Wert eines 16-Bit Farb- oder Alphawertes.
+	alphaMax = 1<<16 - 1
+)
+
+// Liefert die 8-Bit Farbwerte zur vormultiplizierten 16-Bit Farbe
+// (r, g, b, a), so wie sie (ohne Alpha-Kanal) in einem ILIImage abgelegt
+// werden. Alle Zeichenwege (und ILIModel) verwenden diese Funktion, damit
+// draw.Src mit nicht deckenden Farben ueberall das gleiche Resultat ergibt.
+func unpremultiply(r, g, b, a uint32) (uint8, uint8, uint8) {
+	switch a {
+	case 0:
+		return 0, 0, 0
+	case alphaMax:
+	default:
+		r = min(r*alphaMax/a, alphaMax)
+		g = min(g*alphaMax/a, alphaMax)
+		b = min(b*alphaMax/a, alphaMax)
+	}
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
+
+// Zeichnet den Bereich r des Bildes src (beginnend bei sp) in das Bild p.
+// Entspricht draw.Draw(p, r, src, sp, op).
+func (p *ILIImage) Draw(r image.Rectangle, src image.Image, sp image.Point,
+	op draw.Op) {
+	p.DrawMask(r, src, sp, nil, image.Point{}, op)
+}
+
+// Fuellt den Bereich r mit der Farbe c. Ist c nicht deckend, wird die Farbe
+// ueber den bestehenden Inhalt gelegt (draw.Over).
+func (p *ILIImage) Fill(r image.Rectangle, c color.Color) {
+	p.DrawMask(r, image.NewUniform(c), image.Point{}, nil, image.Point{},
+		draw.Over)
+}
+
+// Entspricht draw.DrawMask(p, r, src, sp, mask, mp, op). Speziell behandelt
+// werden als Quelle image.Uniform, image.RGBA und ILIImage, als Maske nil,
+// image.Alpha und image.Uniform.
+func (p *ILIImage) DrawMask(r image.Rectangle, src image.Image, sp image.Point,
+	mask image.Image, mp image.Point, op draw.Op) {
+	r, sp, mp = p.clip(r, src, sp, mask, mp)
+	if r.Empty() {
+		return
+	}
+
+	if mask == nil {
+		switch s := src.(type) {
+		case *image.Uniform:
+			_, _, _, sa := s.RGBA()
+			if op == draw.Src || sa == alphaMax {
+				p.fillColor(r, ILIModel.Convert(s.C).(ILIColor))
+				return
+			}
+		case *ILIImage:
+			p.copyRect(r, s, sp)
+			return
+		case *image.RGBA:
+			if op == draw.Src {
+				p.copyRGBA(r, s, sp)
+				return
+			}
+		}
+	}
+
+	srcFn := pixelSource(src)
+	maskFn := maskSource(mask)
+	if srcFn == nil || maskFn == nil {
+		draw.DrawMask(p, r, src, sp, mask, mp, op)
+		return
+	}
+	p.compose(r, srcFn, sp, maskFn, mp, op)
+}
+
+// Skaliert den Bereich sr des Bildes src auf den Bereich dr von p. Es wird
+// das Verfahren 'naechster Nachbar' verwendet. Fuer Quellen vom Typ ILIImage
+// und image.RGBA werden die Pixeldaten direkt kopiert, fuer alle anderen
+// wird xdraw.NearestNeighbor verwendet.
+func (p *ILIImage) Scale(dr image.Rectangle, src image.Image,
+	sr image.Rectangle, op draw.Op) {
+	sr = sr.Intersect(src.Bounds())
+	clipped := dr.Intersect(p.Rect)
+	if clipped.Empty() || sr.Empty() {
+		return
+	}
+
+	var pixFn func(d []uint8, sx, sy int)
+	switch s := src.(type) {
+	case *ILIImage:
+		pixFn = func(d []uint8, sx, sy int) {
+			idx := s.PixOffset(sx, sy)
+			copy(d, s.Pix[idx:idx+bytesPerPixel])
+		}
+	case *image.RGBA:
+		if op == draw.Src || s.Opaque() {
+			pixFn = func(d []uint8, sx, sy int) {
+				idx := s.PixOffset(sx, sy)
+				putRGB(d, s.Pix[idx], s.Pix[idx+1], s.Pix[idx+2])
+			}
+		}
+	}
+	if pixFn == nil {
+		xdraw.NearestNeighbor.Scale(p, dr, src, sr, op, nil)
+		return
+	}
+
+	// Die Spalten der Quelle werden nur einmal berechnet.
+	xs := make([]int, clipped.Dx())
+	for i := range xs {
+		x := clipped.Min.X + i - dr.Min.X
+		xs[i] = sr.Min.X + (2*x+1)*sr.Dx()/(2*dr.Dx())
+	}
+	for y := clipped.Min.Y; y < clipped.Max.Y; y++ {
+		sy := sr.Min.Y + (2*(y-dr.Min.Y)+1)*sr.Dy()/(2*dr.Dy())
+		idx := p.PixOffset(clipped.Min.X, y)
+		for _, sx := range xs {
+			pixFn(p.Pix[idx:idx+bytesPerPixel:idx+bytesPerPixel], sx, sy)
+			idx += bytesPerPixel
+		}
+	}
+}
+
+// Schraenkt den Bereich r so ein, dass er vollstaendig in p, src und mask
+// liegt. Die Punkte sp und mp werden entsprechend angepasst (analog zur
+// Funktion clip aus image/draw).
+func (p *ILIImage) clip(r image.Rectangle, src image.Image, sp image.Point,
+	mask image.Image, mp image.Point) (image.Rectangle, image.Point,
+	image.Point) {
+	orig := r.Min
+	r = r.Intersect(p.Rect)
+	r = r.Intersect(src.Bounds().Add(orig.Sub(sp)))
+	if mask != nil {
+		r = r.Intersect(mask.Bounds().Add(orig.Sub(mp)))
+	}
+	dx, dy := r.Min.X-orig.X, r.Min.Y-orig.Y
+	sp = sp.Add(image.Point{dx, dy})
+	mp = mp.Add(image.Point{dx, dy})
+	return r, sp, mp
+}
+
+// Fuellt den Bereich r mit der Farbe c. Die erste Zeile wird Pixel fuer
+// Pixel beschrieben, alle weiteren werden davon kopiert.
+func (p *ILIImage) fillColor(r image.Rectangle, c ILIColor) {
+	var pix [bytesPerPixel]uint8
+	r0, g0, b0, _ := c.RGBA()
+	putRGB(pix[:], uint8(r0>>8), uint8(g0>>8), uint8(b0>>8))
+
+	n := r.Dx() * bytesPerPixel
+	idx0 := p.PixOffset(r.Min.X, r.Min.Y)
+	row := p.Pix[idx0 : idx0+n : idx0+n]
+	for i := 0; i < n; i += bytesPerPixel {
+		copy(row[i:], pix[:])
+	}
+	idx := idx0 + p.Stride
+	for y := r.Min.Y + 1; y < r.Max.Y; y++ {
+		copy(p.Pix[idx:idx+n], row)
+		idx += p.Stride
+	}
+}
+
+// Kopiert den Bereich r aus dem RGBA-Bild src (beginnend bei sp) nach p.
+// Ein allfaelliger Alpha-Kanal wird ignoriert (draw.Src mit deckenden
+// Farben).
+func (p *ILIImage) copyRGBA(r image.Rectangle, src *image.RGBA,
+	sp image.Point) {
+	for y := 0; y < r.Dy(); y++ {
+		srcIdx := src.PixOffset(sp.X, sp.Y+y)
+		dstIdx := p.PixOffset(r.Min.X, r.Min.Y+y)
+		for x := 0; x < r.Dx(); x++ {
+			s := src.Pix[srcIdx : srcIdx+4 : srcIdx+4]
+			d := p.Pix[dstIdx : dstIdx+bytesPerPixel : dstIdx+bytesPerPixel]
+			if s[3] == 0xff {
+				putRGB(d, s[0], s[1], s[2])
+			} else {
+				cr, cg, cb := unpremultiply(uint32(s[0])*0x101,
+					uint32(s[1])*0x101, uint32(s[2])*0x101, uint32(s[3])*0x101)
+				putRGB(d, cr, cg, cb)
+			}
+			srcIdx += 4
+			dstIdx += bytesPerPixel
+		}
+	}
+}
+
+// Liefert fuer die bekannten Bildtypen eine Funktion, welche die
+// (vormultiplizierten) 16-Bit Farbwerte an einer Position liefert. Fuer
+// unbekannte Typen wird nil retourniert.
+func pixelSource(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch s := src.(type) {
+	case *image.Uniform:
+		r, g, b, a := s.RGBA()
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			return r, g, b, a
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			i := s.PixOffset(x, y)
+			c := s.Pix[i : i+4 : i+4]
+			return uint32(c[0]) * 0x101, uint32(c[1]) * 0x101,
+				uint32(c[2]) * 0x101, uint32(c[3]) * 0x101
+		}
+	case *ILIImage:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			i := s.PixOffset(x, y)
+			r, g, b := getRGB(s.Pix[i : i+bytesPerPixel : i+bytesPerPixel])
+			return uint32(r) * 0x101, uint32(g) * 0x101, uint32(b) * 0x101,
+				alphaMax
+		}
+	}
+	return nil
+}
+
+// Analog zu pixelSource, jedoch fuer die Maske. Ist mask nil, wird eine
+// Funktion retourniert, welche immer den maximalen Wert liefert.
+func maskSource(mask image.Image) func(x, y int) uint32 {
+	switch mk := mask.(type) {
+	case nil:
+		return func(x, y int) uint32 {
+			return alphaMax
+		}
+	case *image.Uniform:
+		_, _, _, a := mk.RGBA()
+		return func(x, y int) uint32 {
+			return a
+		}
+	case *image.Alpha:
+		return func(x, y int) uint32 {
+			return uint32(mk.Pix[mk.PixOffset(x, y)]) * 0x101
+		}
+	}
+	return nil
+}
+
+// Setzt die Quelle (via srcFn) mit der Maske (via maskFn) auf den Bereich r
+// von p zusammen. Die Formeln entsprechen denjenigen aus image/draw, wobei
+// das Resultat (wie bei ILIImage.Set) als deckende Farbe abgelegt wird.
+func (p *ILIImage) compose(r image.Rectangle,
+	srcFn func(x, y int) (r, g, b, a uint32), sp image.Point,
+	maskFn func(x, y int) uint32, mp image.Point, op draw.Op) {
+	for y := 0; y < r.Dy(); y++ {
+		idx := p.PixOffset(r.Min.X, r.Min.Y+y)
+		for x := 0; x < r.Dx(); x++ {
+			d := p.Pix[idx : idx+bytesPerPixel : idx+bytesPerPixel]
+			idx += bytesPerPixel
+			ma := maskFn(mp.X+x, mp.Y+y)
+			if ma == 0 && op == draw.Over {
+				continue
+			}
+			sr, sg, sb, sa := srcFn(sp.X+x, sp.Y+y)
+			var or, og, ob, oa uint32
+			if op == draw.Over {
+				dr, dg, db := getRGB(d)
+				a := alphaMax - (sa * ma / alphaMax)
+				or = (uint32(dr)*0x101*a + sr*ma) / alphaMax
+				og = (uint32(dg)*0x101*a + sg*ma) / alphaMax
+				ob = (uint32(db)*0x101*a + sb*ma) / alphaMax
+				oa = alphaMax
+			} else {
+				or, og, ob = sr*ma/alphaMax, sg*ma/alphaMax, sb*ma/alphaMax
+				oa = sa * ma / alphaMax
+			}
+			cr, cg, cb := unpremultiply(or, og, ob, oa)
+			putRGB(d, cr, cg, cb)
+		}
+	}
+}
+
+// Die folgenden Methoden entsprechen denjenigen von ILIImage, markieren
+// jedoch die betroffenen Bereiche als veraendert.
+
+func (fb *Framebuffer) Draw(r image.Rectangle, src image.Image, sp image.Point,
+	op draw.Op) {
+	fb.DrawMask(r, src, sp, nil, image.Point{}, op)
+}
+
+func (fb *Framebuffer) Fill(r image.Rectangle, c color.Color) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.img.Fill(r, c)
+	fb.markDirty(r)
+}
+
+func (fb *Framebuffer) DrawMask(r image.Rectangle, src image.Image,
+	sp image.Point, mask image.Image, mp image.Point, op draw.Op) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	clipped, _, _ := fb.img.clip(r, src, sp, mask, mp)
+	fb.img.DrawMask(r, src, sp, mask, mp, op)
+	fb.markDirty(clipped)
+}
+
+func (fb *Framebuffer) Scale(dr image.Rectangle, src image.Image,
+	sr image.Rectangle, op draw.Op) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.img.Scale(dr, src, sr, op)
+	fb.markDirty(dr)
+}
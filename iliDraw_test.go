@@ -0,0 +1,176 @@
+package adatft
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// Mit diesem Typ wird der Typ des Zielbildes vor image/draw versteckt, so
+// dass dort zwingend der generische Weg ueber Set verwendet wird.
+type genericImage struct {
+	*ILIImage
+}
+
+var (
+	drawRand = rand.New(rand.NewSource(12_345))
+)
+
+// Erstellt ein RGBA-Bild mit zufaelligen (vormultiplizierten) Farben.
+func randomRGBA(r image.Rectangle, opaque bool) *image.RGBA {
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := uint8(0xff)
+		if !opaque {
+			a = uint8(drawRand.Intn(256))
+		}
+		img.Pix[i+0] = uint8(drawRand.Intn(int(a) + 1))
+		img.Pix[i+1] = uint8(drawRand.Intn(int(a) + 1))
+		img.Pix[i+2] = uint8(drawRand.Intn(int(a) + 1))
+		img.Pix[i+3] = a
+	}
+	return img
+}
+
+func randomAlpha(r image.Rectangle) *image.Alpha {
+	img := image.NewAlpha(r)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(drawRand.Intn(256))
+	}
+	return img
+}
+
+// Vergleicht zwei Bilder pixelweise; Abweichungen bis zu einer Stufe des
+// Pixelformats werden toleriert (Rundungsfehler).
+func compareILI(t *testing.T, name string, a, b *ILIImage) {
+	t.Helper()
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			r1, g1, b1, _ := a.ILIColorAt(x, y).RGBA()
+			r2, g2, b2, _ := b.ILIColorAt(x, y).RGBA()
+			for _, d := range []int{int(r1>>8) - int(r2>>8),
+				int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				if d > 8 || d < -8 {
+					t.Fatalf("%s: pixel (%d,%d) differs: %v != %v", name,
+						x, y, a.ILIColorAt(x, y), b.ILIColorAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestDrawMaskFastPaths(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 30)
+	back := randomRGBA(rect, true)
+	dr := image.Rect(5, 3, 38, 25)
+	sp := image.Pt(2, 1)
+
+	srcList := map[string]image.Image{
+		"uniform":     image.NewUniform(color.RGBA{0x80, 0x20, 0x40, 0xff}),
+		"uniformHalf": image.NewUniform(color.RGBA{0x40, 0x10, 0x20, 0x80}),
+		"rgba":        randomRGBA(rect, true),
+		"rgbaAlpha":   randomRGBA(rect, false),
+	}
+	iliSrc := NewILIImage(rect)
+	iliSrc.Convert(randomRGBA(rect, true))
+	srcList["ili"] = iliSrc
+
+	maskList := map[string]image.Image{
+		"nil":     nil,
+		"alpha":   randomAlpha(rect),
+		"uniform": image.NewUniform(color.Alpha{0x60}),
+	}
+
+	for srcName, src := range srcList {
+		for maskName, mask := range maskList {
+			for _, op := range []draw.Op{draw.Over, draw.Src} {
+				fast := NewILIImage(rect)
+				fast.Convert(back)
+				slow := NewILIImage(rect)
+				slow.Convert(back)
+
+				fast.DrawMask(dr, src, sp, mask, sp, op)
+				draw.DrawMask(genericImage{slow}, dr, src, sp, mask, sp, op)
+				compareILI(t, srcName+"/"+maskName, fast, slow)
+			}
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	src := randomRGBA(image.Rect(0, 0, 16, 16), true)
+	iliSrc := NewILIImage(src.Rect)
+	iliSrc.Convert(src)
+
+	// Vergroesserung um den Faktor 2: jedes Quellpixel wird zu einem
+	// 2x2-Block.
+	for _, s := range []image.Image{src, iliSrc} {
+		dst := NewILIImage(image.Rect(0, 0, 32, 32))
+		dst.Scale(dst.Rect, s, s.Bounds(), draw.Src)
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				if dst.ILIColorAt(x, y) != iliSrc.ILIColorAt(x/2, y/2) {
+					t.Fatalf("%T: pixel (%d,%d) differs", s, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestFramebufferDraw(t *testing.T) {
+	fb := newFramebuffer(image.Rect(0, 0, 100, 100))
+	fb.Fill(image.Rect(-10, -10, 20, 20), color.White)
+	if len(fb.Dirty()) != 1 || fb.Dirty()[0] != image.Rect(0, 0, 20, 20) {
+		t.Errorf("fill: got %v", fb.Dirty())
+	}
+	if fb.At(5, 5) != ILIModel.Convert(color.White) {
+		t.Errorf("fill: pixel not set")
+	}
+}
+
+// Die schnellen Wege fuer draw.Src muessen nicht deckende Farben genau
+// gleich behandeln wie compose.
+func TestDrawSrcUnpremultiply(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	for name, src := range map[string]image.Image{
+		"uniform": image.NewUniform(color.RGBA{0x40, 0x10, 0x20, 0x80}),
+		"rgba":    randomRGBA(rect, false),
+	} {
+		fast := NewILIImage(rect)
+		fast.DrawMask(rect, src, image.Point{}, nil, image.Point{}, draw.Src)
+		slow := NewILIImage(rect)
+		slow.compose(rect, pixelSource(src), image.Point{}, maskSource(nil),
+			image.Point{}, draw.Src)
+		if !bytes.Equal(fast.Pix, slow.Pix) {
+			t.Errorf("%s: fast path differs from compose", name)
+		}
+	}
+}
+
+// Ueberlappende Kopien innerhalb des gleichen Bildes muessen in alle
+// Richtungen das gleiche Resultat liefern wie eine Kopie ueber ein
+// separates Bild.
+func TestCopyRectOverlap(t *testing.T) {
+	rect := image.Rect(0, 0, 32, 24)
+	orig := NewILIImage(rect)
+	orig.Convert(randomRGBA(rect, true))
+	r := image.Rect(4, 4, 24, 18)
+	for _, d := range []image.Point{{3, 2}, {-3, -2}, {3, -2}, {-3, 2},
+		{0, 1}, {1, 0}, {0, -1}, {-1, 0}} {
+		got := NewILIImage(rect)
+		copy(got.Pix, orig.Pix)
+		got.copyRect(r.Add(d), got, r.Min)
+
+		want := NewILIImage(rect)
+		copy(want.Pix, orig.Pix)
+		tmp := NewILIImage(rect)
+		copy(tmp.Pix, orig.Pix)
+		want.copyRect(r.Add(d), tmp, r.Min)
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("shift %v: overlapping copy corrupted data", d)
+		}
+	}
+}
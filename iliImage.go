@@ -12,6 +12,12 @@ type ILIImage struct {
 	Rect   image.Rectangle
 	Stride int
 	Pix    []uint8
+	// Einstellungen fuer die Konvertierung (Dithering, etc.). Ist conv
+	// nil, wird ohne jegliche Nachbearbeitung konvertiert.
+	conv *convSettings
+	// Messungen der Latenz, welche mit diesem Bild abgeschlossen werden
+	// (siehe Display.Draw).
+	traces []*LatencyTrace
 }
 
 func NewILIImage(r image.Rectangle) *ILIImage {
@@ -50,6 +56,7 @@ func (p *ILIImage) SubImage(r image.Rectangle) image.Image {
 		Rect:   r,
 		Stride: p.Stride,
 		Pix:    p.Pix[idx:],
+		conv:   p.conv,
 	}
 }
 
@@ -63,3 +70,33 @@ func (p *ILIImage) Clear() {
 		p.Pix[i] = 0x00
 	}
 }
+
+// Kopiert den Bereich r aus dem Bild src in das Bild p. Der Punkt sp in src
+// entspricht dabei der linken oberen Ecke von r. Da beide Bilder das gleiche
+// Pixelformat verwenden, koennen die Daten zeilenweise kopiert werden.
+// p und src duerfen sich ueberlappen (z.B. beim Verschieben eines Bereiches
+// innerhalb des gleichen Bildes): liegt das Ziel unterhalb der Quelle,
+// werden die Zeilen von unten nach oben kopiert. Innerhalb einer Zeile
+// behandelt copy die Ueberlappung selber (auch nach rechts).
+func (p *ILIImage) copyRect(r image.Rectangle, src *ILIImage, sp image.Point) {
+	r0 := r
+	r = r.Intersect(p.Rect).Intersect(src.Rect.Add(r0.Min.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(r0.Min))
+	n := r.Dx() * bytesPerPixel
+	dstIdx := p.PixOffset(r.Min.X, r.Min.Y)
+	srcIdx := src.PixOffset(sp.X, sp.Y)
+	dstStride, srcStride := p.Stride, src.Stride
+	if r.Min.Y > sp.Y {
+		dstIdx += (r.Dy() - 1) * dstStride
+		srcIdx += (r.Dy() - 1) * srcStride
+		dstStride, srcStride = -dstStride, -srcStride
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(p.Pix[dstIdx:dstIdx+n], src.Pix[srcIdx:srcIdx+n])
+		dstIdx += dstStride
+		srcIdx += srcStride
+	}
+}
@@ -109,13 +109,21 @@ Loop4:
 
 // Konvertiert die Bilddaten des Bildes hinter src (RGBA-Image) in ein
 // ILI-spezifisches Bild. Dabei kann mit Rect (d.h. Bounds()) bestimmt werden
-// welcher Bereich konvertiert werden soll.
+// welcher Bereich konvertiert werden soll. Ist fuer das Bild ein Dithering
+// oder eine Farbkorrektur eingestellt (siehe Display.SetDither und
+// Display.SetColorTransform), wird dies hier beruecksichtigt.
 func (p *ILIImage) Convert(src *image.RGBA) {
 	var row, col int
 	var srcBaseIdx, srcIdx, dstBaseIdx, dstIdx int
 
 	ConvWatch.Start()
 
+	if cp := p.conv.load(); cp.active() {
+		p.convertParams(src, cp)
+		ConvWatch.Stop()
+		return
+	}
+
 	srcBaseIdx = 0
 	dstBaseIdx = src.Rect.Min.Y*p.Stride + src.Rect.Min.X*bytesPerPixel
 	for row = src.Rect.Min.Y; row < src.Rect.Max.Y; row++ {
@@ -0,0 +1,87 @@
+package adatft
+
+import (
+	"math"
+)
+
+// Rechnet die Bildschirmposition pos (in der Rotation Rot) in die Rohdaten
+// um, welche der Touchscreen an dieser Stelle liefern wuerde (Umkehrung
+// von Transform). Damit koennen Beruehrungen fuer Simulation, Wiedergabe
+// oder zur Ueberpruefung einer Kalibrierung in Pixeln angegeben werden. Die
+// Rohdaten werden gerundet und auf den Wertebereich des Touchscreens
+// begrenzt. Ist die Abbildung nicht umkehrbar, wird der Nullwert geliefert.
+func (d *DistortedPlane) Inverse(pos TouchPos) TouchRawPos {
+	var rawPos TouchRawPos
+
+	rx, ry, err := d.inverse(pos)
+	if err != nil {
+		return rawPos
+	}
+	rawPos.RawX = uint16(max(0.0, min(touchRawMax, math.Round(rx))))
+	rawPos.RawY = uint16(max(0.0, min(touchRawMax, math.Round(ry))))
+	if d.RawZmin != d.RawZmax && d.Zmin != d.Zmax {
+		rz := Map(pos.Z, d.Zmin, d.Zmax, float64(d.RawZmin),
+			float64(d.RawZmax))
+		rawPos.RawZ = uint8(max(0.0, min(255.0, math.Round(rz))))
+	}
+	return rawPos
+}
+
+// Liefert die (nicht gerundeten) Rohdaten zur Position pos. Die affinen
+// und das perspektivische Modell werden ueber die inverse Matrix
+// umgerechnet, beim bilinearen Modell wird ausgehend von einer Naeherung
+// das Newton-Verfahren angewendet.
+func (d *DistortedPlane) inverse(pos TouchPos) (rx, ry float64, err error) {
+	pos = d.ToNative(pos)
+	m, err := d.matrix(false)
+	if err != nil {
+		return 0, 0, err
+	}
+	inv, err := m.inverse()
+	if err != nil {
+		return 0, 0, err
+	}
+	rx, ry = inv.apply(pos.X, pos.Y)
+	if d.Model != CalibBilinear {
+		return rx, ry, nil
+	}
+
+	c := d.Coeff
+	for range 20 {
+		fx := c[0] + c[1]*rx + c[2]*ry + c[3]*rx*ry - pos.X
+		fy := c[4] + c[5]*rx + c[6]*ry + c[7]*rx*ry - pos.Y
+		a, b := c[1]+c[3]*ry, c[2]+c[3]*rx
+		e, f := c[5]+c[7]*ry, c[6]+c[7]*rx
+		det := a*f - b*e
+		if math.Abs(det) < 1e-12 {
+			return 0, 0, ErrCalibDegenerate
+		}
+		dx, dy := (f*fx-b*fy)/det, (a*fy-e*fx)/det
+		rx, ry = rx-dx, ry-dy
+		if math.Abs(dx) < 1e-6 && math.Abs(dy) < 1e-6 {
+			return rx, ry, nil
+		}
+	}
+	return rx, ry, ErrCalibDegenerate
+}
+
+// Erzeugt ein Event an der Bildschirmposition pos, als kaeme es vom
+// Touchscreen: die Rohdaten werden mit Inverse berechnet und durchlaufen
+// anschliessend die gleiche Verarbeitung (Abbildung, Filter, Queue bzw.
+// Abonnenten) wie echte Messwerte. Liefert false, falls das Event von einem
+// Filter verworfen wurde.
+func (tch *Touch) Simulate(typ PenEventType, pos TouchPos) bool {
+	plane := tch.Plane()
+	sample := PenEvent{Type: typ, TouchRawPos: plane.Inverse(pos)}
+	sample.TouchPos, _ = plane.Transform(sample.TouchRawPos)
+	if typ == PenRelease {
+		tch.enqueueEvent(sample)
+		tch.resetFilters()
+		return true
+	}
+	if !tch.filter(&sample) {
+		return false
+	}
+	tch.enqueueEvent(sample)
+	return true
+}
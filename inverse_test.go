@@ -0,0 +1,112 @@
+package adatft
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func inverseTestPlane(t *testing.T, cm CalibModel,
+	rot RotationType) *DistortedPlane {
+	t.Helper()
+	d := &DistortedPlane{Rot: rot, Model: cm, Width: 240, Height: 320}
+	raw, pos := modelTestPoints(modelTestMaps[cm], 3)
+	d.RefPoints, _ = RefPoints(9)
+	d.RawPosList, d.PosList = raw, pos
+	if err := d.Compute(); err != nil {
+		t.Fatalf("%v: %v", cm, err)
+	}
+	d.SetZRange(0, 127, 1.0, 0.0)
+	return d
+}
+
+// Fuer alle Modelle und Rotationen muss Inverse die Umkehrung von Transform
+// sein: sowohl ausgehend von Rohdaten als auch von Bildschirmpositionen.
+func TestInverseRoundTrip(t *testing.T) {
+	testRand := rand.New(rand.NewSource(1_234))
+	for rot := Rotate000; rot <= Rotate270; rot++ {
+		for cm := CalibLinear; cm < NumCalibModels; cm++ {
+			d := inverseTestPlane(t, cm, rot)
+			for range 200 {
+				raw := TouchRawPos{
+					RawX: uint16(400 + testRand.Intn(3200)),
+					RawY: uint16(350 + testRand.Intn(3300)),
+					RawZ: uint8(testRand.Intn(128)),
+				}
+				pos, err := d.Transform(raw)
+				if err != nil {
+					t.Fatalf("%v/%v: %v", rot, cm, err)
+				}
+				rx, ry, err := d.inverse(pos)
+				if err != nil {
+					t.Fatalf("%v/%v: %v", rot, cm, err)
+				}
+				if math.Hypot(rx-float64(raw.RawX),
+					ry-float64(raw.RawY)) > 1e-6 {
+					t.Errorf("%v/%v: %v -> %v -> (%.3f, %.3f)", rot, cm,
+						raw, pos, rx, ry)
+				}
+				if got := d.Inverse(pos); got != raw {
+					t.Errorf("%v/%v: %v -> %v -> %v", rot, cm, raw, pos,
+						got)
+				}
+			}
+
+			b := d.Bounds()
+			for range 200 {
+				pos := TouchPos{
+					X: float64(b.Min.X) + float64(b.Dx()-1)*testRand.Float64(),
+					Y: float64(b.Min.Y) + float64(b.Dy()-1)*testRand.Float64(),
+					Z: testRand.Float64(),
+				}
+				// Positionen, welche ausserhalb des Touchscreens liegen,
+				// koennen nicht exakt abgebildet werden.
+				if rx, ry, _ := d.inverse(pos); rx < 0 || ry < 0 ||
+					rx > touchRawMax || ry > touchRawMax {
+					continue
+				}
+				got, err := d.Transform(d.Inverse(pos))
+				if err != nil {
+					t.Fatalf("%v/%v: %v", rot, cm, err)
+				}
+				if math.Hypot(got.X-pos.X, got.Y-pos.Y) > 0.1 ||
+					math.Abs(got.Z-pos.Z) > 0.01 {
+					t.Errorf("%v/%v: %v -> %v", rot, cm, pos, got)
+				}
+			}
+		}
+	}
+}
+
+func TestInverseClamp(t *testing.T) {
+	d := inverseTestPlane(t, CalibAffine, Rotate000)
+	raw := d.Inverse(TouchPos{X: -1000, Y: 5000})
+	if raw.RawX != touchRawMax || raw.RawY != touchRawMax {
+		t.Errorf("got %v, want raw values clamped to %d", raw, touchRawMax)
+	}
+	d.Coeff = nil
+	if raw := d.Inverse(TouchPos{X: 10, Y: 10}); raw != (TouchRawPos{}) {
+		t.Errorf("invalid plane: got %v", raw)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	tch.plane = *inverseTestPlane(t, CalibBilinear, Rotate090)
+	tch.SetFilters(&EdgeFilter{Bounds: tch.Bounds(), Margin: 10})
+
+	if tch.Simulate(PenPress, TouchPos{X: 2, Y: 100}) {
+		t.Errorf("event at the border not filtered")
+	}
+	want := TouchPos{X: 100, Y: 150, Z: 0.5}
+	if !tch.Simulate(PenPress, want) || !tch.Simulate(PenRelease, want) {
+		t.Fatalf("events rejected")
+	}
+	for _, typ := range []PenEventType{PenPress, PenRelease} {
+		ev := <-tch.EventQ
+		if ev.Type != typ || math.Hypot(ev.X-want.X, ev.Y-want.Y) > 0.1 {
+			t.Errorf("got %v at %v, want %v at %v", ev.Type, ev.TouchPos,
+				typ, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package adatft
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mit der Latenzmessung wird ermittelt, wie lange es von einer Beruehrung
+// bis zum entsprechenden Bild auf dem TFT dauert. Dazu werden fuer jedes
+// Event die folgenden Zeitpunkte festgehalten:
+//
+//   - Edge: Flanke am Interrupt-Pin (siehe STMPE610.SetCallback), resp. die
+//     Erkennung der Daten im Polling-Modus,
+//   - Read: die Messwerte wurden aus der FIFO gelesen,
+//   - Enqueue: das Event wurde in die Queue gestellt (oder einem Abonnenten
+//     zugestellt),
+//   - Draw: die Applikation zeichnet als Reaktion auf das Event (siehe
+//     TraceDraw),
+//   - Display: das Bild, welches die Applikation danach dem Display
+//     uebergeben hat (Draw, DrawSync, DrawILI, DrawRegion oder Flush), wurde
+//     vollstaendig zum TFT gesendet. Unterscheidet es sich nicht vom
+//     aktuellen Bildschirminhalt, gilt es als dargestellt, sobald dies
+//     feststeht.
+//
+// Die Messung ist standardmaessig ausgeschaltet (siehe EnableLatency). Die
+// Statistik wird von PrintStat ausgegeben.
+
+const (
+	// Anzahl der letzten vollstaendigen Messungen, ueber welche die
+	// Statistik berechnet wird.
+	latencyWindow = 1024
+)
+
+// Die Zeitpunkte eines Events auf dem Weg vom Touchscreen zum TFT.
+type LatencyTrace struct {
+	Edge, Read, Enqueue, Draw, Display time.Time
+}
+
+// Die Abschnitte, fuer welche die Latenz ausgewertet wird.
+type LatencyStage uint8
+
+const (
+	// Von der Flanke bis zum Lesen der FIFO.
+	LatencyRead LatencyStage = iota
+	// Vom Lesen der FIFO bis zum Einreihen des Events.
+	LatencyEnqueue
+	// Vom Einreihen des Events bis zum Zeichnen durch die Applikation.
+	LatencyDraw
+	// Vom Zeichnen bis das Bild zum TFT gesendet wurde.
+	LatencyDisplay
+	// Von der Flanke bis das Bild zum TFT gesendet wurde.
+	LatencyTotal
+	numLatencyStages
+)
+
+func (ls LatencyStage) String() string {
+	switch ls {
+	case LatencyRead:
+		return "edge to FIFO read"
+	case LatencyEnqueue:
+		return "FIFO read to enqueue"
+	case LatencyDraw:
+		return "enqueue to draw"
+	case LatencyDisplay:
+		return "draw to display"
+	case LatencyTotal:
+		return "edge to display"
+	}
+	return "(unknown stage)"
+}
+
+// Liefert die Dauer des Abschnittes stage.
+func (lt LatencyTrace) Duration(stage LatencyStage) time.Duration {
+	switch stage {
+	case LatencyRead:
+		return lt.Read.Sub(lt.Edge)
+	case LatencyEnqueue:
+		return lt.Enqueue.Sub(lt.Read)
+	case LatencyDraw:
+		return lt.Draw.Sub(lt.Enqueue)
+	case LatencyDisplay:
+		return lt.Display.Sub(lt.Draw)
+	case LatencyTotal:
+		return lt.Display.Sub(lt.Edge)
+	}
+	return 0
+}
+
+// Statistik ueber die Dauer eines Abschnittes.
+type LatencyStats struct {
+	Num                int
+	Min, Avg, P95, Max time.Duration
+}
+
+type latencyTracker struct {
+	enabled atomic.Bool
+	mutex   sync.Mutex
+	// Events, zu welchen gezeichnet wurde, deren Bild aber noch nicht
+	// dem Display uebergeben ist.
+	pending []*LatencyTrace
+	// Die letzten vollstaendigen Messungen (Ringpuffer).
+	done []LatencyTrace
+	next int
+}
+
+var (
+	latency = &latencyTracker{}
+)
+
+// Schaltet die Latenzmessung ein bzw. aus.
+func EnableLatency(enable bool) {
+	latency.enabled.Store(enable)
+	if !enable {
+		latency.mutex.Lock()
+		latency.pending = nil
+		latency.mutex.Unlock()
+	}
+}
+
+// Liefert true, falls die Latenzmessung eingeschaltet ist.
+func LatencyEnabled() bool {
+	return latency.enabled.Load()
+}
+
+// Wird von Touch-Controllern implementiert, welche den Zeitpunkt der
+// Flanke am Interrupt-Pin festhalten.
+type edgeTimer interface {
+	EdgeTime() time.Time
+}
+
+// Liefert den Zeitpunkt, zu welchem der Controller die aktuellen Daten
+// gemeldet hat, bzw. die aktuelle Zeit, falls dieser nicht bekannt ist.
+func (tch *Touch) edgeTime() time.Time {
+	if et, ok := tch.tspi.(edgeTimer); ok {
+		if t := et.EdgeTime(); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// Beginnt die Messung fuer ein neues Event, dessen Daten bei edge erkannt
+// und bei read gelesen wurden. Liefert nil, falls die Messung
+// ausgeschaltet ist.
+func newLatencyTrace(edge, read time.Time) *LatencyTrace {
+	if !latency.enabled.Load() {
+		return nil
+	}
+	return &LatencyTrace{Edge: edge, Read: read}
+}
+
+// Muss von der Applikation aufgerufen werden, sobald sie als Reaktion auf
+// das Event ev zu zeichnen beginnt. Die Messung wird abgeschlossen, sobald
+// das naechste Bild, welches die Applikation dem Display uebergibt, zum TFT
+// gesendet wurde. Wird zu einem Event
+// mehrmals gezeichnet, zaehlt nur der erste Aufruf. Events ohne Messung
+// (z.B. bei ausgeschalteter Latenzmessung oder aus einer Aufzeichnung)
+// werden ignoriert.
+func TraceDraw(ev PenEvent) {
+	if ev.trace == nil || !latency.enabled.Load() {
+		return
+	}
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	if !ev.trace.Draw.IsZero() {
+		return
+	}
+	ev.trace.Draw = time.Now()
+	if len(latency.pending) == latencyWindow {
+		latency.pending = latency.pending[1:]
+	}
+	latency.pending = append(latency.pending, ev.trace)
+}
+
+// Wird beim Uebergeben eines Bildes an das Display aufgerufen und liefert
+// die Messungen aller Events, zu welchen seit dem letzten Bild gezeichnet
+// wurde. Sie gehoeren ab sofort zu diesem Bild (siehe displayed).
+func (lt *latencyTracker) take() []*LatencyTrace {
+	if !lt.enabled.Load() {
+		return nil
+	}
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	traces := lt.pending
+	lt.pending = nil
+	return traces
+}
+
+// Wie take, fuer Bilder, welche synchron gesendet werden. Mit der
+// gelieferten Funktion werden die Messungen nach dem Senden abgeschlossen.
+func (lt *latencyTracker) submit() (sent func()) {
+	traces := lt.take()
+	return func() {
+		lt.displayed(traces, time.Now())
+	}
+}
+
+// Schliesst die Messungen traces ab, deren Bild zum Zeitpunkt t zum TFT
+// gesendet wurde.
+func (lt *latencyTracker) displayed(traces []*LatencyTrace, t time.Time) {
+	if len(traces) == 0 || !lt.enabled.Load() {
+		return
+	}
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	for _, trace := range traces {
+		trace.Display = t
+		if len(lt.done) < latencyWindow {
+			lt.done = append(lt.done, *trace)
+		} else {
+			lt.done[lt.next] = *trace
+		}
+		lt.next = (lt.next + 1) % latencyWindow
+	}
+}
+
+func (lt *latencyTracker) reset() {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	lt.pending, lt.done, lt.next = nil, nil, 0
+}
+
+// Liefert die letzten vollstaendigen Messungen, die aelteste zuerst.
+func RecentLatencies() []LatencyTrace {
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	if len(latency.done) < latencyWindow {
+		return slices.Clone(latency.done)
+	}
+	return append(slices.Clone(latency.done[latency.next:]),
+		latency.done[:latency.next]...)
+}
+
+// Berechnet die Statistik des Abschnittes stage ueber die letzten
+// vollstaendigen Messungen (siehe RecentLatencies).
+func LatencyStat(stage LatencyStage) LatencyStats {
+	traces := RecentLatencies()
+	if len(traces) == 0 {
+		return LatencyStats{}
+	}
+	ds := make([]time.Duration, len(traces))
+	var sum time.Duration
+	for i, trace := range traces {
+		ds[i] = trace.Duration(stage)
+		sum += ds[i]
+	}
+	slices.Sort(ds)
+	n := len(ds)
+	return LatencyStats{
+		Num: n,
+		Min: ds[0],
+		Avg: sum / time.Duration(n),
+		P95: ds[(n*95+99)/100-1],
+		Max: ds[n-1],
+	}
+}
+
+// Gibt die Statistik aller Abschnitte aus (wird von PrintStat aufgerufen).
+func printLatency() {
+	if LatencyStat(LatencyTotal).Num == 0 {
+		return
+	}
+	fmt.Printf("touch latency:\n")
+	for stage := LatencyRead; stage < numLatencyStages; stage++ {
+		s := LatencyStat(stage)
+		fmt.Printf("  %-20v: min %v, avg %v, p95 %v, max %v (%d events)\n",
+			stage, s.Min, s.Avg, s.P95, s.Max, s.Num)
+	}
+}
@@ -0,0 +1,173 @@
+package adatft
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Ein fifoSim, welcher zusaetzlich den Zeitpunkt der Flanke liefert.
+type edgeSim struct {
+	*fifoSim
+	edge time.Time
+}
+
+func (e *edgeSim) EdgeTime() time.Time {
+	return e.edge
+}
+
+func enableLatencyTest(t *testing.T) {
+	EnableLatency(true)
+	latency.reset()
+	t.Cleanup(func() {
+		EnableLatency(false)
+		latency.reset()
+	})
+}
+
+func TestLatencyTrace(t *testing.T) {
+	enableLatencyTest(t)
+	sim := &edgeSim{fifoSim: &fifoSim{regRecorder: newRegRecorder()}}
+	sim.regs[hw.INT_EN] = hw.INT_TOUCH_DET | hw.INT_FIFO_TH
+	tch := newTestTouch(eventQueueSize)
+	tch.tspi = sim
+	ev.Type = PenRelease
+
+	sim.edge = time.Now()
+	sim.fifo = []TouchRawPos{{RawX: 1000, RawY: 2000, RawZ: 50}}
+	sim.regs[hw.TSC_CTRL] = hw.TSC_CTRL_STATUS
+	eventDispatcher(tch)
+	e := <-tch.EventQ
+	if e.trace == nil {
+		t.Fatalf("event without latency trace")
+	}
+	TraceDraw(e)
+	draw := e.trace.Draw
+	TraceDraw(e)
+	if e.trace.Draw != draw {
+		t.Errorf("second TraceDraw changed the draw time")
+	}
+	if len(RecentLatencies()) != 0 {
+		t.Errorf("trace completed before the image was sent")
+	}
+	latency.submit()()
+
+	traces := RecentLatencies()
+	if len(traces) != 1 {
+		t.Fatalf("got %d traces, want 1", len(traces))
+	}
+	lt := traces[0]
+	if !lt.Edge.Equal(sim.edge) || !lt.Enqueue.Equal(e.Time) {
+		t.Errorf("got edge %v, enqueue %v", lt.Edge, lt.Enqueue)
+	}
+	// Der Dispatcher wartet sampleTime, bevor er die FIFO liest.
+	if d := lt.Duration(LatencyRead); d < sampleTime {
+		t.Errorf("edge to read %v, want at least %v", d, sampleTime)
+	}
+	for stage := LatencyRead; stage < numLatencyStages; stage++ {
+		if lt.Duration(stage) < 0 {
+			t.Errorf("%v: negative duration %v", stage, lt.Duration(stage))
+		}
+	}
+	if lt.Duration(LatencyTotal) != lt.Display.Sub(lt.Edge) {
+		t.Errorf("total %v", lt.Duration(LatencyTotal))
+	}
+
+	// Ohne Latenzmessung erhalten die Events keine Zeitpunkte.
+	EnableLatency(false)
+	sim.regs[hw.INT_STA] = hw.INT_TOUCH_DET
+	sim.regs[hw.TSC_CTRL] = 0
+	eventDispatcher(tch)
+	if e := <-tch.EventQ; e.Type != PenRelease || e.trace != nil {
+		t.Errorf("got %v with trace %v", e.Type, e.trace)
+	}
+}
+
+func TestLatencyStat(t *testing.T) {
+	enableLatencyTest(t)
+	if s := LatencyStat(LatencyTotal); s.Num != 0 {
+		t.Errorf("got %+v without measurements", s)
+	}
+	base := time.Now()
+	for i := 1; i <= 100; i++ {
+		trace := &LatencyTrace{Edge: base, Read: base, Enqueue: base}
+		TraceDraw(PenEvent{trace: trace})
+		latency.displayed(latency.take(),
+			base.Add(time.Duration(i)*time.Millisecond))
+	}
+	// Events ohne Messung werden ignoriert.
+	TraceDraw(PenEvent{})
+	latency.submit()()
+
+	s := LatencyStat(LatencyTotal)
+	want := LatencyStats{Num: 100, Min: time.Millisecond,
+		Avg: 50500 * time.Microsecond, P95: 95 * time.Millisecond,
+		Max: 100 * time.Millisecond}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+
+	for range latencyWindow {
+		TraceDraw(PenEvent{trace: &LatencyTrace{}})
+		latency.displayed(latency.take(), time.Time{})
+	}
+	if n := len(RecentLatencies()); n != latencyWindow {
+		t.Errorf("got %d traces, want %d", n, latencyWindow)
+	}
+	ResetStat()
+	if n := len(RecentLatencies()); n != 0 {
+		t.Errorf("ResetStat left %d traces", n)
+	}
+}
+
+// Die Messung gehoert zum Bild, welches nach TraceDraw uebergeben wird,
+// und nicht zu einem frueheren, noch nicht gesendeten Bild. Ein Bild ohne
+// Differenz zum Bildschirminhalt schliesst sie ebenfalls ab.
+func TestLatencyDraw(t *testing.T) {
+	enableLatencyTest(t)
+	dsp := newNullDisplay()
+	dsp.imgChan = []chan *ILIImage{make(chan *ILIImage, numBuffers+1),
+		make(chan *ILIImage, numBuffers+1)}
+	for range numBuffers {
+		dsp.imgChan[toConv] <- NewILIImage(dsp.rect)
+	}
+	dsp.quitQ = make(chan bool)
+	go dsp.displayer()
+	defer func() {
+		close(dsp.imgChan[toDisp])
+		<-dsp.quitQ
+	}()
+	img := image.NewRGBA(dsp.rect)
+	draw.Draw(img, img.Rect, image.NewUniform(color.White), image.Point{},
+		draw.Src)
+
+	// Das Bild wird uebergeben, aber erst nach TraceDraw gesendet.
+	dsp.mutex.Lock()
+	dsp.Draw(img)
+	TraceDraw(PenEvent{trace: &LatencyTrace{}})
+	dsp.mutex.Unlock()
+	for len(dsp.imgChan[toConv]) < numBuffers {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(RecentLatencies()); n != 0 {
+		t.Fatalf("trace completed by an earlier image")
+	}
+
+	dsp.Draw(img)
+	for len(dsp.imgChan[toConv]) < numBuffers {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(RecentLatencies()); n != 1 {
+		t.Fatalf("got %d traces after an unchanged image, want 1", n)
+	}
+
+	TraceDraw(PenEvent{trace: &LatencyTrace{}})
+	dsp.DrawSync(img)
+	if n := len(RecentLatencies()); n != 2 {
+		t.Errorf("got %d traces after DrawSync, want 2", n)
+	}
+}
@@ -0,0 +1,123 @@
+package adatft
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+)
+
+// Minimaler Unterschied der Rohwerte von Z zwischen leichtem und festem
+// Druck bei der Kalibrierung des Drucks.
+const minPressureDelta = 4
+
+var (
+	// Farben fuer die Aufforderung zum leichten bzw. festen Druck.
+	calibLightColor = color.RGBA{0x40, 0x80, 0xff, 0xff}
+	calibFirmColor  = color.RGBA{0xff, 0xa0, 0x20, 0xff}
+
+	ErrCalibPressure = errors.New("calibration: pressure range too small")
+)
+
+// Fuehrt die Kalibrierung des Drucks durch. In der Mitte des Displays wird
+// zuerst ein blaues Fadenkreuz angezeigt, welches leicht gedrueckt werden
+// muss, danach ein oranges, welches fest gedrueckt werden muss. Ab sofort
+// liefert tch in TouchPos.Z einen normierten Druck: 0 entspricht dem
+// leichten, 1 dem festen Druck (Werte ausserhalb werden begrenzt). Die
+// Kalibrierung wird zusammen mit derjenigen der Position gespeichert (siehe
+// Calibrate, opts.FileName) und bleibt beim Aendern des Z-Formates (siehe
+// TouchConfig.FractionZ) gueltig. Kann das File nicht geschrieben werden,
+// wird die neue Kalibrierung zusammen mit dem Fehler retourniert, von tch
+// aber nicht uebernommen. Wie bei Calibrate sind der minimale Druck, die
+// Filterkette und die Abonnenten waehrend der Kalibrierung ausgeschaltet.
+func CalibratePressure(dsp *Display, tch *Touch,
+	opts CalibOptions) (*DistortedPlane, error) {
+	pos := refPointPos(RefCenter, dsp.Bounds(), opts.Margin)
+	fb := dsp.Framebuffer()
+
+	// Ein leichter Druck darf waehrend der Kalibrierung nicht verworfen
+	// werden.
+	defer tch.suspendFilters()()
+	defer tch.router.suspend()()
+
+	log.Printf("Press the blue target lightly")
+	light, err := pressurePoint(dsp, fb, tch, pos, calibLightColor, opts)
+	if err != nil {
+		return nil, fmt.Errorf("light press: %w", err)
+	}
+	log.Printf("Press the orange target firmly")
+	firm, err := pressurePoint(dsp, fb, tch, pos, calibFirmColor, opts)
+	if err != nil {
+		return nil, fmt.Errorf("firm press: %w", err)
+	}
+	clearScreen(dsp, fb)
+	if d := int(light) - int(firm); d > -minPressureDelta &&
+		d < minPressureDelta {
+		return nil, fmt.Errorf("%w: light %d, firm %d", ErrCalibPressure,
+			light, firm)
+	}
+
+	plane := tch.Plane()
+	tc := tch.Config()
+	plane.SetPressureRange(light, firm, tc.FractionZ)
+	plane.setZFormat(tc.FractionZ, tc.rawZMax())
+
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = CalibFileName(tch.device)
+	}
+	if err = plane.WriteConfigFile(fileName); err != nil {
+		return &plane, err
+	}
+	tch.setPlane(plane)
+	return &plane, nil
+}
+
+// Zeigt an der Position pos ein Fadenkreuz der Farbe col an und liefert
+// den Mittelwert der Rohwerte von Z beim Druecken.
+func pressurePoint(dsp *Display, fb *Framebuffer, tch *Touch, pos TouchPos,
+	col color.Color, opts CalibOptions) (uint8, error) {
+	clearScreen(dsp, fb)
+	drawCrosshair(fb, pos, col)
+	dsp.Flush()
+	rawPos, _, err := collectSamples(tch, opts)
+	return rawPos.RawZ, err
+}
+
+// Liefert true, falls der Druck kalibriert ist (siehe CalibratePressure).
+func (d *DistortedPlane) PressureCalibrated() bool {
+	return d.RawZLight != d.RawZFirm
+}
+
+// Setzt die Rohwerte von Z fuer einen leichten (light) und einen festen
+// Druck (firm), gemessen mit dem Z-Format fractionZ (siehe
+// TouchConfig.FractionZ). Die Abbildung wird erst mit dem naechsten Aufruf
+// von setZFormat (z.B. durch Touch.SetConfig) aktiv.
+func (d *DistortedPlane) SetPressureRange(light, firm uint8, fractionZ int) {
+	d.RawZLight, d.RawZFirm = light, firm
+	d.FractionZ = fractionZ
+}
+
+// Passt die Abbildung der Z-Werte an das Z-Format fractionZ an, bei
+// welchem die Rohwerte maximal rawZMax betragen. Ohne Kalibrierung des
+// Drucks wird der gesamte Bereich auf [1,0] abgebildet, ansonsten werden die
+// kalibrierten Rohwerte auf das neue Format umgerechnet.
+func (d *DistortedPlane) setZFormat(fractionZ int, rawZMax uint8) {
+	if !d.PressureCalibrated() {
+		d.SetZRange(0, rawZMax, 1.0, 0.0)
+		return
+	}
+	scale := func(raw uint8) uint8 {
+		v := float64(raw) * math.Pow(2.0, float64(fractionZ-d.FractionZ))
+		return uint8(max(0.0, min(float64(rawZMax), math.Round(v))))
+	}
+	light, firm := scale(d.RawZLight), scale(d.RawZFirm)
+	if light == firm {
+		log.Printf("Pressure calibration not usable with fraction z %d; "+
+			"recalibration recommended", fractionZ)
+		d.SetZRange(0, rawZMax, 1.0, 0.0)
+		return
+	}
+	d.SetZRange(light, firm, 0.0, 1.0)
+}
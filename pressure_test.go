@@ -0,0 +1,114 @@
+package adatft
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestPressureZFormat(t *testing.T) {
+	var d DistortedPlane
+	d.setDefault(Rotate000)
+
+	// Ohne Kalibrierung wird der gesamte Bereich auf [1,0] abgebildet.
+	d.setZFormat(5, DefaultTouchConfig.rawZMax())
+	if d.RawZmin != 0 || d.RawZmax != 127 || d.Zmin != 1.0 || d.Zmax != 0.0 {
+		t.Errorf("z range %d-%d -> %g-%g", d.RawZmin, d.RawZmax, d.Zmin,
+			d.Zmax)
+	}
+
+	d.SetPressureRange(80, 20, 5)
+	testList := []struct {
+		fractionZ   int
+		light, firm uint8
+	}{
+		{5, 80, 20},
+		{6, 160, 40},
+		{4, 40, 10},
+		{7, 255, 80},
+	}
+	for _, test := range testList {
+		tc := DefaultTouchConfig
+		tc.FractionZ = test.fractionZ
+		d.setZFormat(tc.FractionZ, tc.rawZMax())
+		if d.RawZmin != test.light || d.RawZmax != test.firm ||
+			d.Zmin != 0.0 || d.Zmax != 1.0 {
+			t.Errorf("fraction %d: z range %d-%d -> %g-%g", test.fractionZ,
+				d.RawZmin, d.RawZmax, d.Zmin, d.Zmax)
+		}
+	}
+}
+
+func TestPressureTransform(t *testing.T) {
+	var d DistortedPlane
+	d.setDefault(Rotate000)
+	d.SetPressureRange(80, 20, 5)
+	d.setZFormat(5, DefaultTouchConfig.rawZMax())
+
+	testList := []struct {
+		rawZ uint8
+		z    float64
+	}{
+		{80, 0.0},
+		{50, 0.5},
+		{20, 1.0},
+		{120, 0.0},
+		{5, 1.0},
+	}
+	for _, test := range testList {
+		pos, err := d.Transform(TouchRawPos{2000, 2000, test.rawZ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(pos.Z-test.z) > 1e-9 {
+			t.Errorf("raw z %d: got %g, want %g", test.rawZ, pos.Z, test.z)
+		}
+	}
+}
+
+func TestPressureWriteRead(t *testing.T) {
+	var d, e DistortedPlane
+	d.setDefault(Rotate000)
+	d.SetPressureRange(90, 30, 6)
+
+	fileName := filepath.Join(t.TempDir(), calibDataFile)
+	if err := d.WriteConfigFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadConfigFile(fileName, Rotate000); err != nil {
+		t.Fatal(err)
+	}
+	if !e.PressureCalibrated() || e.RawZLight != 90 || e.RawZFirm != 30 ||
+		e.FractionZ != 6 {
+		t.Errorf("got light %d, firm %d, fraction %d", e.RawZLight,
+			e.RawZFirm, e.FractionZ)
+	}
+}
+
+func TestPressureThreshold(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	tch.plane.SetPressureRange(80, 20, 5)
+	tc := DefaultTouchConfig
+	tc.MinPressure = 0.2
+	if err := tch.SetConfig(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		rawZ uint8
+		want bool
+	}{{75, false}, {60, true}, {20, true}} {
+		ev := PenEvent{Type: PenPress}
+		ev.TouchPos, _ = tch.plane.Transform(TouchRawPos{2000, 2000,
+			test.rawZ})
+		if got := tch.filter(&ev); got != test.want {
+			t.Errorf("raw z %d (z %g): got %v, want %v", test.rawZ, ev.Z,
+				got, test.want)
+		}
+	}
+
+	tc.MinPressure = 1.0
+	if err := tch.SetConfig(tc); err == nil {
+		t.Errorf("min. pressure 1.0 accepted")
+	}
+}
@@ -0,0 +1,132 @@
+package adatft
+
+import (
+	"log"
+	"slices"
+)
+
+// Zaehler fuer die Events, welche wegen einer vollen Queue nicht einzeln
+// zugestellt wurden. Ist die Queue voll, werden aufeinanderfolgende
+// PenDrag-Events durch das letzte von ihnen ersetzt (Coalesced), so dass
+// die Applikation nur die aktuelle Position erhaelt. Reicht dies nicht,
+// werden die aeltesten PenDrag-Events verworfen (Dropped). PenPress und
+// PenRelease werden nie verworfen, da die Applikation sonst den Zustand
+// des Stiftes verliert. Enthaelt die Queue nur noch solche Events, wird
+// die aelteste Folge von PenRelease und PenPress entfernt, d.h. zwei
+// Beruehrungen werden zu einer zusammengefasst (Merged). Das neueste Event
+// bleibt dabei immer erhalten. Ist auch dies nicht moeglich, wartet der
+// Touchscreen, bis die Applikation wieder Events liest.
+type QueueStats struct {
+	Coalesced, Dropped, Merged uint64
+}
+
+func (qs *QueueStats) add(o QueueStats) {
+	qs.Coalesced += o.Coalesced
+	qs.Dropped += o.Dropped
+	qs.Merged += o.Merged
+}
+
+// Liefert die Zaehler der Queue seit dem Oeffnen des Touchscreens. Darin
+// enthalten sind auch die Events fuer die Abonnenten (siehe Subscribe).
+func (tch *Touch) QueueStats() QueueStats {
+	tch.queueMutex.Lock()
+	stats := tch.queueStats
+	tch.queueMutex.Unlock()
+	tch.router.mutex.Lock()
+	stats.add(tch.router.stats)
+	tch.router.mutex.Unlock()
+	return stats
+}
+
+// Stellt das Event ev in die Queue, ohne zu blockieren. Ist die Queue voll,
+// wird ihr Inhalt gemaess den Regeln bei QueueStats verdichtet. Dies
+// funktioniert, da alle Schreiber (der Dispatcher, aber auch Replay und
+// Simulate) ueber pushEvent gehen und queueMutex sperren: waehrend die
+// Queue geleert und neu gefuellt wird, kann die Applikation weiterhin in
+// der richtigen Reihenfolge lesen. Nach Close werden keine Events mehr
+// angenommen.
+func (tch *Touch) pushEvent(ev PenEvent) {
+	tch.queueMutex.Lock()
+	defer tch.queueMutex.Unlock()
+
+	select {
+	case <-tch.done:
+		return
+	case tch.EventQ <- ev:
+		return
+	default:
+	}
+
+	var evs []routedEvent
+drain:
+	for {
+		select {
+		case e := <-tch.EventQ:
+			evs = append(evs, routedEvent{ev: e})
+		default:
+			break drain
+		}
+	}
+	evs = compactEvents(append(evs, routedEvent{ev: ev}), cap(tch.EventQ),
+		&tch.queueStats)
+	for _, e := range evs {
+		select {
+		case tch.EventQ <- e.ev:
+		case <-tch.done:
+			return
+		}
+	}
+}
+
+// Verdichtet die Events evs (das aelteste zuerst) gemaess den Regeln bei
+// QueueStats auf hoechstens n Events und zaehlt die Aenderungen in stats.
+// Events fuer verschiedene Abonnenten werden nie zusammengefasst. Kann evs
+// nicht genuegend verkleinert werden, sind im Resultat mehr als n Events
+// enthalten.
+func compactEvents(evs []routedEvent, n int,
+	stats *QueueStats) []routedEvent {
+	evs = coalesceEvents(evs, stats)
+	for len(evs) > n {
+		var ok bool
+		if evs, ok = dropEvent(evs, stats); !ok {
+			log.Printf("Event queue full: waiting for the application\n")
+			break
+		}
+	}
+	return evs
+}
+
+// Ersetzt jede Folge von PenDrag-Events durch das letzte davon.
+func coalesceEvents(evs []routedEvent, stats *QueueStats) []routedEvent {
+	res := evs[:0]
+	for _, e := range evs {
+		if n := len(res); n > 0 && e.ev.Type == PenDrag &&
+			res[n-1].ev.Type == PenDrag && res[n-1].sub == e.sub {
+			res[n-1] = e
+			stats.Coalesced++
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// Verwirft das aelteste PenDrag-Event oder, falls keines vorhanden ist,
+// die aelteste Folge von PenRelease und PenPress (ohne das neueste Event).
+// Liefert false, falls keines von beiden moeglich ist.
+func dropEvent(evs []routedEvent, stats *QueueStats) ([]routedEvent, bool) {
+	if i := slices.IndexFunc(evs, func(e routedEvent) bool {
+		return e.ev.Type == PenDrag
+	}); i >= 0 {
+		stats.Dropped++
+		return slices.Delete(evs, i, i+1), true
+	}
+	for i := 0; i < len(evs)-2; i++ {
+		if evs[i].ev.Type == PenRelease && evs[i+1].ev.Type == PenPress &&
+			evs[i].sub == evs[i+1].sub {
+			stats.Merged++
+			return slices.Delete(evs, i, i+2), true
+		}
+	}
+	return evs, false
+}
@@ -0,0 +1,113 @@
+package adatft
+
+import (
+	"testing"
+)
+
+func queueContent(tch *Touch) []PenEventType {
+	var res []PenEventType
+	for len(tch.EventQ) > 0 {
+		res = append(res, (<-tch.EventQ).Type)
+	}
+	return res
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	tch := newTestTouch(4)
+	tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+	for i := 1; i <= 10; i++ {
+		tch.enqueueEvent(penEventAt(PenDrag, float64(i), 0, 0, 0))
+	}
+	tch.enqueueEvent(penEventAt(PenRelease, 10, 0, 0, 0))
+
+	if len(tch.EventQ) != 3 {
+		t.Fatalf("got %d events, want 3", len(tch.EventQ))
+	}
+	if ev := <-tch.EventQ; ev.Type != PenPress {
+		t.Errorf("got %v, want PenPress", ev.Type)
+	}
+	if ev := <-tch.EventQ; ev.Type != PenDrag || ev.X != 10 {
+		t.Errorf("got %v at %v, want latest PenDrag", ev.Type, ev.TouchPos)
+	}
+	if ev := <-tch.EventQ; ev.Type != PenRelease {
+		t.Errorf("got %v, want PenRelease", ev.Type)
+	}
+	stats := tch.QueueStats()
+	if stats.Coalesced != 9 || stats.Dropped != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestQueueDrop(t *testing.T) {
+	tch := newTestTouch(4)
+	// Zwei Beruehrungen mit je einem Drag fuellen die Queue; das Release
+	// der zweiten muss trotzdem ankommen.
+	for _, typ := range []PenEventType{PenPress, PenDrag, PenRelease,
+		PenPress, PenDrag, PenRelease} {
+		tch.enqueueEvent(penEventAt(typ, 0, 0, 0, 0))
+	}
+	want := []PenEventType{PenPress, PenRelease, PenPress, PenRelease}
+	got := queueContent(tch)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if stats := tch.QueueStats(); stats.Dropped != 2 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+// Enthaelt die Queue nur noch Press und Release, werden Beruehrungen
+// zusammengefasst: Press und Release wechseln sich weiterhin ab, die erste
+// und die neueste Zustandsaenderung bleiben erhalten.
+func TestQueuePressRelease(t *testing.T) {
+	tch := newTestTouch(4)
+	for i := 0; i < 5; i++ {
+		tch.enqueueEvent(penEventAt(PenPress, float64(i), 0, 0, 0))
+		tch.enqueueEvent(penEventAt(PenRelease, float64(i), 0, 0, 0))
+	}
+	var got []PenEvent
+	for len(tch.EventQ) > 0 {
+		got = append(got, <-tch.EventQ)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d events, want 4", len(got))
+	}
+	for i, ev := range got {
+		if want := []PenEventType{PenPress, PenRelease}[i%2]; ev.Type != want {
+			t.Fatalf("event %d: got %v, want %v", i, ev.Type, want)
+		}
+	}
+	if got[0].X != 0 || got[3].X != 4 {
+		t.Errorf("first press at %v, last release at %v", got[0].X, got[3].X)
+	}
+	if stats := tch.QueueStats(); stats.Merged != 3 || stats.Dropped != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+// Kann die Queue nicht verdichtet werden, wartet enqueueEvent auf die
+// Applikation, anstatt ein Event zu verwerfen.
+func TestQueueWait(t *testing.T) {
+	tch := newTestTouch(2)
+	tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 0, 0, 0, 0))
+	done := make(chan bool)
+	go func() {
+		tch.enqueueEvent(penEventAt(PenPress, 1, 0, 0, 0))
+		close(done)
+	}()
+	if ev := <-tch.EventQ; ev.Type != PenPress || ev.X != 0 {
+		t.Errorf("got %v at %v, want first PenPress", ev.Type, ev.TouchPos)
+	}
+	<-done
+	want := []PenEventType{PenRelease, PenPress}
+	if got := queueContent(tch); len(got) != 2 || got[0] != want[0] ||
+		got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
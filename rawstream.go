@@ -0,0 +1,118 @@
+package adatft
+
+import (
+	"sync"
+	"time"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Ein RawSample ist ein einzelner Messwert aus der FIFO des Controllers.
+// Da der Controller keine Zeitstempel liefert, wird Time aus dem Zeitpunkt
+// des Auslesens und dem Messintervall (siehe TouchConfig.SampleInterval)
+// geschaetzt. Ist Overflow gesetzt, ist die FIFO vor diesem Messwert
+// uebergelaufen, d.h. es sind Messwerte verloren gegangen.
+type RawSample struct {
+	TouchRawPos
+	Time     time.Time
+	Overflow bool
+}
+
+// Zaehler des Rohdatenstroms (siehe OpenRawStream): Anzahl gelieferter
+// Messwerte, Anzahl verworfener Messwerte (Channel voll) und Anzahl
+// Ueberlaeufe der FIFO des Controllers.
+type RawStreamStats struct {
+	Samples, Dropped, Overflows uint64
+}
+
+// Verwaltet den Rohdatenstrom eines Touchscreens.
+type rawStream struct {
+	mutex    sync.Mutex
+	ch       chan RawSample
+	overflow bool
+	stats    RawStreamStats
+}
+
+// Schaetzt die Zeit zwischen zwei Messwerten in der FIFO: fuer jede der drei
+// Koordinaten (X, Y und Z) wird die Einschwingzeit und die Wandlung aller
+// gemittelten Messungen benoetigt. Mit Window-Tracking werden zudem nicht
+// alle Messwerte in die FIFO gestellt; der Wert ist daher als untere Grenze
+// zu verstehen.
+func (tc TouchConfig) SampleInterval() time.Duration {
+	cfg, err := tc.hwConfig()
+	if err != nil {
+		cfg = hw.DefaultConfig
+	}
+	return tc.sampleInterval(cfg)
+}
+
+// Wie SampleInterval, die Taktfrequenz des ADC wird jedoch aus ADC_CTRL2
+// der Registerwerte cfg bestimmt.
+func (tc TouchConfig) sampleInterval(cfg hw.Config) time.Duration {
+	conv := time.Duration(tc.Samples*tc.SampleClocks) * time.Second /
+		time.Duration(cfg.AdcClock())
+	return 3 * (tc.Settle + conv)
+}
+
+// Oeffnet den Rohdatenstrom: ab sofort werden alle Messwerte aus der FIFO
+// des Controllers (ungefiltert und unkalibriert) in den gelieferten Channel
+// der Groesse size gestellt. Damit gehen - anders als bei den Events in
+// EventQ - keine Messwerte verloren, was z.B. fuer das Erfassen von
+// Unterschriften notwendig ist. Ist der Channel voll, werden neue
+// Messwerte verworfen (siehe RawStreamStats). Ein bereits geoeffneter
+// Strom wird vorher geschlossen.
+func (tch *Touch) OpenRawStream(size int) <-chan RawSample {
+	tch.CloseRawStream()
+	ch := make(chan RawSample, size)
+	tch.raw.mutex.Lock()
+	tch.raw.ch = ch
+	tch.raw.mutex.Unlock()
+	return ch
+}
+
+// Schliesst den Rohdatenstrom (und dessen Channel).
+func (tch *Touch) CloseRawStream() {
+	tch.raw.mutex.Lock()
+	defer tch.raw.mutex.Unlock()
+	if tch.raw.ch != nil {
+		close(tch.raw.ch)
+		tch.raw.ch = nil
+	}
+}
+
+// Liefert die Zaehler des Rohdatenstroms.
+func (tch *Touch) RawStreamStats() RawStreamStats {
+	tch.raw.mutex.Lock()
+	defer tch.raw.mutex.Unlock()
+	return tch.raw.stats
+}
+
+// Stellt die soeben aus der FIFO gelesenen Messwerte samples in den
+// Rohdatenstrom. Der letzte Messwert erhaelt die aktuelle Zeit, die
+// vorangehenden entsprechend dem Messintervall fruehere Zeiten. Mit
+// overflow wird ein Ueberlauf der FIFO gemeldet; ohne Messwerte wird er
+// mit dem naechsten Messwert weitergegeben.
+func (tch *Touch) streamSamples(samples []TouchRawPos, overflow bool) {
+	tch.raw.mutex.Lock()
+	defer tch.raw.mutex.Unlock()
+	if overflow {
+		tch.raw.stats.Overflows++
+		tch.raw.overflow = true
+	}
+	if tch.raw.ch == nil || len(samples) == 0 {
+		return
+	}
+	now := time.Now()
+	interval := tch.Config().SampleInterval()
+	for i, s := range samples {
+		rs := RawSample{TouchRawPos: s, Overflow: tch.raw.overflow,
+			Time: now.Add(-time.Duration(len(samples)-1-i) * interval)}
+		select {
+		case tch.raw.ch <- rs:
+			tch.raw.stats.Samples++
+			tch.raw.overflow = false
+		default:
+			tch.raw.stats.Dropped++
+		}
+	}
+}
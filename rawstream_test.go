@@ -0,0 +1,131 @@
+package adatft
+
+import (
+	"testing"
+	"time"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Simuliert die FIFO und die Interrupt-Register des STMPE610, damit der
+// eventDispatcher ohne Hardware getestet werden kann.
+type fifoSim struct {
+	*regRecorder
+	fifo []TouchRawPos
+}
+
+func (f *fifoSim) ReadReg8(addr uint8) uint8 {
+	switch addr {
+	case hw.FIFO_SIZE:
+		return uint8(len(f.fifo))
+	case hw.INT_STA:
+		sta := f.regs[hw.INT_STA]
+		if len(f.fifo) > 0 {
+			sta |= hw.INT_FIFO_TH
+		}
+		return sta
+	}
+	return f.regs[addr]
+}
+
+func (f *fifoSim) WriteReg8(addr uint8, value uint8) {
+	switch addr {
+	case hw.INT_STA:
+		f.regs[hw.INT_STA] &^= value
+	case hw.FIFO_STA:
+		if value&hw.FIFO_STA_RESET != 0 {
+			f.fifo = nil
+		}
+	default:
+		f.regRecorder.WriteReg8(addr, value)
+	}
+}
+
+func (f *fifoSim) ReadData() (x, y uint16, z uint8) {
+	s := f.fifo[0]
+	f.fifo = f.fifo[1:]
+	return s.RawX, s.RawY, s.RawZ
+}
+
+func TestRawStream(t *testing.T) {
+	sim := &fifoSim{regRecorder: newRegRecorder()}
+	sim.regs[hw.INT_EN] = hw.INT_TOUCH_DET | hw.INT_FIFO_TH |
+		hw.INT_FIFO_OFLOW
+	tch := newTestTouch(eventQueueSize)
+	tch.tspi = sim
+	tch.config = DefaultTouchConfig
+	tch.plane.setDefault(Rotate000)
+	ev.Type = PenRelease
+
+	raw := tch.OpenRawStream(10)
+	for i := range 5 {
+		sim.fifo = append(sim.fifo, TouchRawPos{RawX: uint16(1000 + i),
+			RawY: 2000, RawZ: 50})
+	}
+	sim.regs[hw.INT_STA] = hw.INT_FIFO_OFLOW
+	sim.regs[hw.TSC_CTRL] = hw.TSC_CTRL_STATUS
+	eventDispatcher(tch)
+
+	if len(raw) != 5 {
+		t.Fatalf("got %d raw samples, want 5", len(raw))
+	}
+	interval := DefaultTouchConfig.SampleInterval()
+	var last time.Time
+	for i := range 5 {
+		s := <-raw
+		if s.RawX != uint16(1000+i) || s.Overflow != (i == 0) {
+			t.Errorf("sample %d: got %v (overflow %v)", i, s.TouchRawPos,
+				s.Overflow)
+		}
+		if i > 0 && s.Time.Sub(last) != interval {
+			t.Errorf("sample %d: %v after previous, want %v", i,
+				s.Time.Sub(last), interval)
+		}
+		last = s.Time
+	}
+	stats := tch.RawStreamStats()
+	if stats.Samples != 5 || stats.Overflows != 1 || stats.Dropped != 0 {
+		t.Errorf("got %+v", stats)
+	}
+
+	// In die Queue wird nur ein Event mit dem letzten Messwert gestellt.
+	if len(tch.EventQ) != 1 {
+		t.Fatalf("got %d events, want 1", len(tch.EventQ))
+	}
+	if e := <-tch.EventQ; e.Type != PenPress || e.RawX != 1004 ||
+		e.FifoSize != 5 {
+		t.Errorf("got %v at %v with FIFO size %d", e.Type, e.TouchRawPos,
+			e.FifoSize)
+	}
+
+	sim.regs[hw.INT_STA] = hw.INT_TOUCH_DET
+	sim.regs[hw.TSC_CTRL] = 0
+	eventDispatcher(tch)
+	if e := <-tch.EventQ; e.Type != PenRelease {
+		t.Errorf("got %v, want PenRelease", e.Type)
+	}
+	tch.CloseRawStream()
+	if _, ok := <-raw; ok {
+		t.Errorf("raw stream not closed")
+	}
+}
+
+func TestSampleInterval(t *testing.T) {
+	tc := DefaultTouchConfig
+	tc.Settle = time.Millisecond
+	tc.Samples = 1
+	tc.SampleClocks = 80
+	// 3 * (1ms + 80/6.5MHz)
+	want := 3 * (time.Millisecond + 80*time.Second/6_500_000)
+	if got := tc.SampleInterval(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Bei einem langsameren Takt dauert die Wandlung entsprechend laenger.
+	cfg := hw.DefaultConfig
+	cfg.AdcCtrl2 = hw.ADC_CTRL2_1_625MHZ
+	want = 3 * (time.Millisecond + 80*time.Second/1_625_000)
+	if got := tc.sampleInterval(cfg); got != want {
+		t.Errorf("1.625 MHz: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,202 @@
+package adatft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Eine Aufzeichnung enthaelt pro Zeile ein Event im JSON-Format mit Typ,
+// Zeitstempel, Rohdaten und kalibrierter Position, z.B.
+//
+//	{"Type":"PenPress","RawX":2047,"RawY":1890,"RawZ":96,"X":119.5,...}
+//
+// Damit koennen Fehler im Zusammenhang mit dem Touchscreen auch ohne das
+// Geraet (z.B. mit dem Dummy-Touchscreen auf einem PC) nachvollzogen werden.
+
+var (
+	ErrRecording         = errors.New("touch: invalid recording")
+	ErrRecordingOverflow = errors.New("touch: recording too slow, events lost")
+)
+
+// Anzahl Events, welche auf das Schreiben in die Aufzeichnung warten
+// koennen.
+const recordQueueSize = 256
+
+// Die Events werden in einer eigenen Go-Routine (siehe run) kodiert und
+// geschrieben, damit der Dispatcher nicht durch langsame Writer blockiert
+// wird.
+type touchRecorder struct {
+	events  chan PenEvent
+	stopped chan struct{}
+	lost    int
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	closer  io.Closer
+	err     error
+}
+
+// Startet die Aufzeichnung aller Events, welche in die Queue gestellt (oder
+// einem Abonnenten zugestellt) werden, nach w. Eine laufende Aufzeichnung
+// wird vorher beendet. Kann w nicht mit den Events mithalten, gehen
+// Events verloren und StopRecording liefert ErrRecordingOverflow.
+func (tch *Touch) StartRecording(w io.Writer) error {
+	if err := tch.StopRecording(); err != nil {
+		return err
+	}
+	rec := &touchRecorder{
+		events:  make(chan PenEvent, recordQueueSize),
+		stopped: make(chan struct{}),
+		buf:     bufio.NewWriter(w),
+	}
+	rec.enc = json.NewEncoder(rec.buf)
+	if c, ok := w.(io.Closer); ok {
+		rec.closer = c
+	}
+	go rec.run()
+	tch.recordMutex.Lock()
+	tch.recorder = rec
+	tch.recordMutex.Unlock()
+	return nil
+}
+
+// Schreibt die Events bis zum Schliessen von rec.events und schliesst
+// anschliessend den Writer.
+func (rec *touchRecorder) run() {
+	defer close(rec.stopped)
+	for ev := range rec.events {
+		if rec.err == nil {
+			rec.err = rec.enc.Encode(ev)
+		}
+	}
+	if ferr := rec.buf.Flush(); rec.err == nil {
+		rec.err = ferr
+	}
+	if rec.closer != nil {
+		if cerr := rec.closer.Close(); rec.err == nil {
+			rec.err = cerr
+		}
+	}
+}
+
+// Wie StartRecording, die Events werden jedoch in das File fileName
+// geschrieben.
+func (tch *Touch) StartRecordingFile(fileName string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	return tch.StartRecording(fh)
+}
+
+// Beendet die laufende Aufzeichnung, nachdem alle bisherigen Events
+// geschrieben wurden. Wurde sie mit einem io.Closer (z.B. einem File)
+// gestartet, wird dieser geschlossen. Liefert den ersten Fehler, welcher
+// beim Schreiben aufgetreten ist.
+func (tch *Touch) StopRecording() error {
+	tch.recordMutex.Lock()
+	rec := tch.recorder
+	tch.recorder = nil
+	if rec != nil {
+		close(rec.events)
+	}
+	tch.recordMutex.Unlock()
+	if rec == nil {
+		return nil
+	}
+	<-rec.stopped
+	if rec.err == nil && rec.lost > 0 {
+		return fmt.Errorf("%w: %d", ErrRecordingOverflow, rec.lost)
+	}
+	return rec.err
+}
+
+// Uebergibt das Event ev der laufenden Aufzeichnung, ohne zu blockieren.
+func (tch *Touch) record(ev PenEvent) {
+	tch.recordMutex.Lock()
+	defer tch.recordMutex.Unlock()
+	rec := tch.recorder
+	if rec == nil {
+		return
+	}
+	select {
+	case rec.events <- ev:
+	default:
+		rec.lost++
+	}
+}
+
+// Liest eine Aufzeichnung aus r.
+func LoadRecording(r io.Reader) ([]PenEvent, error) {
+	var events []PenEvent
+
+	scanner := bufio.NewScanner(r)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev PenEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrRecording, lineNr,
+				err)
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Liest eine Aufzeichnung aus dem File fileName.
+func LoadRecordingFile(fileName string) ([]PenEvent, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return LoadRecording(fh)
+}
+
+// Stellt die aufgezeichneten Events wieder in die Queue (bzw. stellt sie
+// den Abonnenten zu), als kaemen sie vom Touchscreen. Die Abstaende
+// zwischen den Events werden durch speed geteilt: mit 1.0 erfolgt die
+// Wiedergabe in Originalzeit, mit 2.0 doppelt so schnell, mit 0.0 ohne
+// Pausen. Die Filter werden nicht nochmals angewendet, die Events behalten
+// ihre Zeitstempel und werden nicht erneut aufgezeichnet. Die Funktion kehrt
+// zurueck, wenn alle Events wiedergegeben oder der Touchscreen geschlossen
+// wurde.
+func (tch *Touch) Replay(events []PenEvent, speed float64) {
+	var last time.Time
+
+	for i, ev := range events {
+		if dt := ev.Time.Sub(last); i > 0 && speed > 0.0 && dt > 0 {
+			select {
+			case <-time.After(time.Duration(float64(dt) / speed)):
+			case <-tch.done:
+				return
+			}
+		}
+		select {
+		case <-tch.done:
+			return
+		default:
+		}
+		last = ev.Time
+		tch.dispatchEvent(ev)
+	}
+}
+
+// Wie Replay, die Events werden jedoch aus dem File fileName gelesen.
+func (tch *Touch) ReplayFile(fileName string, speed float64) error {
+	events, err := LoadRecordingFile(fileName)
+	if err != nil {
+		return err
+	}
+	tch.Replay(events, speed)
+	return nil
+}
@@ -0,0 +1,184 @@
+package adatft
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var recordTestEvents = []PenEvent{
+	{Type: PenPress, TouchRawPos: TouchRawPos{2047, 1890, 96},
+		TouchPos: TouchPos{119.5, 160.25, 0.75}},
+	{Type: PenDrag, TouchRawPos: TouchRawPos{2100, 1900, 90},
+		TouchPos: TouchPos{122.0, 161.0, 0.7}},
+	{Type: PenRelease, TouchRawPos: TouchRawPos{2100, 1900, 90},
+		TouchPos: TouchPos{122.0, 161.0, 0.7}},
+}
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+
+	tch := newTestTouch(eventQueueSize)
+	tch.StartRecording(&buf)
+	for _, ev := range recordTestEvents {
+		tch.enqueueEvent(ev)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := tch.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), `"Type":"PenPress"`) {
+		t.Errorf("event type not recorded by name:\n%s", buf.String())
+	}
+
+	events, err := LoadRecording(&buf)
+	if err != nil {
+		t.Fatalf("LoadRecording: %v", err)
+	}
+	if len(events) != len(recordTestEvents) {
+		t.Fatalf("got %d events, want %d", len(events),
+			len(recordTestEvents))
+	}
+	for i, ev := range events {
+		want := recordTestEvents[i]
+		if ev.Type != want.Type || ev.TouchRawPos != want.TouchRawPos ||
+			ev.TouchPos != want.TouchPos || ev.Time.IsZero() {
+			t.Errorf("event %d: got %+v, want %+v", i, ev, want)
+		}
+	}
+
+	for _, speed := range []float64{0.0, 1.0, 2.0} {
+		tch := newTestTouch(eventQueueSize)
+		start := time.Now()
+		tch.Replay(events, speed)
+		elapsed := time.Since(start)
+		if len(tch.EventQ) != len(events) {
+			t.Fatalf("speed %g: got %d events, want %d", speed,
+				len(tch.EventQ), len(events))
+		}
+		for i := range events {
+			if ev := <-tch.EventQ; ev.Type != events[i].Type ||
+				ev.TouchPos != events[i].TouchPos ||
+				!ev.Time.Equal(events[i].Time) {
+				t.Errorf("speed %g: event %d is %+v", speed, i, ev)
+			}
+		}
+		orig := events[len(events)-1].Time.Sub(events[0].Time)
+		if speed == 0.0 {
+			if elapsed > orig/2 {
+				t.Errorf("speed 0: replay took %v", elapsed)
+			}
+			continue
+		}
+		want := time.Duration(float64(orig) / speed)
+		if elapsed < want || elapsed > want+100*time.Millisecond {
+			t.Errorf("speed %g: replay took %v, want %v", speed, elapsed,
+				want)
+		}
+	}
+}
+
+// Close muss eine laufende Wiedergabe sofort beenden (mit -race pruefen).
+func TestReplayClose(t *testing.T) {
+	start := time.Now()
+	events := []PenEvent{
+		{Type: PenPress, Time: start},
+		{Type: PenRelease, Time: start.Add(time.Hour)},
+	}
+	tch := newTestTouch(eventQueueSize)
+	tch.done = make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		tch.Replay(events, 1.0)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	tch.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Replay still running after Close")
+	}
+	tch.Close()
+}
+
+// Wiedergegebene Events duerfen nicht nochmals aufgezeichnet werden.
+func TestReplayNotRecorded(t *testing.T) {
+	var buf bytes.Buffer
+
+	tch := newTestTouch(eventQueueSize)
+	tch.StartRecording(&buf)
+	tch.Replay(recordTestEvents, 0.0)
+	if err := tch.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("replayed events recorded:\n%s", buf.String())
+	}
+}
+
+// Ein Writer, welcher bis zum Schliessen von release blockiert.
+type blockingWriter struct {
+	release chan bool
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// Ein langsamer Writer darf den Dispatcher nicht blockieren; die
+// verlorenen Events werden von StopRecording gemeldet.
+func TestRecordOverflow(t *testing.T) {
+	w := blockingWriter{make(chan bool)}
+	tch := newTestTouch(eventQueueSize)
+	tch.StartRecording(w)
+	for i := range 2 * recordQueueSize {
+		tch.enqueueEvent(penEventAt(PenDrag, float64(i), 0, 0, 0))
+	}
+	close(w.release)
+	if err := tch.StopRecording(); !errors.Is(err, ErrRecordingOverflow) {
+		t.Errorf("got %v, want %v", err, ErrRecordingOverflow)
+	}
+}
+
+func TestRecordFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "session.jsonl")
+
+	tch := newTestTouch(eventQueueSize)
+	if err := tch.StartRecordingFile(fileName); err != nil {
+		t.Fatalf("StartRecordingFile: %v", err)
+	}
+	for _, ev := range recordTestEvents {
+		tch.enqueueEvent(ev)
+	}
+	tch.Close()
+
+	tch = newTestTouch(eventQueueSize)
+	if err := tch.ReplayFile(fileName, 0.0); err != nil {
+		t.Fatalf("ReplayFile: %v", err)
+	}
+	if len(tch.EventQ) != len(recordTestEvents) {
+		t.Errorf("got %d events, want %d", len(tch.EventQ),
+			len(recordTestEvents))
+	}
+}
+
+func TestLoadRecordingErrors(t *testing.T) {
+	testList := []string{
+		`{"Type":"PenPress","X":1}` + "\n" + `{"Type":"PenHover"}`,
+		`{"Type":"PenDrag"`,
+	}
+	for _, test := range testList {
+		if _, err := LoadRecording(strings.NewReader(test)); !errors.Is(err,
+			ErrRecording) {
+			t.Errorf("%q: got %v, want %v", test, err, ErrRecording)
+		}
+	}
+}
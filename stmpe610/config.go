@@ -0,0 +1,89 @@
+package stmpe610
+
+// In Config sind die Registerwerte zusammengefasst, mit welchen die Messung
+// des Touchscreens eingestellt wird (Fenster-Tracking, Mittelung, Wartezeiten,
+// ADC, Treiberstrom, etc.). Die Werte werden direkt aus den Konstanten
+// dieses Packages zusammengesetzt.
+type Config struct {
+	// Inhalt von TSC_CTRL ohne das Bit TSC_CTRL_EN (z.B.
+	// TSC_CTRL_WTRK8|TSC_CTRL_XYZ).
+	TscCtrl uint8
+	// Inhalt von TSC_CFG (Mittelung, Verzoegerung und Einschwingzeit).
+	TscCfg uint8
+	// Inhalte von ADC_CTRL1 (Aufloesung, Sample-Zeit) und ADC_CTRL2
+	// (Taktfrequenz).
+	AdcCtrl1, AdcCtrl2 uint8
+	// Format des Z-Wertes (TSC_FRACT_Z_x_y).
+	FractZ uint8
+	// Treiberstrom (TSC_I_DRIVE_20MA oder TSC_I_DRIVE_50MA).
+	IDrive uint8
+	// Anzahl Messwerte in der FIFO, ab welcher ein Interrupt ausgeloest
+	// wird.
+	FifoTh uint8
+}
+
+var (
+	// Die bisher fest eingestellten Werte (siehe Init).
+	DefaultConfig = Config{
+		TscCtrl:  TSC_CTRL_WTRK8 | TSC_CTRL_XYZ,
+		TscCfg:   TSC_CFG_4SAMPLE | TSC_CFG_DELAY_1MS | TSC_CFG_SETTLE_5MS,
+		AdcCtrl1: ADC_CTRL1_10BIT | ADC_CTRL1_124CLK,
+		AdcCtrl2: ADC_CTRL2_6_5MHZ,
+		FractZ:   TSC_FRACT_Z_3_5,
+		IDrive:   TSC_I_DRIVE_50MA,
+		FifoTh:   1,
+	}
+)
+
+// Liefert die Taktfrequenz des ADC in Hz, wie sie mit AdcCtrl2 eingestellt
+// wird.
+func (cfg Config) AdcClock() int {
+	switch cfg.AdcCtrl2 & 0x03 {
+	case ADC_CTRL2_1_625MHZ:
+		return 1_625_000
+	case ADC_CTRL2_3_25MHZ:
+		return 3_250_000
+	default:
+		return 6_500_000
+	}
+}
+
+// Alles, was Register des STMPE610 beschreiben kann (der Controller selber,
+// die Dummy-Implementation oder das Interface in adatft).
+type RegWriter interface {
+	WriteReg8(addr uint8, value uint8)
+}
+
+// Schreibt die Konfiguration cfg in die Register des Controllers. Laut
+// Datenblatt duerfen TSC_CFG und die ADC-Register nur bei ausgeschaltetem
+// Touchscreen-Controller veraendert werden; er wird daher waehrend des
+// Schreibens deaktiviert. Die FIFO wird anschliessend geleert, damit keine
+// Messwerte mit der alten Konfiguration mehr gelesen werden.
+func WriteConfig(d RegWriter, cfg Config) {
+	d.WriteReg8(TSC_CTRL, cfg.TscCtrl&^TSC_CTRL_EN)
+	d.WriteReg8(ADC_CTRL1, cfg.AdcCtrl1)
+	d.WriteReg8(ADC_CTRL2, cfg.AdcCtrl2)
+	d.WriteReg8(TSC_CFG, cfg.TscCfg)
+	d.WriteReg8(TSC_FRACTION_Z, cfg.FractZ)
+	d.WriteReg8(TSC_I_DRIVE, cfg.IDrive)
+	d.WriteReg8(FIFO_TH, max(1, cfg.FifoTh))
+	d.WriteReg8(FIFO_STA, FIFO_STA_RESET)
+	d.WriteReg8(FIFO_STA, 0)
+	d.WriteReg8(TSC_CTRL, cfg.TscCtrl|TSC_CTRL_EN)
+}
+
+// Ermittelt die Konfiguration aus den Initialisierungsparametern von Init:
+// entweder eine vollstaendige Config oder (wie bisher) nur das Format des
+// Z-Wertes als byte.
+func configFromParams(params []any) Config {
+	cfg := DefaultConfig
+	if len(params) > 0 {
+		switch p := params[0].(type) {
+		case Config:
+			cfg = p
+		case byte:
+			cfg.FractZ = p
+		}
+	}
+	return cfg
+}
@@ -38,8 +38,14 @@ func (d *STMPE610Dummy) Close() {
 func (d *STMPE610Dummy) Init(initParams []any) {
 }
 
+// Die Identifikation wird wie bei einem echten STMPE610 geliefert, damit
+// OpenTouch auch ohne Hardware (z.B. fuer die Wiedergabe von Aufzeichnungen)
+// verwendet werden kann.
 func (d *STMPE610Dummy) ReadReg8(addr uint8) uint8 {
 	var rxBuf []byte = []byte{0x00, 0x00}
+	if addr == ID_VER {
+		rxBuf[1] = 0x03
+	}
 	return rxBuf[1]
 }
 
@@ -48,11 +54,15 @@ func (d *STMPE610Dummy) WriteReg8(addr uint8, value uint8) {
 
 func (d *STMPE610Dummy) ReadReg16(addr uint8) uint16 {
 	var rxBuf []byte = []byte{0x00, 0x00, 0x00}
+	if addr == CHIP_ID {
+		rxBuf[1], rxBuf[2] = 0x08, 0x11
+	}
 	return (uint16(rxBuf[1]) << 8) | uint16(rxBuf[2])
 }
 
 func (d *STMPE610Dummy) WriteReg16(addr uint8, value uint16) {
-	// Nicht implementiert
+	d.WriteReg8(addr, uint8(value>>8))
+	d.WriteReg8(addr+1, uint8(value))
 }
 
 func (d *STMPE610Dummy) ReadData() (x, y uint16, z uint8) {
@@ -0,0 +1,145 @@
+package stmpe610
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Neben dem Touchscreen verfuegt der STMPE610 ueber GPIOs und Eingaenge des
+// ADC, welche sich die Pins 0 bis 7 teilen. Die Pins 4 bis 7 werden vom
+// Touchscreen belegt (siehe TouchPins), die uebrigen koennen entweder als
+// GPIO oder als ADC-Kanal verwendet werden (welche davon tatsaechlich
+// herausgefuehrt sind, haengt von der Ausfuehrung des Chips ab). Die Pins
+// werden als Bitmaske angegeben (Bit 0 fuer Pin 0, usw.).
+
+var (
+	ErrGPIOPin    = errors.New("stmpe610: pin reserved for the touchscreen")
+	ErrADCChannel = errors.New("stmpe610: invalid ADC channel")
+	ErrADCTimeout = errors.New("stmpe610: ADC conversion timed out")
+)
+
+const (
+	// Maximale Dauer einer Wandlung durch den ADC.
+	adcTimeout = 10 * time.Millisecond
+)
+
+func checkPins(pins uint8) error {
+	if pins&TouchPins != 0 {
+		return fmt.Errorf("%w: %08b", ErrGPIOPin, pins&TouchPins)
+	}
+	return nil
+}
+
+// Setzt die Bits mask des Registers addr auf die Werte in value.
+func (d *STMPE610) updateReg8(addr, mask, value uint8) {
+	d.WriteReg8(addr, (d.ReadReg8(addr)&^mask)|(value&mask))
+}
+
+// Konfiguriert die Pins pins als GPIO, als Ausgang (output ist true) oder
+// als Eingang.
+func (d *STMPE610) SetGPIOMode(pins uint8, output bool) error {
+	if err := checkPins(pins); err != nil {
+		return err
+	}
+	d.regMutex.Lock()
+	defer d.regMutex.Unlock()
+	dir := uint8(0)
+	if output {
+		dir = pins
+	}
+	d.updateReg8(GPIO_DIR, pins, dir)
+	d.updateReg8(GPIO_AF, pins, pins)
+	return nil
+}
+
+// Setzt die Ausgaenge pins auf High (high ist true) oder Low.
+func (d *STMPE610) WriteGPIO(pins uint8, high bool) error {
+	if err := checkPins(pins); err != nil {
+		return err
+	}
+	if high {
+		d.WriteReg8(GPIO_SET_PIN, pins)
+	} else {
+		d.WriteReg8(GPIO_CLR_PIN, pins)
+	}
+	return nil
+}
+
+// Liefert den aktuellen Zustand aller Pins.
+func (d *STMPE610) ReadGPIO() uint8 {
+	return d.ReadReg8(GPIO_MP_STA)
+}
+
+// Legt fest, bei welchen Flanken an den Pins pins ein Interrupt ausgeloest
+// wird. Sind weder rising noch falling gesetzt, werden die Interrupts
+// dieser Pins ausgeschaltet. Die Interrupts werden ueber den gleichen
+// Callback wie diejenigen des Touchscreens gemeldet (siehe SetCallback und
+// GPIOEdges).
+func (d *STMPE610) SetGPIOEdges(pins uint8, rising, falling bool) error {
+	if err := checkPins(pins); err != nil {
+		return err
+	}
+	d.regMutex.Lock()
+	defer d.regMutex.Unlock()
+	mask := func(on bool) uint8 {
+		if on {
+			return pins
+		}
+		return 0
+	}
+	d.updateReg8(GPIO_RE, pins, mask(rising))
+	d.updateReg8(GPIO_FE, pins, mask(falling))
+	d.WriteReg8(GPIO_ED, pins)
+	d.WriteReg8(GPIO_INT_STA, pins)
+	d.updateReg8(GPIO_INT_EN, pins, mask(rising || falling))
+	intEn := uint8(0)
+	if d.ReadReg8(GPIO_INT_EN) != 0 {
+		intEn = INT_GPIO
+	}
+	d.updateReg8(INT_EN, INT_GPIO, intEn)
+	return nil
+}
+
+// Liefert die Pins, an welchen seit dem letzten Aufruf eine Flanke erkannt
+// wurde, sowie den aktuellen Zustand aller Pins. Die Flanken werden dabei
+// quittiert; das Bit INT_GPIO in INT_STA muss vom Aufrufer zurueckgesetzt
+// werden.
+func (d *STMPE610) GPIOEdges() (pins, level uint8) {
+	d.regMutex.Lock()
+	defer d.regMutex.Unlock()
+	pins = d.ReadReg8(GPIO_INT_STA)
+	level = d.ReadReg8(GPIO_MP_STA)
+	if pins != 0 {
+		d.WriteReg8(GPIO_INT_STA, pins)
+		d.WriteReg8(GPIO_ED, pins)
+	}
+	return pins, level
+}
+
+// Fuehrt eine Wandlung auf dem ADC-Kanal channel durch und liefert den
+// Rohwert (10 oder 12 Bit, je nach Konfiguration in ADC_CTRL1). Der Pin
+// wird dazu (falls noetig) von der GPIO-Funktion auf den ADC umgestellt.
+// Der ADC wird mit dem Touchscreen geteilt; waehrend einer Beruehrung kann
+// die Wandlung daher etwas laenger dauern.
+func (d *STMPE610) ReadADC(channel int) (uint16, error) {
+	if channel < 0 || channel > 7 {
+		return 0, fmt.Errorf("%w: %d", ErrADCChannel, channel)
+	}
+	bit := uint8(1) << channel
+	if err := checkPins(bit); err != nil {
+		return 0, err
+	}
+	d.regMutex.Lock()
+	defer d.regMutex.Unlock()
+	d.updateReg8(GPIO_AF, bit, 0)
+	d.WriteReg8(ADC_CAPT, bit)
+	deadline := time.Now().Add(adcTimeout)
+	for d.ReadReg8(ADC_CAPT)&bit == 0 {
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("%w: channel %d", ErrADCTimeout, channel)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	return d.ReadReg16(ADC_DATA_CH0+2*uint8(channel)) & 0x0FFF, nil
+}
@@ -0,0 +1,136 @@
+package stmpe610
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func expectWrites(t *testing.T, c *regConn, what string, want ...regWrite) {
+	t.Helper()
+	if got := c.takeWrites(); !slices.Equal(got, want) {
+		t.Errorf("%s: got writes %x, want %x", what, got, want)
+	}
+}
+
+func TestSetGPIOMode(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c}
+	c.setReg(GPIO_DIR, 0x01)
+
+	if err := d.SetGPIOMode(0x06, false); err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, c, "input", regWrite{GPIO_DIR, 0x01},
+		regWrite{GPIO_AF, 0x06})
+	if err := d.SetGPIOMode(0x02, true); err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, c, "output", regWrite{GPIO_DIR, 0x03},
+		regWrite{GPIO_AF, 0x06})
+
+	if err := d.SetGPIOMode(0x11, true); !errors.Is(err, ErrGPIOPin) {
+		t.Errorf("touch pin: got %v, want ErrGPIOPin", err)
+	}
+	expectWrites(t, c, "touch pin")
+}
+
+func TestWriteGPIO(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c}
+
+	if err := d.WriteGPIO(0x05, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteGPIO(0x01, false); err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, c, "set/clear", regWrite{GPIO_SET_PIN, 0x05},
+		regWrite{GPIO_CLR_PIN, 0x01})
+	if err := d.WriteGPIO(TouchPins, true); !errors.Is(err, ErrGPIOPin) {
+		t.Errorf("touch pins: got %v, want ErrGPIOPin", err)
+	}
+	expectWrites(t, c, "touch pins")
+}
+
+func TestSetGPIOEdges(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c}
+	c.setReg(GPIO_FE, 0x08)
+	c.setReg(INT_EN, INT_TOUCH_DET|INT_FIFO_TH)
+
+	if err := d.SetGPIOEdges(0x03, true, false); err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, c, "rising",
+		regWrite{GPIO_RE, 0x03},
+		regWrite{GPIO_FE, 0x08},
+		regWrite{GPIO_ED, 0x03},
+		regWrite{GPIO_INT_STA, 0x03},
+		regWrite{GPIO_INT_EN, 0x03},
+		regWrite{INT_EN, INT_TOUCH_DET | INT_FIFO_TH | INT_GPIO})
+
+	if err := d.SetGPIOEdges(0x03, false, false); err != nil {
+		t.Fatal(err)
+	}
+	expectWrites(t, c, "off",
+		regWrite{GPIO_RE, 0x00},
+		regWrite{GPIO_FE, 0x08},
+		regWrite{GPIO_ED, 0x03},
+		regWrite{GPIO_INT_STA, 0x03},
+		regWrite{GPIO_INT_EN, 0x00},
+		regWrite{INT_EN, INT_TOUCH_DET | INT_FIFO_TH})
+}
+
+func TestReadADC(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c}
+	c.setReg(GPIO_AF, 0x0F)
+	// Die Wandlung ist sofort abgeschlossen; die oberen vier Bit des
+	// Datenregisters sind nicht definiert.
+	c.onWrite = func(c *regConn, w regWrite) {
+		if w.addr == ADC_CAPT {
+			c.regs[ADC_DATA_CH0+4] = 0xF2
+			c.regs[ADC_DATA_CH0+5] = 0x34
+		}
+	}
+
+	v, err := d.ReadADC(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0x0234 {
+		t.Errorf("got %#04x, want 0x0234", v)
+	}
+	expectWrites(t, c, "channel 2", regWrite{GPIO_AF, 0x0B},
+		regWrite{ADC_CAPT, 0x04})
+
+	// Eine Wandlung, welche nie abgeschlossen wird.
+	c.onWrite = func(c *regConn, w regWrite) {
+		if w.addr == ADC_CAPT {
+			c.regs[ADC_CAPT] = 0
+		}
+	}
+	if _, err := d.ReadADC(1); !errors.Is(err, ErrADCTimeout) {
+		t.Errorf("got %v, want ErrADCTimeout", err)
+	}
+
+	if _, err := d.ReadADC(8); !errors.Is(err, ErrADCChannel) {
+		t.Errorf("channel 8: got %v, want ErrADCChannel", err)
+	}
+	if _, err := d.ReadADC(4); !errors.Is(err, ErrGPIOPin) {
+		t.Errorf("channel 4: got %v, want ErrGPIOPin", err)
+	}
+}
+
+func TestWriteReg16(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c}
+
+	d.WriteReg16(ADC_DATA_CH0, 0x1234)
+	expectWrites(t, c, "WriteReg16", regWrite{ADC_DATA_CH0, 0x12},
+		regWrite{ADC_DATA_CH0 + 1, 0x34})
+	if v := d.ReadReg16(ADC_DATA_CH0); v != 0x1234 {
+		t.Errorf("ReadReg16: got %#04x, want 0x1234", v)
+	}
+}
@@ -2,6 +2,7 @@ package stmpe610
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"periph.io/x/conn/v3/gpio"
@@ -30,9 +31,22 @@ const (
 	INT_CTRL       = 0x09
 	INT_EN         = 0x0A
 	INT_STA        = 0x0B
+	GPIO_INT_EN    = 0x0C
+	GPIO_INT_STA   = 0x0D
+	ADC_INT_EN     = 0x0E
+	ADC_INT_STA    = 0x0F
+	GPIO_SET_PIN   = 0x10
+	GPIO_CLR_PIN   = 0x11
+	GPIO_MP_STA    = 0x12
+	GPIO_DIR       = 0x13
+	GPIO_ED        = 0x14
+	GPIO_RE        = 0x15
+	GPIO_FE        = 0x16
+	GPIO_AF        = 0x17
 	ADC_CTRL1      = 0x20
 	ADC_CTRL2      = 0x21
 	ADC_CAPT       = 0x22
+	ADC_DATA_CH0   = 0x30
 	TSC_CTRL       = 0x40
 	TSC_CFG        = 0x41
 	FIFO_TH        = 0x4A
@@ -46,6 +60,10 @@ const (
 	//
 	SYS_CTRL1_RESET = 0x02
 
+	SYS_CTRL2_ADC_OFF  = 0x01
+	SYS_CTRL2_TSC_OFF  = 0x02
+	SYS_CTRL2_GPIO_OFF = 0x04
+
 	INT_CTRL_POL_HIGH = 0x04
 	INT_CTRL_POL_LOW  = 0x00
 	INT_CTRL_EDGE     = 0x02
@@ -58,6 +76,8 @@ const (
 	INT_FIFO_OFLOW = 0x04
 	INT_FIFO_FULL  = 0x08
 	INT_FIFO_EMPTY = 0x10
+	INT_ADC        = 0x40
+	INT_GPIO       = 0x80
 
 	ADC_CTRL1_10BIT  = 0x00
 	ADC_CTRL1_12BIT  = 0x08
@@ -132,17 +152,39 @@ const (
 	TSC_GROUND_Y_P = 0x02
 	TSC_GROUND_Y_N = 0x01
 
+	// Die Pins 4 bis 7 (X-, Y-, X+, Y+) werden vom Touchscreen belegt und
+	// stehen weder als GPIO noch als ADC-Kanal zur Verfuegung.
+	TouchPins = 0xF0
+
 	// Dies schlussendlich sind Konstanten, welche in Zusammenhang mit einer
 	// konkrete Verwendung des Adafruit TFT-Display auf einem RaspberryPi
 	// oder ASUS TinkerBoard stehen.
 	//
 	SpiDevFile = "/dev/spidev0.1"
 	IntPin     = "GPIO24"
+
+	// Mit diesem Intervall wird der Controller abgefragt, wenn auf den
+	// Polling-Modus gewechselt werden muss (siehe SetPollInterval).
+	DefaultPollInterval = 10 * time.Millisecond
+
+	// Laengste Wartezeit auf eine Flanke am Interrupt-Pin. Danach wird
+	// geprueft, ob inzwischen auf Polling umgestellt wurde, da nicht jede
+	// Implementation von gpio.PinIn ein laufendes WaitForEdge mit Halt
+	// beendet.
+	edgeWaitTimeout = 100 * time.Millisecond
 )
 
 type STMPE610 struct {
 	spi spi.Conn
 	pin gpio.PinIn
+
+	mutex        sync.Mutex
+	pollInterval time.Duration
+	touched      bool
+	edgeTime     time.Time
+
+	// Schuetzt das Lesen und Veraendern der GPIO- und ADC-Register.
+	regMutex sync.Mutex
 }
 
 // Oeffnet eine Verbindung zum Touchscreen-Controller STMPE610 ueber den
@@ -152,7 +194,9 @@ type STMPE610 struct {
 // ist ein Pointer auf eine STMPE610 Struktur.
 //
 // Beim auftreten eines Fehlers wird das Programm abgebrochen. Ausserdem
-// wird der Pin fuer das Empfangen von Interrupts konfiguriert.
+// wird der Pin fuer das Empfangen von Interrupts konfiguriert. Ist dies
+// nicht moeglich (Pin nicht vorhanden oder keine Flankenerkennung), wird
+// der Controller stattdessen regelmaessig abgefragt (siehe SetPollInterval).
 func Open(speedHz physic.Frequency) *STMPE610 {
 	var err error
 	var d *STMPE610
@@ -166,13 +210,19 @@ func Open(speedHz physic.Frequency) *STMPE610 {
 		log.Fatalf("OpenSTMPE610(): error on port.Connect(): %v", err)
 	}
 	if d.pin = gpioreg.ByName(IntPin); d.pin == nil {
-		log.Fatal("OpenSTMPE610(): gpio io pin not found")
+		log.Printf("OpenSTMPE610(): gpio io pin not found; using polling " +
+			"mode")
+		d.pollInterval = DefaultPollInterval
+		return d
 	}
 	// Grosse Frage, was hier genommen werden soll
 	// - PullUp und FallingEdge sicher auf einem Raspi-4 mit Dietpi und dem
 	//   3.5'' Adafruit Display (TFT: HX8357, Touch: STMPE610)
 	if err = d.pin.In(gpio.PullUp, gpio.FallingEdge); err != nil {
-		log.Fatalf("OpenSTMPE610(): couldn't configure interrupt pin: %v", err)
+		log.Printf("OpenSTMPE610(): couldn't configure interrupt pin: %v; "+
+			"using polling mode", err)
+		d.pin = nil
+		d.pollInterval = DefaultPollInterval
 	}
 
 	return d
@@ -181,7 +231,9 @@ func Open(speedHz physic.Frequency) *STMPE610 {
 // Schliesst die Verbindung zum STMPE610 und gibt alle damit verbundenen
 // Ressourcen wieder frei.
 func (d *STMPE610) Close() {
-	d.pin.Halt()
+	if pin, _ := d.mode(); pin != nil {
+		pin.Halt()
+	}
 	// err := d.spi.Close()
 	// check("spi.Close()", err)
 }
@@ -191,9 +243,12 @@ func (d *STMPE610) Close() {
 // dem Internet zusammenorchestriert - geschmückt mit vielen Stunden
 // 'try and error'. Verbesserungen und Vorschläge sind jederzeit herzlich
 // willkommen.
+//
+// Als Parameter kann entweder eine Config (siehe DefaultConfig) oder nur
+// das Format des Z-Wertes (TSC_FRACT_Z_x_y) als byte uebergeben werden.
 func (d *STMPE610) Init(params []any) {
 
-	zFract := params[0].(byte)
+	cfg := configFromParams(params)
 
 	// System Register (SYS_XXX)
 	//
@@ -204,57 +259,26 @@ func (d *STMPE610) Init(params []any) {
 	}
 	d.WriteReg8(SYS_CTRL2, 0x00)
 
-	// Touchscreen Register (TSC_XXX)
-	//
-	// Touchscreen Controller Control
-	// - set the window tracking feature to 8 pixels
-	// - acquire X, Y, Z data
-	// - enable touch screen control
+	// Touchscreen-, ADC- und FIFO-Register (TSC_XXX, ADC_XXX, FIFO_XXX)
+	// gemaess der Konfiguration. Mit DefaultConfig sind dies:
+	// - window tracking of 8 pixels, acquire X, Y, Z data
+	// - average 4 samples, touch detect delay of 1ms, settling time of 5ms
+	// - 10 bit ADC, 124 clocks sample time, 6.5 MHz ADC clock
+	// - 50mA drive current, FIFO threshold of 1
 	//
-	d.WriteReg8(TSC_CTRL,
-		TSC_CTRL_WTRK8|TSC_CTRL_XYZ|TSC_CTRL_EN)
-	d.WriteReg8(TSC_FRACTION_Z, zFract)
-
-	// Touchscreen Controller Configuration
-	// - average 4 samples
-	// - set a touch detect delay of 1ms
-	// - set a settling time of 5ms
-	//
-	d.WriteReg8(TSC_CFG,
-		TSC_CFG_4SAMPLE|TSC_CFG_DELAY_1MS|TSC_CFG_SETTLE_5MS)
-
-	// Analog Digital Converter Register (ADC_XXX)
-	// (Wozu braucht es diese?)
-	//
-	d.WriteReg8(ADC_CTRL1,
-		ADC_CTRL1_10BIT|(0x6<<4)) // Ada
-	//ADC_CTRL1_36CLK)
-
-	d.WriteReg8(ADC_CTRL2,
-		ADC_CTRL2_6_5MHZ)
-
-	//d.WriteReg8(ADC_CAPT,
-	//	ADC_CAPT_ALL)
-
-	// FIFO Register (FIFO_XXX)
-	//
-	d.WriteReg8(FIFO_TH, 1)
-	d.WriteReg8(FIFO_STA, FIFO_STA_RESET)
-	d.WriteReg8(FIFO_STA, 0)
-
-	d.WriteReg8(TSC_I_DRIVE, TSC_I_DRIVE_50MA)
+	WriteConfig(d, cfg)
 
 	// Interrupt Register (INT_XXX)
 	//
-	// Wir abonnieren uns auf zwei Events: das Drücken, respl. Loslassen
-	// des Bildschirms (beide Ereignisse generieren das gleiche Event) sowie
-	// das Erreichen eines bestimmten Schwellwertes bei der FIFO-Queue
+	// Wir abonnieren uns auf drei Events: das Drücken, respl. Loslassen
+	// des Bildschirms (beide Ereignisse generieren das gleiche Event), das
+	// Erreichen eines bestimmten Schwellwertes bei der FIFO-Queue sowie
+	// deren Ueberlauf (Messwerte sind verloren gegangen).
 
 	d.WriteReg8(INT_EN,
-		INT_TOUCH_DET|INT_FIFO_TH)
+		INT_TOUCH_DET|INT_FIFO_TH|INT_FIFO_OFLOW)
 	//		INT_FIFO_EMPTY |
-	//		INT_FIFO_FULL |
-	//		INT_FIFO_OFLOW)
+	//		INT_FIFO_FULL)
 
 	// Reset all interupts to begin with
 	d.WriteReg8(INT_STA, 0xFF)
@@ -292,8 +316,17 @@ func (d *STMPE610) ReadReg16(addr uint8) uint16 {
 	return (uint16(rxBuf[1]) << 8) | uint16(rxBuf[2])
 }
 
+// Die 16-Bit Register liegen im Big-Endian-Format vor (siehe ReadReg16),
+// das hoeherwertige Byte wird daher an die Adresse addr geschrieben.
 func (d *STMPE610) WriteReg16(addr uint8, value uint16) {
-	// Nicht implementiert
+	var buf []byte = []byte{addr, uint8(value >> 8)}
+	if err := d.spi.Tx(buf, nil); err != nil {
+		log.Fatalf("WriteReg16(): %s", err)
+	}
+	buf = []byte{addr + 1, uint8(value)}
+	if err := d.spi.Tx(buf, nil); err != nil {
+		log.Fatalf("WriteReg16(): %s", err)
+	}
 }
 
 func (d *STMPE610) ReadData() (x, y uint16, z uint8) {
@@ -309,15 +342,116 @@ func (d *STMPE610) ReadData() (x, y uint16, z uint8) {
 	return
 }
 
+// Setzt das Intervall, mit welchem der Controller im Polling-Modus
+// abgefragt wird. Mit 0 werden (sofern verfuegbar) wieder die Interrupts
+// verwendet; die Flankenerkennung am Interrupt-Pin wird dazu neu
+// eingerichtet. Ohne Interrupt-Pin (oder ohne Flankenerkennung) bleibt der
+// Polling-Modus mit DefaultPollInterval aktiv. Der Wechsel erfolgt nach
+// Ablauf des bisherigen Intervalls bzw. spaetestens nach edgeWaitTimeout.
+func (d *STMPE610) SetPollInterval(interval time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if interval <= 0 && d.pollInterval > 0 && d.pin != nil {
+		if err := d.pin.In(gpio.PullUp, gpio.FallingEdge); err != nil {
+			log.Printf("SetPollInterval(): couldn't configure interrupt "+
+				"pin: %v; using polling mode", err)
+			d.pin = nil
+		}
+	}
+	if interval <= 0 && d.pin == nil {
+		interval = DefaultPollInterval
+	}
+	if interval > 0 && d.pollInterval == 0 && d.pin != nil {
+		// Schaltet die Flankenerkennung aus (und beendet je nach
+		// Implementation ein laufendes WaitForEdge).
+		d.pin.Halt()
+	}
+	d.pollInterval = interval
+}
+
+// Liefert den Interrupt-Pin und das Intervall des Polling-Modus.
+func (d *STMPE610) mode() (gpio.PinIn, time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.pin, d.pollInterval
+}
+
+// Wird aufgerufen, wenn WaitForEdge am Pin pin ohne Flanke sofort
+// zurueckkehrt, d.h. keine Flankenerkennung vorhanden ist. Der Pin wird
+// danach nicht mehr verwendet.
+func (d *STMPE610) disableEdges(pin gpio.PinIn) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.pin != pin || d.pollInterval > 0 {
+		// Es wurde inzwischen auf Polling umgestellt.
+		return
+	}
+	log.Printf("WaitForEdge() not available; using polling mode\n")
+	d.pin = nil
+	d.pollInterval = DefaultPollInterval
+}
+
+// Liefert das Intervall des Polling-Modus oder 0, falls die Interrupts
+// verwendet werden.
+func (d *STMPE610) PollInterval() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.pollInterval
+}
+
+// Im Interrupt-Modus wird cbFunc bei jeder Flanke am Interrupt-Pin
+// aufgerufen. Im Polling-Modus werden TSC_CTRL und FIFO_SIZE regelmaessig
+// gelesen und cbFunc aufgerufen, sobald Daten vorliegen oder der Touchscreen
+// gedrueckt bzw. losgelassen wurde. Kehrt WaitForEdge ohne Flanke sofort
+// zurueck (keine Flankenerkennung), wird dauerhaft auf Polling umgestellt.
+// Zwischen den beiden Modi kann mit SetPollInterval jederzeit gewechselt
+// werden.
 func (d *STMPE610) SetCallback(cbFunc func(any), cbData any) {
 	go func() {
 		for {
-			if d.pin.WaitForEdge(24 * time.Hour) {
+			pin, interval := d.mode()
+			if interval > 0 {
+				time.Sleep(interval)
+				if d.pending() {
+					d.setEdgeTime(time.Now())
+					cbFunc(cbData)
+				}
+				continue
+			}
+			start := time.Now()
+			if pin.WaitForEdge(edgeWaitTimeout) {
+				d.setEdgeTime(time.Now())
 				cbFunc(cbData)
-			} else {
-				log.Printf("WaitForEdge() returned 'false'\n")
+				continue
+			}
+			if time.Since(start) < edgeWaitTimeout/2 {
+				d.disableEdges(pin)
 			}
 		}
 	}()
 }
 
+func (d *STMPE610) setEdgeTime(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.edgeTime = t
+}
+
+// Liefert den Zeitpunkt der letzten Flanke am Interrupt-Pin (bzw. im
+// Polling-Modus den Zeitpunkt, zu welchem die Daten erkannt wurden), welche
+// zum Aufruf des Callbacks gefuehrt hat.
+func (d *STMPE610) EdgeTime() time.Time {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.edgeTime
+}
+
+// Prueft im Polling-Modus, ob der Callback aufgerufen werden muss.
+func (d *STMPE610) pending() bool {
+	touched := d.ReadReg8(TSC_CTRL)&TSC_CTRL_STATUS != 0
+	changed := touched != d.touched
+	d.touched = touched
+	return changed || d.ReadReg8(FIFO_SIZE) > 0 ||
+		d.ReadReg8(INT_STA)&INT_GPIO != 0
+}
+
@@ -0,0 +1,247 @@
+package stmpe610
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+	"periph.io/x/conn/v3/spi"
+)
+
+// Eine SPI-Verbindung ohne Hardware, welche die Register des STMPE610
+// nachbildet und alle Schreibzugriffe festhaelt. Lesezugriffe (Bit 7 der
+// Adresse gesetzt) liefern den Inhalt des jeweiligen Registers. Mit
+// onWrite kann das Verhalten des Controllers nach einem Schreibzugriff
+// nachgebildet werden (der Mutex ist dabei gesperrt).
+type regConn struct {
+	mutex   sync.Mutex
+	regs    [0x80]uint8
+	writes  []regWrite
+	onWrite func(c *regConn, w regWrite)
+}
+
+type regWrite struct {
+	addr, value uint8
+}
+
+func (c *regConn) String() string      { return "regConn" }
+func (c *regConn) Duplex() conn.Duplex { return conn.Full }
+func (c *regConn) TxPackets(p []spi.Packet) error {
+	return errors.New("regConn: TxPackets not supported")
+}
+
+func (c *regConn) Tx(w, r []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if r == nil {
+		if len(w) != 2 || w[0]&0x80 != 0 {
+			return errors.New("regConn: invalid write")
+		}
+		c.regs[w[0]] = w[1]
+		c.writes = append(c.writes, regWrite{w[0], w[1]})
+		if c.onWrite != nil {
+			c.onWrite(c, regWrite{w[0], w[1]})
+		}
+		return nil
+	}
+	for i := 0; i < len(w)-1; i++ {
+		r[i+1] = c.regs[w[i]&0x7F]
+	}
+	return nil
+}
+
+func (c *regConn) setReg(addr, value uint8) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.regs[addr] = value
+}
+
+// Liefert die bisherigen Schreibzugriffe und setzt die Liste zurueck.
+func (c *regConn) takeWrites() []regWrite {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w := c.writes
+	c.writes = nil
+	return w
+}
+
+// Ein Interrupt-Pin, dessen WaitForEdge sofort zurueckkehrt (keine
+// Flankenerkennung).
+type noEdgePin struct {
+	gpiotest.Pin
+}
+
+func (p *noEdgePin) WaitForEdge(timeout time.Duration) bool {
+	return false
+}
+
+// Ein Interrupt-Pin, welcher sich wie unter sysfs verhaelt: nach Halt ist
+// die Flankenerkennung ausgeschaltet (WaitForEdge wartet bis zum Timeout),
+// bis sie mit In wieder eingerichtet wird.
+type sysfsPin struct {
+	gpiotest.Pin
+	mutex sync.Mutex
+	armed bool
+}
+
+func newSysfsPin() *sysfsPin {
+	return &sysfsPin{Pin: gpiotest.Pin{N: IntPin,
+		EdgesChan: make(chan gpio.Level, 1)}}
+}
+
+func (p *sysfsPin) In(pull gpio.Pull, edge gpio.Edge) error {
+	p.mutex.Lock()
+	p.armed = edge != gpio.NoEdge
+	p.mutex.Unlock()
+	return p.Pin.In(pull, edge)
+}
+
+func (p *sysfsPin) Halt() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.armed = false
+	return nil
+}
+
+func (p *sysfsPin) WaitForEdge(timeout time.Duration) bool {
+	p.mutex.Lock()
+	armed := p.armed
+	p.mutex.Unlock()
+	if !armed {
+		time.Sleep(timeout)
+		return false
+	}
+	return p.Pin.WaitForEdge(timeout)
+}
+
+// Startet den Callback von d und liefert einen Channel, in welchen bei
+// jedem Aufruf geschrieben wird.
+func startCallback(d *STMPE610) <-chan bool {
+	calls := make(chan bool, 16)
+	d.SetCallback(func(any) {
+		select {
+		case calls <- true:
+		default:
+		}
+	}, nil)
+	return calls
+}
+
+func expectCall(t *testing.T, calls <-chan bool, what string) {
+	t.Helper()
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatalf("%s: callback not called", what)
+	}
+}
+
+func expectNoCall(t *testing.T, calls <-chan bool, what string) {
+	t.Helper()
+	select {
+	case <-calls:
+		t.Fatalf("%s: unexpected callback", what)
+	case <-time.After(3 * edgeWaitTimeout / 2):
+	}
+}
+
+func TestPollingWithoutPin(t *testing.T) {
+	c := &regConn{}
+	d := &STMPE610{spi: c, pollInterval: time.Millisecond}
+	calls := startCallback(d)
+
+	c.setReg(FIFO_SIZE, 1)
+	expectCall(t, calls, "data in FIFO")
+	c.setReg(FIFO_SIZE, 0)
+	for len(calls) > 0 {
+		<-calls
+	}
+	c.setReg(TSC_CTRL, TSC_CTRL_STATUS)
+	expectCall(t, calls, "touch detected")
+
+	d.SetPollInterval(0)
+	if d.PollInterval() != DefaultPollInterval {
+		t.Errorf("without pin: poll interval %v, want %v", d.PollInterval(),
+			DefaultPollInterval)
+	}
+}
+
+// Der Wechsel vom Interrupt- in den Polling-Modus und zurueck muss
+// jederzeit moeglich sein.
+func TestSwitchPollInterrupt(t *testing.T) {
+	c := &regConn{}
+	pin := newSysfsPin()
+	if err := pin.In(gpio.PullUp, gpio.FallingEdge); err != nil {
+		t.Fatal(err)
+	}
+	d := &STMPE610{spi: c, pin: pin}
+	calls := startCallback(d)
+
+	pin.EdgesChan <- gpio.Low
+	expectCall(t, calls, "interrupt mode")
+
+	d.SetPollInterval(time.Millisecond)
+	if d.PollInterval() != time.Millisecond {
+		t.Fatalf("poll interval %v", d.PollInterval())
+	}
+	time.Sleep(3 * edgeWaitTimeout / 2)
+	c.setReg(FIFO_SIZE, 1)
+	expectCall(t, calls, "polling mode")
+	c.setReg(FIFO_SIZE, 0)
+
+	d.SetPollInterval(0)
+	if d.PollInterval() != 0 {
+		t.Fatalf("back to interrupts: poll interval %v", d.PollInterval())
+	}
+	// Ein bereits begonnener Durchgang im Polling-Modus wird noch beendet.
+	time.Sleep(10 * time.Millisecond)
+	for len(calls) > 0 {
+		<-calls
+	}
+	c.setReg(FIFO_SIZE, 1)
+	expectNoCall(t, calls, "interrupt mode without edge")
+	pin.EdgesChan <- gpio.Low
+	expectCall(t, calls, "interrupt mode again")
+}
+
+// Ohne Flankenerkennung wird dauerhaft auf Polling umgestellt.
+func TestNoEdgeFallback(t *testing.T) {
+	c := &regConn{}
+	pin := &noEdgePin{}
+	d := &STMPE610{spi: c, pin: pin}
+	calls := startCallback(d)
+
+	deadline := time.Now().Add(time.Second)
+	for d.PollInterval() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no fallback to polling")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.setReg(FIFO_SIZE, 1)
+	expectCall(t, calls, "polling after fallback")
+
+	d.SetPollInterval(0)
+	if d.PollInterval() != DefaultPollInterval {
+		t.Errorf("without edges: poll interval %v, want %v",
+			d.PollInterval(), DefaultPollInterval)
+	}
+}
+
+func TestAdcClock(t *testing.T) {
+	for ctrl2, want := range map[uint8]int{
+		ADC_CTRL2_1_625MHZ: 1_625_000,
+		ADC_CTRL2_3_25MHZ:  3_250_000,
+		ADC_CTRL2_6_5MHZ:   6_500_000,
+		ADC_CTRL2_6_5_MHZ:  6_500_000,
+	} {
+		cfg := Config{AdcCtrl2: ctrl2}
+		if got := cfg.AdcClock(); got != want {
+			t.Errorf("ADC_CTRL2 %#x: got %d, want %d", ctrl2, got, want)
+		}
+	}
+}
@@ -122,6 +122,7 @@ func PrintStat() {
 //	fmt.Printf("  %v min\n", DispWatch.Min())
 //	fmt.Printf("  %v max\n", DispWatch.Max())
 	fmt.Printf("  %v / frame\n", DispWatch.Avg())
+	printLatency()
 }
 
 func ResetStat() {
@@ -129,4 +130,5 @@ func ResetStat() {
 	PaintWatch.Reset()
 	ConvWatch.Reset()
 	DispWatch.Reset()
+	latency.reset()
 }
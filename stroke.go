@@ -0,0 +1,526 @@
+package adatft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mit einem StrokeRecorder wird der Touchscreen als Unterschriften- oder
+// Skizzenfeld verwendet: aus den PenEvents werden Striche (von PenPress bis
+// PenRelease) mit Druck und Zeitpunkt jedes Messwertes gesammelt. Die
+// Striche werden mit Catmull-Rom-Splines geglaettet, auf Wunsch laufend
+// auf das Display gezeichnet (die Strichbreite haengt vom Druck ab) und
+// koennen als SVG, PNG oder JSON exportiert werden.
+
+var (
+	ErrStrokeData = errors.New("stroke: invalid stroke data")
+)
+
+// Ein Messwert eines Striches. Pressure ist der kalibrierte Druck im
+// Bereich [0,1] (siehe TouchPos.Z), Time der Zeitpunkt seit Beginn der
+// Aufzeichnung.
+type StrokePoint struct {
+	X, Y, Pressure float64
+	Time           time.Duration
+}
+
+// Ein Strich ist die Folge der Messwerte einer Beruehrung.
+type Stroke []StrokePoint
+
+// Dauer des Striches.
+func (s Stroke) Duration() time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1].Time - s[0].Time
+}
+
+// Liefert den mit Catmull-Rom-Splines geglaetteten Strich, wobei zwischen
+// zwei Messwerten steps Punkte erzeugt werden (die Messwerte selber liegen
+// auf dem Spline). Druck und Zeit werden ebenfalls interpoliert. Mit steps
+// kleiner 2 wird der Strich unveraendert geliefert.
+func (s Stroke) Smooth(steps int) Stroke {
+	if steps < 2 || len(s) < 3 {
+		return s
+	}
+	res := make(Stroke, 0, (len(s)-1)*steps+1)
+	for i := 0; i < len(s)-1; i++ {
+		res = s.appendSegment(res, i, steps)
+	}
+	return append(res, s[len(s)-1])
+}
+
+// Haengt die Punkte des Spline-Segmentes zwischen s[i] und s[i+1] an pts
+// an (ohne den Endpunkt s[i+1]). Am Anfang und am Ende des Striches wird
+// der jeweils letzte Messwert als fehlender Kontrollpunkt verwendet.
+func (s Stroke) appendSegment(pts []StrokePoint, i, steps int) []StrokePoint {
+	p0, p1, p2 := s[max(i-1, 0)], s[i], s[i+1]
+	p3 := s[min(i+2, len(s)-1)]
+	cr := func(a, b, c, d, t float64) float64 {
+		return 0.5 * (2.0*b + (c-a)*t + (2.0*a-5.0*b+4.0*c-d)*t*t +
+			(3.0*b-a-3.0*c+d)*t*t*t)
+	}
+	for j := 0; j < steps; j++ {
+		t := float64(j) / float64(steps)
+		pts = append(pts, StrokePoint{
+			X: cr(p0.X, p1.X, p2.X, p3.X, t),
+			Y: cr(p0.Y, p1.Y, p2.Y, p3.Y, t),
+			Pressure: min(max(cr(p0.Pressure, p1.Pressure, p2.Pressure,
+				p3.Pressure, t), 0.0), 1.0),
+			Time: p1.Time + time.Duration(t*float64(p2.Time-p1.Time)),
+		})
+	}
+	return pts
+}
+
+// Mit StrokeStyle wird das Aussehen der Striche festgelegt.
+type StrokeStyle struct {
+	// Farbe der Striche und des Hintergrundes (nur fuer den Export; nil
+	// bedeutet einen transparenten Hintergrund).
+	Color, Background color.Color
+	// Strichbreite (in Pixeln) bei minimalem und maximalem Druck.
+	MinWidth, MaxWidth float64
+	// Anzahl interpolierter Punkte zwischen zwei Messwerten (siehe
+	// Stroke.Smooth).
+	Smoothing int
+}
+
+var (
+	DefaultStrokeStyle = StrokeStyle{
+		Color:     color.Black,
+		MinWidth:  1.5,
+		MaxWidth:  4.0,
+		Smoothing: 8,
+	}
+)
+
+// Liefert die Strichbreite beim Druck pressure.
+func (st StrokeStyle) width(pressure float64) float64 {
+	return st.MinWidth + pressure*(st.MaxWidth-st.MinWidth)
+}
+
+// Das Format fuer den Export als JSON.
+type strokeData struct {
+	Width, Height int
+	Start         time.Time
+	Strokes       []Stroke
+}
+
+// Der StrokeRecorder sammelt die Striche (siehe oben). Die Events werden
+// mit Feed uebergeben, z.B. als Handler eines Abonnenten (siehe
+// Touch.Subscribe) oder mit Run aus EventQ. Alle Methoden koennen aus
+// verschiedenen Go-Routinen aufgerufen werden.
+type StrokeRecorder struct {
+	mutex   sync.Mutex
+	style   StrokeStyle
+	bounds  image.Rectangle
+	start   time.Time
+	strokes []Stroke
+	// true, solange der letzte Strich noch nicht abgeschlossen ist.
+	drawing bool
+	// Ziel fuer das laufende Zeichnen und die Anzahl bereits gezeichneter
+	// Segmente des aktuellen Striches.
+	canvas   draw.Image
+	flush    func(rects ...image.Rectangle) error
+	rendered int
+}
+
+// Erstellt einen neuen StrokeRecorder fuer eine Zeichenflaeche der Groesse
+// bounds (i.d.R. Display.Bounds) mit dem Aussehen style.
+func NewStrokeRecorder(bounds image.Rectangle,
+	style StrokeStyle) *StrokeRecorder {
+	return &StrokeRecorder{style: style, bounds: bounds}
+}
+
+// Zeichnet die Striche ab sofort laufend in den Framebuffer des Displays
+// dsp und sendet die veraenderten Bereiche zum TFT. Mit nil wird das
+// laufende Zeichnen beendet.
+func (sr *StrokeRecorder) SetDisplay(dsp *Display) {
+	if dsp == nil {
+		sr.SetCanvas(nil, nil)
+		return
+	}
+	sr.SetCanvas(dsp.Framebuffer(), dsp.Flush)
+}
+
+// Wie SetDisplay, die Striche werden jedoch in das Bild dst gezeichnet.
+// Ist flush nicht nil, wird die Funktion nach jedem neu gezeichneten
+// Stueck aufgerufen.
+func (sr *StrokeRecorder) SetCanvas(dst draw.Image,
+	flush func(rects ...image.Rectangle) error) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.canvas, sr.flush = dst, flush
+}
+
+// Liefert das Aussehen der Striche.
+func (sr *StrokeRecorder) Style() StrokeStyle {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	return sr.style
+}
+
+// Aendert das Aussehen der Striche. Bereits gezeichnete Striche werden
+// nicht neu gezeichnet; der Export verwendet jedoch das neue Aussehen.
+func (sr *StrokeRecorder) SetStyle(style StrokeStyle) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.style = style
+}
+
+// Liefert die Groesse der Zeichenflaeche.
+func (sr *StrokeRecorder) Bounds() image.Rectangle {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	return sr.bounds
+}
+
+// Verarbeitet das PenEvent ev: PenPress beginnt einen neuen Strich, PenDrag
+// verlaengert ihn und PenRelease schliesst ihn ab.
+func (sr *StrokeRecorder) Feed(ev PenEvent) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	switch ev.Type {
+	case PenPress:
+		if sr.drawing {
+			sr.finish()
+		}
+		if sr.start.IsZero() {
+			sr.start = ev.Time
+		}
+		sr.strokes = append(sr.strokes, Stroke{sr.point(ev)})
+		sr.drawing, sr.rendered = true, 0
+	case PenDrag:
+		if !sr.drawing {
+			return
+		}
+		i := len(sr.strokes) - 1
+		sr.strokes[i] = append(sr.strokes[i], sr.point(ev))
+		// Das Segment vor dem letzten Messwert ist nun vollstaendig
+		// bestimmt und kann gezeichnet werden.
+		sr.render(len(sr.strokes[i]) - 2)
+	case PenRelease:
+		if sr.drawing {
+			sr.finish()
+		}
+	}
+}
+
+// Liest die Events aus in und uebergibt sie an Feed, bis in geschlossen
+// wird.
+func (sr *StrokeRecorder) Run(in <-chan PenEvent) {
+	for ev := range in {
+		sr.Feed(ev)
+	}
+}
+
+// Liefert eine Kopie aller bisher gesammelten Striche.
+func (sr *StrokeRecorder) Strokes() []Stroke {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	res := make([]Stroke, len(sr.strokes))
+	for i, s := range sr.strokes {
+		res[i] = append(Stroke(nil), s...)
+	}
+	return res
+}
+
+// Verwirft alle Striche und beginnt eine neue Aufzeichnung. Die bereits
+// gezeichneten Striche werden nicht geloescht.
+func (sr *StrokeRecorder) Clear() {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.strokes, sr.start, sr.drawing = nil, time.Time{}, false
+}
+
+// Entfernt den letzten Strich (z.B. fuer eine Undo-Funktion) und liefert
+// false, falls keiner vorhanden ist.
+func (sr *StrokeRecorder) Undo() bool {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	if len(sr.strokes) == 0 {
+		return false
+	}
+	sr.strokes, sr.drawing = sr.strokes[:len(sr.strokes)-1], false
+	return true
+}
+
+func (sr *StrokeRecorder) point(ev PenEvent) StrokePoint {
+	return StrokePoint{X: ev.X, Y: ev.Y, Pressure: ev.Z,
+		Time: ev.Time.Sub(sr.start)}
+}
+
+// Schliesst den aktuellen Strich ab und zeichnet die restlichen Segmente.
+func (sr *StrokeRecorder) finish() {
+	sr.drawing = false
+	s := sr.strokes[len(sr.strokes)-1]
+	sr.render(len(s) - 1)
+}
+
+// Zeichnet die Segmente des aktuellen Striches bis (ohne) upTo auf die
+// Zeichenflaeche fuer das laufende Zeichnen.
+func (sr *StrokeRecorder) render(upTo int) {
+	if sr.canvas == nil {
+		sr.rendered = max(sr.rendered, upTo)
+		return
+	}
+	s := sr.strokes[len(sr.strokes)-1]
+	var dirty image.Rectangle
+	if len(s) == 1 && upTo == 0 {
+		dirty = drawStrokeDot(sr.canvas, s[0], sr.style)
+	}
+	for ; sr.rendered < upTo; sr.rendered++ {
+		dirty = dirty.Union(drawStrokeSegment(sr.canvas, s, sr.rendered,
+			sr.style))
+	}
+	if sr.flush != nil && !dirty.Empty() {
+		sr.flush(dirty)
+	}
+}
+
+// Zeichnet alle Striche in das Bild dst.
+func (sr *StrokeRecorder) Draw(dst draw.Image) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.draw(dst)
+}
+
+func (sr *StrokeRecorder) draw(dst draw.Image) {
+	for _, s := range sr.strokes {
+		if len(s) == 1 {
+			drawStrokeDot(dst, s[0], sr.style)
+		}
+		for i := 0; i < len(s)-1; i++ {
+			drawStrokeSegment(dst, s, i, sr.style)
+		}
+	}
+}
+
+// Zeichnet das geglaettete Segment zwischen s[i] und s[i+1], indem entlang
+// des Splines Kreisscheiben mit der Strichbreite des jeweiligen Druckes
+// gestempelt werden. Liefert den veraenderten Bereich.
+func drawStrokeSegment(dst draw.Image, s Stroke, i int,
+	st StrokeStyle) image.Rectangle {
+	pts := s.appendSegment(nil, i, max(st.Smoothing, 1))
+	pts = append(pts, s[i+1])
+	var r image.Rectangle
+	for j := 0; j < len(pts)-1; j++ {
+		a, b := pts[j], pts[j+1]
+		wa, wb := st.width(a.Pressure), st.width(b.Pressure)
+		// Der Abstand der Kreisscheiben betraegt hoechstens ein Viertel
+		// der Strichbreite, damit keine Luecken entstehen.
+		n := int(math.Ceil(math.Hypot(b.X-a.X, b.Y-a.Y) /
+			max(min(wa, wb)/4.0, 0.25)))
+		for k := 0; k <= n; k++ {
+			t := 0.0
+			if n > 0 {
+				t = float64(k) / float64(n)
+			}
+			r = r.Union(fillDisc(dst, a.X+t*(b.X-a.X), a.Y+t*(b.Y-a.Y),
+				(wa+t*(wb-wa))/2.0, st.Color))
+		}
+	}
+	return r
+}
+
+// Zeichnet einen Strich, welcher aus einem einzelnen Messwert besteht.
+func drawStrokeDot(dst draw.Image, p StrokePoint,
+	st StrokeStyle) image.Rectangle {
+	return fillDisc(dst, p.X, p.Y, st.width(p.Pressure)/2.0, st.Color)
+}
+
+// Fuellt die Kreisscheibe mit Mittelpunkt (cx,cy) und Radius r zeilenweise
+// mit der Farbe col.
+func fillDisc(dst draw.Image, cx, cy, r float64,
+	col color.Color) image.Rectangle {
+	type filler interface {
+		Fill(r image.Rectangle, c color.Color)
+	}
+	src := image.NewUniform(col)
+	var res image.Rectangle
+	for y := int(math.Floor(cy - r)); y <= int(math.Ceil(cy+r)); y++ {
+		dy := float64(y) + 0.5 - cy
+		if math.Abs(dy) > r {
+			continue
+		}
+		dx := math.Sqrt(r*r - dy*dy)
+		x0 := int(math.Round(cx - dx))
+		x1 := int(math.Round(cx + dx))
+		if x1 <= x0 {
+			x1 = x0 + 1
+		}
+		line := image.Rect(x0, y, x1, y+1).Intersect(dst.Bounds())
+		if line.Empty() {
+			continue
+		}
+		if f, ok := dst.(filler); ok {
+			f.Fill(line, col)
+		} else {
+			draw.Draw(dst, line, src, image.Point{}, draw.Src)
+		}
+		res = res.Union(line)
+	}
+	return res
+}
+
+// Schreibt die Striche als PNG-Bild in der Groesse der Zeichenflaeche nach
+// w.
+func (sr *StrokeRecorder) WritePNG(w io.Writer) error {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	img := image.NewRGBA(sr.bounds)
+	if sr.style.Background != nil {
+		draw.Draw(img, img.Rect, image.NewUniform(sr.style.Background),
+			image.Point{}, draw.Src)
+	}
+	sr.draw(img)
+	return png.Encode(w, img)
+}
+
+// Schreibt die Striche als SVG-Grafik nach w. Da die Breite eines Pfades
+// in SVG nicht variieren kann, wird jeder Strich in Pfade gleicher Breite
+// (auf 0.25 Pixel gerundet) zerlegt.
+func (sr *StrokeRecorder) WriteSVG(w io.Writer) error {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	b := sr.bounds
+	fmt.Fprintf(buf, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" "+
+		"width=\"%d\" height=\"%d\" viewBox=\"%d %d %d %d\">\n", b.Dx(),
+		b.Dy(), b.Min.X, b.Min.Y, b.Dx(), b.Dy())
+	if sr.style.Background != nil {
+		fmt.Fprintf(buf, "  <rect x=\"%d\" y=\"%d\" width=\"%d\" "+
+			"height=\"%d\" fill=\"%s\"/>\n", b.Min.X, b.Min.Y, b.Dx(),
+			b.Dy(), svgColor(sr.style.Background))
+	}
+	fmt.Fprintf(buf, "  <g fill=\"none\" stroke=\"%s\" "+
+		"stroke-linecap=\"round\" stroke-linejoin=\"round\">\n",
+		svgColor(sr.style.Color))
+	quant := func(p StrokePoint) float64 {
+		return math.Round(sr.style.width(p.Pressure)*4.0) / 4.0
+	}
+	for _, s := range sr.strokes {
+		pts := s.Smooth(sr.style.Smoothing)
+		if len(pts) == 1 {
+			pts = append(pts, pts[0])
+		}
+		for i := 0; i < len(pts)-1; {
+			width := quant(pts[i])
+			var d strings.Builder
+			fmt.Fprintf(&d, "M%.2f %.2f", pts[i].X, pts[i].Y)
+			for i++; i < len(pts); i++ {
+				fmt.Fprintf(&d, " L%.2f %.2f", pts[i].X, pts[i].Y)
+				if quant(pts[i]) != width {
+					break
+				}
+			}
+			fmt.Fprintf(buf, "    <path stroke-width=\"%g\" d=\"%s\"/>\n",
+				width, d.String())
+		}
+	}
+	fmt.Fprintf(buf, "  </g>\n</svg>\n")
+	return buf.Flush()
+}
+
+// Liefert die Farbe c im Format von SVG.
+func svgColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3f)", n.R, n.G, n.B,
+		float64(n.A)/255.0)
+}
+
+// Schreibt die (ungeglaetteten) Striche im JSON-Format nach w, z.B.
+//
+//	{"Width":480,"Height":320,"Start":"...","Strokes":[[{"X":10.5,
+//	"Y":20.25,"Pressure":0.4,"Time":0},...],...]}
+//
+// Time ist die Zeit seit Start in Nanosekunden. Mit LoadStrokes koennen
+// die Daten wieder eingelesen werden.
+func (sr *StrokeRecorder) WriteJSON(w io.Writer) error {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	data := strokeData{Width: sr.bounds.Dx(), Height: sr.bounds.Dy(),
+		Start: sr.start, Strokes: sr.strokes}
+	return json.NewEncoder(w).Encode(data)
+}
+
+// Schreibt die Striche in das File fileName. Das Format wird anhand der
+// Endung bestimmt: .svg, .png oder .json.
+func (sr *StrokeRecorder) WriteFile(fileName string) error {
+	var write func(w io.Writer) error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".svg":
+		write = sr.WriteSVG
+	case ".png":
+		write = sr.WritePNG
+	case ".json":
+		write = sr.WriteJSON
+	default:
+		return fmt.Errorf("%w: unknown file format '%s'", ErrStrokeData,
+			filepath.Ext(fileName))
+	}
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := write(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Liest Striche im JSON-Format (siehe WriteJSON) aus r und liefert einen
+// StrokeRecorder mit diesen Strichen und dem Aussehen style, womit sie
+// z.B. in ein anderes Format exportiert werden koennen.
+func LoadStrokes(r io.Reader, style StrokeStyle) (*StrokeRecorder, error) {
+	var data strokeData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStrokeData, err)
+	}
+	if data.Width <= 0 || data.Height <= 0 {
+		return nil, fmt.Errorf("%w: size %dx%d", ErrStrokeData, data.Width,
+			data.Height)
+	}
+	for i, s := range data.Strokes {
+		if len(s) == 0 {
+			return nil, fmt.Errorf("%w: stroke %d is empty", ErrStrokeData, i)
+		}
+	}
+	sr := NewStrokeRecorder(image.Rect(0, 0, data.Width, data.Height), style)
+	sr.start, sr.strokes = data.Start, data.Strokes
+	return sr, nil
+}
+
+// Wie LoadStrokes, die Daten werden jedoch aus dem File fileName gelesen.
+func LoadStrokesFile(fileName string, style StrokeStyle) (*StrokeRecorder,
+	error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return LoadStrokes(fh, style)
+}
@@ -0,0 +1,239 @@
+package adatft
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Zeichnet eine horizontale Linie von x=20 bis x=100 auf der Hoehe y, mit
+// zunehmendem Druck, und anschliessend einen Punkt.
+func feedStrokes(sr *StrokeRecorder, y float64) {
+	sr.Feed(penEventAt(PenPress, 20, y, 0.0, 0))
+	for i := 1; i <= 8; i++ {
+		sr.Feed(penEventAt(PenDrag, 20+float64(i)*10, y, float64(i)/8.0, i*10))
+	}
+	sr.Feed(penEventAt(PenRelease, 100, y, 1.0, 90))
+	sr.Feed(penEventAt(PenPress, 150, y, 0.5, 200))
+	sr.Feed(penEventAt(PenRelease, 150, y, 0.5, 220))
+}
+
+func countColumn(img image.Image, x int) int {
+	n := 0
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStrokeSmooth(t *testing.T) {
+	s := Stroke{{X: 0, Y: 0}, {X: 10, Y: 10, Time: 10}, {X: 20, Y: 0,
+		Time: 20}, {X: 30, Y: 10, Time: 30}}
+	sm := s.Smooth(4)
+	if len(sm) != 3*4+1 {
+		t.Fatalf("smoothed stroke has %d points, want %d", len(sm), 13)
+	}
+	for i, p := range s {
+		q := sm[i*4]
+		if math.Abs(q.X-p.X) > 1e-9 || math.Abs(q.Y-p.Y) > 1e-9 ||
+			q.Time != p.Time {
+			t.Errorf("point %d: got %v, want %v", i, q, p)
+		}
+	}
+	// Zwischen den Messwerten muss die Kurve glatt verlaufen, d.h. auch
+	// Punkte zwischen den Extremwerten enthalten.
+	if y := sm[2].Y; y <= 0 || y >= 10 {
+		t.Errorf("interpolated y = %g, want between 0 and 10", y)
+	}
+	if got := s.Smooth(1); len(got) != len(s) {
+		t.Errorf("Smooth(1) changed the stroke")
+	}
+	if d := s.Duration(); d != 30 {
+		t.Errorf("Duration() = %v, want 30ns", d)
+	}
+}
+
+func TestStrokeRecorderFeed(t *testing.T) {
+	sr := NewStrokeRecorder(image.Rect(0, 0, 200, 100), DefaultStrokeStyle)
+	sr.Feed(penEventAt(PenDrag, 10, 10, 0.5, 0))
+	feedStrokes(sr, 50)
+	strokes := sr.Strokes()
+	if len(strokes) != 2 {
+		t.Fatalf("got %d strokes, want 2", len(strokes))
+	}
+	if n := len(strokes[0]); n != 9 {
+		t.Errorf("first stroke has %d points, want 9", n)
+	}
+	if d := strokes[0].Duration(); d != 80*time.Millisecond {
+		t.Errorf("first stroke lasts %v, want 80ms", d)
+	}
+	if p := strokes[1][0]; p.Time != 200*time.Millisecond ||
+		p.Pressure != 0.5 {
+		t.Errorf("second stroke starts with %+v", p)
+	}
+
+	if !sr.Undo() || len(sr.Strokes()) != 1 {
+		t.Errorf("Undo didn't remove the last stroke")
+	}
+	sr.Clear()
+	if sr.Undo() {
+		t.Errorf("Undo after Clear returned true")
+	}
+}
+
+func TestStrokeLiveRendering(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	style := DefaultStrokeStyle
+	style.MinWidth, style.MaxWidth = 1.0, 9.0
+	sr := NewStrokeRecorder(bounds, style)
+	canvas := image.NewRGBA(bounds)
+	flushes := 0
+	var flushed image.Rectangle
+	sr.SetCanvas(canvas, func(rects ...image.Rectangle) error {
+		if len(rects) == 0 {
+			t.Errorf("flush called without a region")
+		}
+		for _, r := range rects {
+			flushed = flushed.Union(r)
+		}
+		flushes++
+		return nil
+	})
+
+	sr.Feed(penEventAt(PenPress, 20, 50, 0.0, 0))
+	sr.Feed(penEventAt(PenDrag, 30, 50, 0.1, 10))
+	if countColumn(canvas, 25) != 0 || flushes != 0 {
+		t.Errorf("segment drawn before its end point is known")
+	}
+	feedStrokes(sr, 50)
+	if flushes == 0 {
+		t.Errorf("flush never called")
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if canvas.RGBAAt(x, y).A != 0 &&
+				!image.Pt(x, y).In(flushed) {
+				t.Fatalf("pixel (%d,%d) drawn but not flushed", x, y)
+			}
+		}
+	}
+	thin, thick := countColumn(canvas, 30), countColumn(canvas, 95)
+	if thin == 0 || thick <= thin {
+		t.Errorf("stroke width %d (light) and %d (firm) pixel", thin, thick)
+	}
+	if countColumn(canvas, 150) == 0 {
+		t.Errorf("dot not drawn")
+	}
+
+	// Das nachtraegliche Zeichnen muss das gleiche Bild ergeben.
+	ref := image.NewRGBA(bounds)
+	sr.SetCanvas(nil, nil)
+	sr.Clear()
+	feedStrokes(sr, 50)
+	sr.Draw(ref)
+	live := image.NewRGBA(bounds)
+	sr.Clear()
+	sr.SetCanvas(live, nil)
+	feedStrokes(sr, 50)
+	if !bytes.Equal(ref.Pix, live.Pix) {
+		t.Errorf("live rendering differs from Draw")
+	}
+}
+
+func TestStrokeExport(t *testing.T) {
+	style := DefaultStrokeStyle
+	style.Background = color.White
+	sr := NewStrokeRecorder(image.Rect(0, 0, 200, 100), style)
+	feedStrokes(sr, 50)
+
+	var buf bytes.Buffer
+	if err := sr.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStrokes(&buf, style)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Bounds() != sr.Bounds() {
+		t.Errorf("bounds %v, want %v", loaded.Bounds(), sr.Bounds())
+	}
+	got, want := loaded.Strokes(), sr.Strokes()
+	if len(got) != len(want) || len(got[0]) != len(want[0]) ||
+		got[0][5] != want[0][5] {
+		t.Errorf("loaded strokes differ from original")
+	}
+
+	buf.Reset()
+	if err := sr.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != sr.Bounds() {
+		t.Errorf("PNG size %v", img.Bounds())
+	}
+	if r, _, _, _ := img.At(60, 50).RGBA(); r != 0 {
+		t.Errorf("stroke missing in PNG")
+	}
+	if r, _, _, _ := img.At(60, 10).RGBA(); r != 0xffff {
+		t.Errorf("background missing in PNG")
+	}
+
+	buf.Reset()
+	if err := sr.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	paths := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid SVG: %v", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "path" {
+			paths++
+		}
+	}
+	// Der Druck nimmt zu, daher muss der erste Strich in mehrere Pfade
+	// unterschiedlicher Breite zerlegt werden.
+	if paths < 3 {
+		t.Errorf("SVG contains %d paths", paths)
+	}
+	if !strings.Contains(svg, `fill="#ffffff"`) {
+		t.Errorf("SVG without background")
+	}
+}
+
+func TestLoadStrokesInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{`,
+		`{"Width":0,"Height":100}`,
+		`{"Width":100,"Height":100,"Strokes":[[]]}`,
+	} {
+		_, err := LoadStrokes(strings.NewReader(data), DefaultStrokeStyle)
+		if !errors.Is(err, ErrStrokeData) {
+			t.Errorf("%s: got %v, want ErrStrokeData", data, err)
+		}
+	}
+	sr := NewStrokeRecorder(image.Rect(0, 0, 10, 10), DefaultStrokeStyle)
+	if err := sr.WriteFile(t.TempDir() + "/strokes.txt"); !errors.Is(err,
+		ErrStrokeData) {
+		t.Errorf("unknown format: got %v", err)
+	}
+}
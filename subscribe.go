@@ -0,0 +1,300 @@
+package adatft
+
+import (
+	"image"
+	"slices"
+	"sync"
+)
+
+// Mit einer Subscription erhaelt ein Teil der Applikation die Events eines
+// bestimmten Bereiches des Bildschirms (siehe Touch.Subscribe). Ein PenPress
+// wird dem obersten Abonnenten zugestellt, dessen Bereich die Position
+// enthaelt. Dieser erhaelt anschliessend alle Events bis und mit dem
+// PenRelease, auch wenn die Position seinen Bereich verlaesst (Capture).
+// Events, welche keinem Abonnenten zugestellt werden, landen wie bisher in
+// EventQ.
+type Subscription struct {
+	tch     *Touch
+	region  image.Rectangle
+	z, seq  int
+	handler PenEventHandlerType
+	active  bool
+}
+
+// Ein Event mit dem Abonnenten, welchem es zugestellt wird.
+type routedEvent struct {
+	sub *Subscription
+	ev  PenEvent
+}
+
+// Verwaltet die Abonnenten eines Touchscreens. Die Handler werden in einer
+// eigenen Go-Routine und in der Reihenfolge der Events aufgerufen, damit
+// der Interrupt-Handler nicht blockiert wird. Die Queue zu den Handlern ist
+// gleich gross wie EventQ; ist sie voll, gelten die gleichen Regeln wie
+// fuer EventQ (siehe QueueStats). Insbesondere wartet der Touchscreen auf
+// die Handler, falls sich die Queue nicht genuegend verdichten laesst.
+type eventRouter struct {
+	// Serialisiert das Schreiben in queue (und das Schliessen); wird
+	// immer vor mutex gesperrt.
+	sendMutex sync.Mutex
+	mutex     sync.Mutex
+	subs      []*Subscription
+	observers []*Subscription
+	seq       int
+	capture   *Subscription
+	queue     chan routedEvent
+	// Wird beim Schliessen geschlossen, damit ein wartendes push (siehe
+	// oben) nicht mehr auf die Handler wartet.
+	done      chan struct{}
+	suspended int
+	stats     QueueStats
+}
+
+// Abonniert die Events im Bereich region (in Bildschirmkoordinaten). Die
+// Funktion handler wird fuer jedes Event aufgerufen; sie sollte rasch
+// zurueckkehren, da alle Handler in der gleichen Go-Routine laufen. Bei
+// ueberlappenden Bereichen liegt der zuletzt erstellte Abonnent oben.
+func (tch *Touch) Subscribe(region image.Rectangle,
+	handler PenEventHandlerType) *Subscription {
+	return tch.SubscribeZ(region, 0, handler)
+}
+
+// Wie Subscribe, jedoch mit der Ebene z. Abonnenten mit hoeherem z liegen
+// ueber denen mit tieferem z.
+func (tch *Touch) SubscribeZ(region image.Rectangle, z int,
+	handler PenEventHandlerType) *Subscription {
+	r := &tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.start(cap(tch.EventQ))
+	r.seq++
+	s := &Subscription{tch: tch, region: region, z: z, seq: r.seq,
+		handler: handler, active: true}
+	r.subs = append(r.subs, s)
+	r.sort()
+	return s
+}
+
+// Wie Subscribe, der Handler erhaelt jedoch alle Events, ohne dass diese
+// den Abonnenten oder EventQ entzogen werden (siehe Unistrokes). Bereich
+// und Ebene des Beobachters sind bedeutungslos.
+func (tch *Touch) observe(handler PenEventHandlerType) *Subscription {
+	r := &tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.start(cap(tch.EventQ))
+	s := &Subscription{tch: tch, handler: handler, active: true}
+	r.observers = append(r.observers, s)
+	return s
+}
+
+// Erstellt beim ersten Abonnenten die Queue der Groesse size (wie EventQ)
+// und startet die Zustellung (r.mutex muss gesperrt sein).
+func (r *eventRouter) start(size int) {
+	if r.queue != nil {
+		return
+	}
+	if size == 0 {
+		size = eventQueueSize
+	}
+	r.queue = make(chan routedEvent, size)
+	r.done = make(chan struct{})
+	go r.deliver(r.queue)
+}
+
+// Beendet das Abonnement. Laeuft gerade eine Beruehrung, welche an diesen
+// Abonnenten gebunden ist, werden ihre restlichen Events verworfen.
+func (s *Subscription) Unsubscribe() {
+	r := &s.tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s.active = false
+	isSub := func(sub *Subscription) bool {
+		return sub == s
+	}
+	r.subs = slices.DeleteFunc(r.subs, isSub)
+	r.observers = slices.DeleteFunc(r.observers, isSub)
+}
+
+// Liefert den Bereich des Abonnenten.
+func (s *Subscription) Region() image.Rectangle {
+	r := &s.tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return s.region
+}
+
+// Aendert den Bereich des Abonnenten. Eine laufende Beruehrung bleibt an
+// ihn gebunden.
+func (s *Subscription) SetRegion(region image.Rectangle) {
+	r := &s.tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s.region = region
+}
+
+// Aendert die Ebene des Abonnenten (siehe SubscribeZ).
+func (s *Subscription) SetZ(z int) {
+	r := &s.tch.router
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s.z = z
+	r.sort()
+}
+
+// Sortiert die Abonnenten von oben nach unten.
+func (r *eventRouter) sort() {
+	slices.SortFunc(r.subs, func(a, b *Subscription) int {
+		if a.z != b.z {
+			return b.z - a.z
+		}
+		return b.seq - a.seq
+	})
+}
+
+// Stellt das Event ev allen Beobachtern (siehe observe) und dem
+// zustaendigen Abonnenten zu. Liefert false, falls kein Abonnent
+// zustaendig ist. Laesst sich die volle Queue nicht verdichten, wartet
+// route auf die Handler; SetRegion, Unsubscribe etc. werden dadurch nicht
+// blockiert.
+func (r *eventRouter) route(ev PenEvent) bool {
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+
+	r.mutex.Lock()
+	sub := r.target(ev)
+	observers := slices.Clone(r.observers)
+	if r.suspended > 0 {
+		observers = nil
+	}
+	queue, done := r.queue, r.done
+	r.mutex.Unlock()
+	if done == nil {
+		return false
+	}
+	for _, o := range observers {
+		r.push(queue, done, routedEvent{o, ev})
+	}
+	if sub == nil {
+		return false
+	}
+	r.push(queue, done, routedEvent{sub, ev})
+	return true
+}
+
+// Unterbricht die Zustellung an die Abonnenten und Beobachter, bis
+// restore aufgerufen wird. In dieser Zeit landen alle Events in EventQ (z.B. waehrend der
+// Kalibrierung, siehe Calibrate). Eine laufende Beruehrung wird dabei von
+// ihrem Abonnenten geloest.
+func (r *eventRouter) suspend() (restore func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.suspended++
+	r.capture = nil
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.suspended--
+		r.capture = nil
+	}
+}
+
+// Ermittelt den Abonnenten fuer das Event ev (r.mutex muss gesperrt sein).
+func (r *eventRouter) target(ev PenEvent) *Subscription {
+	if r.suspended > 0 {
+		return nil
+	}
+	var sub *Subscription
+	switch ev.Type {
+	case PenPress:
+		r.capture = nil
+		pt := image.Pt(int(ev.X), int(ev.Y))
+		for _, s := range r.subs {
+			if pt.In(s.region) {
+				r.capture = s
+				break
+			}
+		}
+		sub = r.capture
+	case PenDrag:
+		sub = r.capture
+	case PenRelease:
+		sub, r.capture = r.capture, nil
+	}
+	return sub
+}
+
+// Stellt re in die Queue zu den Handlern. Ist sie voll, wird ihr Inhalt
+// wie bei Touch.pushEvent verdichtet. Da die Queue nicht mit mutex
+// geschuetzt ist, kann deliver dabei weiterlesen (r.sendMutex muss
+// gesperrt sein). Nach dem Schliessen von done wird nicht mehr gewartet.
+func (r *eventRouter) push(queue chan routedEvent, done <-chan struct{},
+	re routedEvent) {
+	select {
+	case queue <- re:
+		return
+	default:
+	}
+
+	var res []routedEvent
+drain:
+	for {
+		select {
+		case e := <-queue:
+			res = append(res, e)
+		default:
+			break drain
+		}
+	}
+	var stats QueueStats
+	res = compactEvents(append(res, re), cap(queue), &stats)
+	r.mutex.Lock()
+	r.stats.add(stats)
+	r.mutex.Unlock()
+	for _, e := range res {
+		select {
+		case queue <- e:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Ruft die Handler fuer die Events aus queue auf, bis diese geschlossen
+// wird.
+func (r *eventRouter) deliver(queue <-chan routedEvent) {
+	for re := range queue {
+		r.mutex.Lock()
+		active := re.sub.active
+		r.mutex.Unlock()
+		if active {
+			re.sub.handler(re.ev)
+		}
+	}
+}
+
+// Beendet die Zustellung an alle Abonnenten. Ein Handler darf dabei
+// auch Touch.Close aufrufen, waehrend route auf ihn wartet.
+func (r *eventRouter) close() {
+	r.mutex.Lock()
+	for _, s := range slices.Concat(r.subs, r.observers) {
+		s.active = false
+	}
+	r.subs, r.observers, r.capture = nil, nil, nil
+	if r.done != nil {
+		close(r.done)
+		r.done = nil
+	}
+	r.mutex.Unlock()
+
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.queue != nil {
+		close(r.queue)
+		r.queue = nil
+	}
+}
@@ -0,0 +1,244 @@
+package adatft
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+// Liefert einen Touchscreen ohne Hardware mit einer Queue der Groesse
+// queueSize, der Default-Konfiguration und ohne Kalibrierung.
+func newTestTouch(queueSize int) *Touch {
+	tch := &Touch{tspi: newRegRecorder(),
+		EventQ: make(chan PenEvent, queueSize),
+		device: DeviceID{Board: "test", Controller: "STMPE610-0811-03"}}
+	tch.config = DefaultTouchConfig
+	tch.config.QueueSize = queueSize
+	tch.plane.setDefault(Rotate000)
+	tch.plane.Device = tch.device
+	return tch
+}
+
+type subEvent struct {
+	name string
+	ev   PenEvent
+}
+
+func subscribeTest(tch *Touch, name string, r image.Rectangle, z int,
+	out chan<- subEvent) *Subscription {
+	return tch.SubscribeZ(r, z, func(ev PenEvent) {
+		out <- subEvent{name, ev}
+	})
+}
+
+func expectSubEvent(t *testing.T, out <-chan subEvent, name string,
+	typ PenEventType) {
+	t.Helper()
+	select {
+	case se := <-out:
+		if se.name != name || se.ev.Type != typ {
+			t.Errorf("got %v to %s, want %v to %s", se.ev.Type, se.name,
+				typ, name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event delivered, want %v to %s", typ, name)
+	}
+}
+
+var testT0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Liefert ein Event an der Position (x, y) mit dem Druck z, welches ms
+// Millisekunden nach testT0 eintrifft.
+func penEventAt(typ PenEventType, x, y, z float64, ms int) PenEvent {
+	return PenEvent{Type: typ, TouchPos: TouchPos{X: x, Y: y, Z: z},
+		Time: testT0.Add(time.Duration(ms) * time.Millisecond)}
+}
+
+func TestSubscribeZOrder(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	defer tch.Close()
+	out := make(chan subEvent, 10)
+
+	subscribeTest(tch, "back", image.Rect(0, 0, 240, 320), 0, out)
+	top := subscribeTest(tch, "top", image.Rect(0, 0, 100, 100), 1, out)
+	subscribeTest(tch, "front", image.Rect(50, 50, 150, 150), 0, out)
+
+	testList := []struct {
+		x, y float64
+		name string
+	}{
+		{10, 10, "top"},
+		{75, 75, "top"},
+		{120, 120, "front"},
+		{200, 300, "back"},
+	}
+	for _, test := range testList {
+		tch.enqueueEvent(penEventAt(PenPress, test.x, test.y, 0, 0))
+		tch.enqueueEvent(penEventAt(PenRelease, test.x, test.y, 0, 0))
+		expectSubEvent(t, out, test.name, PenPress)
+		expectSubEvent(t, out, test.name, PenRelease)
+	}
+
+	top.SetZ(-1)
+	tch.enqueueEvent(penEventAt(PenPress, 75, 75, 0, 0))
+	expectSubEvent(t, out, "front", PenPress)
+	tch.enqueueEvent(penEventAt(PenRelease, 75, 75, 0, 0))
+	expectSubEvent(t, out, "front", PenRelease)
+	if len(tch.EventQ) != 0 {
+		t.Errorf("%d events in EventQ", len(tch.EventQ))
+	}
+}
+
+func TestSubscribeCapture(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	defer tch.Close()
+	out := make(chan subEvent, 10)
+
+	left := subscribeTest(tch, "left", image.Rect(0, 0, 120, 320), 0, out)
+	subscribeTest(tch, "right", image.Rect(120, 0, 240, 320), 0, out)
+
+	tch.enqueueEvent(penEventAt(PenPress, 50, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenDrag, 150, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenDrag, 300, 400, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 300, 400, 0, 0))
+	expectSubEvent(t, out, "left", PenPress)
+	expectSubEvent(t, out, "left", PenDrag)
+	expectSubEvent(t, out, "left", PenDrag)
+	expectSubEvent(t, out, "left", PenRelease)
+
+	// Events ausserhalb aller Bereiche landen in EventQ.
+	tch.enqueueEvent(penEventAt(PenPress, 250, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenDrag, 50, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 50, 100, 0, 0))
+	if len(tch.EventQ) != 3 {
+		t.Fatalf("got %d events in EventQ, want 3", len(tch.EventQ))
+	}
+	for len(tch.EventQ) > 0 {
+		<-tch.EventQ
+	}
+
+	// Nach Unsubscribe werden die restlichen Events der Beruehrung
+	// verworfen.
+	tch.enqueueEvent(penEventAt(PenPress, 50, 100, 0, 0))
+	expectSubEvent(t, out, "left", PenPress)
+	left.Unsubscribe()
+	tch.enqueueEvent(penEventAt(PenDrag, 60, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 60, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenPress, 50, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 50, 100, 0, 0))
+	if len(tch.EventQ) != 2 {
+		t.Errorf("got %d events in EventQ, want 2", len(tch.EventQ))
+	}
+	select {
+	case se := <-out:
+		t.Errorf("unexpected %v to %s", se.ev.Type, se.name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Kommt ein Abonnent nicht nach, werden seine PenDrag-Events verdichtet;
+// PenPress und PenRelease muessen aber immer ankommen.
+func TestSubscribeQueueFull(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	defer tch.Close()
+	block := make(chan bool)
+	out := make(chan PenEvent, 2*eventQueueSize)
+	tch.Subscribe(image.Rect(0, 0, 240, 320), func(ev PenEvent) {
+		<-block
+		out <- ev
+	})
+
+	n := 3 * eventQueueSize
+	for touch := 0; touch < 2; touch++ {
+		tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+		for i := 1; i <= n; i++ {
+			tch.enqueueEvent(penEventAt(PenDrag, float64(i), 0, 0, 0))
+		}
+		tch.enqueueEvent(penEventAt(PenRelease, float64(n), 0, 0, 0))
+	}
+	close(block)
+
+	var types []PenEventType
+	for len(types) < 4 {
+		select {
+		case ev := <-out:
+			if ev.Type != PenDrag {
+				types = append(types, ev.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, want two complete touches", types)
+		}
+	}
+	want := []PenEventType{PenPress, PenRelease, PenPress, PenRelease}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("got %v, want %v", types, want)
+		}
+	}
+	if stats := tch.QueueStats(); stats.Coalesced == 0 {
+		t.Errorf("got %+v, want coalesced drags", stats)
+	}
+}
+
+// Die Queue zu den Handlern ist gleich gross wie EventQ.
+func TestSubscribeQueueSize(t *testing.T) {
+	tch := newTestTouch(4)
+	defer tch.Close()
+	tch.Subscribe(image.Rect(0, 0, 240, 320), func(ev PenEvent) {})
+	if n := cap(tch.router.queue); n != 4 {
+		t.Errorf("got queue size %d, want 4", n)
+	}
+}
+
+// Waehrend die Zustellung unterbrochen ist (z.B. bei der Kalibrierung),
+// landen alle Events in EventQ.
+func TestSubscribeSuspend(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	defer tch.Close()
+	out := make(chan subEvent, 10)
+	subscribeTest(tch, "all", image.Rect(0, 0, 240, 320), 0, out)
+
+	restore := tch.router.suspend()
+	tch.enqueueEvent(penEventAt(PenPress, 50, 100, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 50, 100, 0, 0))
+	if len(tch.EventQ) != 2 {
+		t.Errorf("got %d events in EventQ, want 2", len(tch.EventQ))
+	}
+	restore()
+	tch.enqueueEvent(penEventAt(PenPress, 50, 100, 0, 0))
+	expectSubEvent(t, out, "all", PenPress)
+}
+
+// Ein Handler darf Close aufrufen, auch wenn der Touchscreen gerade darauf
+// wartet, dass die Handler wieder Events lesen.
+func TestSubscribeCloseFromHandler(t *testing.T) {
+	tch := newTestTouch(2)
+	got, closing := make(chan PenEventType), make(chan bool)
+	tch.Subscribe(image.Rect(0, 0, 240, 320), func(ev PenEvent) {
+		got <- ev.Type
+		if ev.Type == PenRelease {
+			<-closing
+			tch.Close()
+		}
+	})
+	tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+	<-got
+	tch.enqueueEvent(penEventAt(PenRelease, 0, 0, 0, 0))
+	<-got
+
+	// Die Queue ist nun voll und laesst sich nicht verdichten.
+	tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+	tch.enqueueEvent(penEventAt(PenRelease, 0, 0, 0, 0))
+	sent := make(chan bool)
+	go func() {
+		tch.enqueueEvent(penEventAt(PenPress, 0, 0, 0, 0))
+		close(sent)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(closing)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("Close blocked by a full subscriber queue")
+	}
+}
@@ -1,8 +1,12 @@
 package adatft
 
 import (
+	"errors"
 	"fmt"
+	"image"
 	"log"
+	"os"
+	"sync"
 	"time"
 
 	hw "github.com/stefan-muehlebach/adatft/stmpe610"
@@ -46,6 +50,26 @@ func (pet PenEventType) String() string {
 	return "(unknown event)"
 }
 
+func (pet *PenEventType) Set(s string) error {
+	for typ := PenPress; typ <= PenRelease; typ++ {
+		if typ.String() == s {
+			*pet = typ
+			return nil
+		}
+	}
+	return errors.New("Unknown pen event: " + s)
+}
+
+// In Aufzeichnungen (siehe StartRecording) werden die Events mit ihrem
+// Namen abgelegt.
+func (pet PenEventType) MarshalText() ([]byte, error) {
+	return []byte(pet.String()), nil
+}
+
+func (pet *PenEventType) UnmarshalText(text []byte) error {
+	return pet.Set(string(text))
+}
+
 // Dieser Typ enthält die rohen, unkalibrierten Display-Daten
 type TouchRawPos struct {
 	RawX, RawY uint16
@@ -77,7 +101,7 @@ func (p1 TouchPos) Near(p2 TouchPos) bool {
 
 // Dies ist der Funktionstyp für den PenEvent-Handler - also jene Funktion,
 // welche beim Eintreffen eines Interrupts vom STMPE610 aufgerufen werden
-// soll.
+// soll (siehe Touch.Subscribe).
 type PenEventHandlerType func(event PenEvent)
 
 type PenEventChannelType chan PenEvent
@@ -86,18 +110,58 @@ type PenEventChannelType chan PenEvent
 type Touch struct {
 	tspi   TouchInterface
 	EventQ PenEventChannelType
-	plane  DistortedPlane
+	device DeviceID
 	isOpen bool
+	// Wird von Close geschlossen; damit werden laufende Wiedergaben (siehe
+	// Replay) beendet.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Die Konfiguration wird vom Dispatcher gelesen und kann im laufenden
+	// Betrieb geaendert werden (siehe SetConfig).
+	configMutex sync.RWMutex
+	config      TouchConfig
+
+	planeMutex sync.RWMutex
+	plane      DistortedPlane
+	watchStop  chan struct{}
+
+	filterMutex sync.Mutex
+	filters     []TouchFilter
+	minPressure float64
+
+	router eventRouter
+
+	queueMutex sync.Mutex
+	queueStats QueueStats
+
+	raw rawStream
+
+	recordMutex sync.Mutex
+	recorder    *touchRecorder
+
+	gpioMutex   sync.Mutex
+	gpioHandler GPIOHandlerType
 }
 
 // Funktionen
 func OpenTouch(rot RotationType) *Touch {
+	return OpenTouchConfig(rot, DefaultTouchConfig)
+}
+
+// Wie OpenTouch, der Touch-Controller wird jedoch mit der Konfiguration
+// tc initialisiert (siehe TouchConfig).
+func OpenTouchConfig(rot RotationType, tc TouchConfig) *Touch {
 	var tch *Touch
 	var devId uint16
 	var revNr uint8
-	var zFract byte = hw.TSC_FRACT_Z_3_5
 
-	tch = &Touch{}
+	if err := tc.Validate(); err != nil {
+		log.Fatalf("OpenTouchConfig(): %v", err)
+	}
+	hwConfig, _ := tc.hwConfig()
+
+	tch = &Touch{config: tc, done: make(chan struct{})}
 	if isRaspberry {
 		tch.tspi = hw.Open(tchSpeedHz)
 	} else {
@@ -110,47 +174,204 @@ func OpenTouch(rot RotationType) *Touch {
 		log.Fatalf("Wrong ID; got (0x%04x, 0x%02x) want (0x0811, 0x03)\n",
 			devId, revNr)
 	}
+	tch.device = DeviceID{
+		Board:      boardID(),
+		Controller: fmt.Sprintf("STMPE610-%04x-%02x", devId, revNr),
+	}
 
 	// Initialisiere die Queue für applikatorische Events und setze den
 	// Interrupt-Handler für Touch-Events.
-	tch.EventQ = make(chan PenEvent, eventQueueSize)
+	tch.EventQ = make(chan PenEvent, tc.QueueSize)
 	ev.Type = PenRelease
 	tch.tspi.SetCallback(eventDispatcher, tch)
 
-	tch.tspi.Init([]any{zFract})
+	tch.tspi.Init([]any{hwConfig})
 	tch.isOpen = true
 
-	tch.plane.ReadConfig(rot)
-	tch.plane.SetZRange(0, (0b100 << zFract)-1, 1.0, 0.0)
+	// Ohne Kalibrierungsdaten (z.B. bei einem neuen Geraet) wird eine
+	// Default-Abbildung verwendet, damit die Kalibrierung ueberhaupt
+	// durchgefuehrt werden kann (siehe Calibrate).
+	var plane DistortedPlane
+	fileName := tch.calibFileName()
+	if _, err := os.Stat(fileName); err == nil {
+		plane.ReadConfigFile(fileName, rot)
+		if plane.Device != (DeviceID{}) && plane.Device != tch.device {
+			log.Printf("Calibration data in %s is for device %v, not %v",
+				fileName, plane.Device, tch.device)
+		}
+	} else {
+		log.Printf("No calibration data found (%v); run the calibration!",
+			err)
+		plane.setDefault(rot)
+	}
+	plane.Device = tch.device
+	tch.setPlane(plane)
+	tch.applyConfig(tc)
 
 	return tch
 }
 
+// Liefert die Identitaet dieses Touchscreens (siehe DeviceID).
+func (tch *Touch) DeviceID() DeviceID {
+	return tch.device
+}
+
+// Liefert den Bildschirm, auf welchen die Positionen des Touchscreens
+// abgebildet werden.
+func (tch *Touch) Bounds() image.Rectangle {
+	tch.planeMutex.RLock()
+	defer tch.planeMutex.RUnlock()
+	return tch.plane.Bounds()
+}
+
+// Liefert eine Kopie der aktuell verwendeten Kalibrierung.
+func (tch *Touch) Plane() DistortedPlane {
+	tch.planeMutex.RLock()
+	defer tch.planeMutex.RUnlock()
+	return tch.plane
+}
+
+// Ersetzt die Kalibrierung in einem Schritt, auch waehrend Events
+// verarbeitet werden.
+func (tch *Touch) setPlane(plane DistortedPlane) {
+	tch.planeMutex.Lock()
+	defer tch.planeMutex.Unlock()
+	tch.plane = plane
+}
+
+// Bildet die Rohdaten rawPos mit der aktuellen Kalibrierung ab.
+func (tch *Touch) transform(rawPos TouchRawPos) (TouchPos, error) {
+	tch.planeMutex.RLock()
+	defer tch.planeMutex.RUnlock()
+	return tch.plane.Transform(rawPos)
+}
+
+// Setzt die Kette der Filter, welche alle Messwerte durchlaufen, bevor sie
+// als Events in die Queue gestellt werden (siehe TouchFilter). Ohne
+// Argumente werden alle Filter entfernt.
+func (tch *Touch) SetFilters(filters ...TouchFilter) {
+	tch.filterMutex.Lock()
+	defer tch.filterMutex.Unlock()
+	for _, f := range filters {
+		f.Reset()
+	}
+	tch.filters = filters
+}
+
+// Setzt die Filterkette anhand einer textuellen Beschreibung (siehe
+// ParseTouchFilters).
+func (tch *Touch) SetFilterSpec(spec string) error {
+	filters, err := ParseTouchFilters(spec, tch.Bounds())
+	if err != nil {
+		return err
+	}
+	tch.SetFilters(filters...)
+	return nil
+}
+
+// Laesst das Event ev durch die Filterkette laufen. Liefert false, falls
+// es zu wenig Druck aufweist (siehe TouchConfig.MinPressure) oder von
+// einem der Filter verworfen wurde.
+func (tch *Touch) filter(ev *PenEvent) bool {
+	tch.filterMutex.Lock()
+	defer tch.filterMutex.Unlock()
+	if ev.Z < tch.minPressure {
+		return false
+	}
+	for _, f := range tch.filters {
+		if !f.Filter(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+// Setzt den minimalen Druck und liefert den bisherigen Wert.
+func (tch *Touch) setMinPressure(p float64) float64 {
+	tch.filterMutex.Lock()
+	defer tch.filterMutex.Unlock()
+	old := tch.minPressure
+	tch.minPressure = p
+	return old
+}
+
+// Schaltet den minimalen Druck und die Filterkette aus und liefert eine
+// Funktion, mit welcher beides wiederhergestellt wird. Wird bei den
+// Kalibrierungen verwendet, welche die unveraenderten Messwerte benoetigen.
+func (tch *Touch) suspendFilters() (restore func()) {
+	tch.filterMutex.Lock()
+	defer tch.filterMutex.Unlock()
+	filters, minPressure := tch.filters, tch.minPressure
+	tch.filters, tch.minPressure = nil, 0.0
+	return func() {
+		tch.filterMutex.Lock()
+		defer tch.filterMutex.Unlock()
+		for _, f := range filters {
+			f.Reset()
+		}
+		tch.filters, tch.minPressure = filters, minPressure
+	}
+}
+
+func (tch *Touch) resetFilters() {
+	tch.filterMutex.Lock()
+	defer tch.filterMutex.Unlock()
+	for _, f := range tch.filters {
+		f.Reset()
+	}
+}
+
+// Schliesst den Touchscreen. Weitere Aufrufe haben keine Wirkung.
 func (tch *Touch) Close() {
-	tch.isOpen = false
-	close(tch.EventQ)
-	tch.tspi.Close()
+	tch.closeOnce.Do(func() {
+		tch.isOpen = false
+		if tch.done != nil {
+			close(tch.done)
+		}
+		tch.WatchCalibration(0)
+		tch.CloseRawStream()
+		if err := tch.StopRecording(); err != nil {
+			log.Printf("Couldn't finish recording: %v", err)
+		}
+		tch.router.close()
+		tch.queueMutex.Lock()
+		close(tch.EventQ)
+		tch.queueMutex.Unlock()
+		tch.tspi.Close()
+	})
 }
 
 // Mit dieser Funktion wird ein neues Pen-Event in die zentrale Event-Queue
 // gestellt (welche dann von der Applikation ausgelesen werden muss).
 // Diese Operation darf nicht blockierend ausgeführt werden, andernfalls
 // würde der Event-Handler blockiert - was in meinen Augen gravierender ist.
+// Events im Bereich eines Abonnenten (siehe Subscribe) werden diesem
+// zugestellt und nicht in die Queue gestellt. Ist die Queue voll, werden
+// Drag-Events zusammengefasst (siehe QueueStats).
 //
 // Mit dem auskommentierten Code kann für Testzwecke dafür gesorgt werden,
 // dass bei einem Fehler ein Runtime-Panic ausgelöst wird.
 func (tch *Touch) enqueueEvent(ev PenEvent) {
 	ev.Time = time.Now()
+	if ev.trace != nil {
+		ev.trace.Enqueue = ev.Time
+	}
+	tch.record(ev)
+	tch.dispatchEvent(ev)
+}
+
+// Stellt das Event ev einem Abonnenten zu oder in die Queue, ohne es
+// aufzuzeichnen oder seinen Zeitstempel zu aendern (siehe Replay).
+func (tch *Touch) dispatchEvent(ev PenEvent) {
+	if tch.router.route(ev) {
+		return
+	}
 	defer func() {
 		if x := recover(); x != nil {
 			log.Printf("Runtime panic: %v\n", x)
 		}
 	}()
-	select {
-	case tch.EventQ <- ev:
-	default:
-		log.Printf("Sending not possible: event queue full!\n")
-	}
+	tch.pushEvent(ev)
 }
 
 // Diese Funktion wird von 'aussen' aufgerufen und gibt das nächste Pen-Event
@@ -159,23 +380,30 @@ func (tch *Touch) WaitForEvent() PenEvent {
 	return <-tch.EventQ
 }
 
-func (t *Touch) readRawPos() (td TouchRawPos) {
-	td.RawX, td.RawY, td.RawZ = t.ReadData()
-	return
+// Liest alle Messwerte aus der FIFO und setzt diese anschliessend zurueck.
+func (t *Touch) readSamples() []TouchRawPos {
+	cnt := t.BufferLen()
+	samples := make([]TouchRawPos, cnt)
+	for i := range samples {
+		s := &samples[i]
+		s.RawX, s.RawY, s.RawZ = t.tspi.ReadData()
+	}
+	t.tspi.WriteReg8(hw.FIFO_STA, hw.FIFO_STA_RESET)
+	t.tspi.WriteReg8(hw.FIFO_STA, 0)
+	return samples
 }
 
 func (t *Touch) BufferLen() uint8 {
 	return t.tspi.ReadReg8(hw.FIFO_SIZE)
 }
 
+// Leert die FIFO und liefert den letzten Messwert. Alle Messwerte koennen
+// ueber OpenRawStream bezogen werden.
 func (t *Touch) ReadData() (x, y uint16, z uint8) {
-	cnt := t.BufferLen()
-	for cnt > 0 {
-		x, y, z = t.tspi.ReadData()
-		cnt--
+	if samples := t.readSamples(); len(samples) > 0 {
+		s := samples[len(samples)-1]
+		x, y, z = s.RawX, s.RawY, s.RawZ
 	}
-	t.tspi.WriteReg8(hw.FIFO_STA, hw.FIFO_STA_RESET)
-	t.tspi.WriteReg8(hw.FIFO_STA, 0)
 	return
 }
 
@@ -195,6 +423,8 @@ type PenEvent struct {
 	TouchPos
 	Time     time.Time
 	FifoSize uint8
+	// Zeitpunkte fuer die Latenzmessung (siehe EnableLatency).
+	trace *LatencyTrace
 }
 
 // Diese Funktion ist der Callback-Handler, welcher beim Eintreten eines
@@ -207,6 +437,9 @@ func eventDispatcher(arg any) {
 	t = arg.(*Touch)
 
 	intEnable := t.tspi.ReadReg8(hw.INT_EN)
+	// Fuer die Latenzmessung gilt beim ersten Durchlauf die Flanke am
+	// Interrupt-Pin, bei weiteren der Beginn des Durchlaufs.
+	edge := t.edgeTime()
 	// log.Printf("ISR called\n")
 	for {
 		time.Sleep(sampleTime) // NEU!!! ACHTUNG!!!
@@ -220,14 +453,30 @@ func eventDispatcher(arg any) {
 			// log.Printf("    INT_FIFO_TH\n")
 			for t.tspi.ReadReg8(hw.FIFO_SIZE) > 0 {
 				// log.Printf("      FIFO_SIZE > 0\n")
+				sample := PenEvent{Type: PenDrag}
 				if ev.Type == PenRelease {
-					ev.Type = PenPress
-				} else {
-					ev.Type = PenDrag
+					sample.Type = PenPress
+				}
+				samples := t.readSamples()
+				if len(samples) == 0 {
+					break
+				}
+				sample.trace = newLatencyTrace(edge, time.Now())
+				overflow := (intStatus & hw.INT_FIFO_OFLOW) != 0
+				if overflow {
+					t.tspi.WriteReg8(hw.INT_STA, hw.INT_FIFO_OFLOW)
+					intStatus &^= hw.INT_FIFO_OFLOW
+				}
+				t.streamSamples(samples, overflow)
+				sample.TouchRawPos = samples[len(samples)-1]
+				sample.FifoSize = uint8(len(samples))
+				sample.TouchPos, _ = t.transform(sample.TouchRawPos)
+				// Verwirft ein Filter den ersten Messwert, wird der
+				// naechste akzeptierte zum PenPress.
+				if t.filter(&sample) {
+					ev = sample
+					t.enqueueEvent(ev)
 				}
-				ev.TouchRawPos = t.readRawPos()
-				ev.TouchPos, _ = t.plane.Transform(ev.TouchRawPos)
-				t.enqueueEvent(ev)
 			}
 			t.tspi.WriteReg8(hw.INT_STA, hw.INT_FIFO_TH)
 		}
@@ -238,11 +487,28 @@ func eventDispatcher(arg any) {
 				// log.Printf("      Pen down\n")
 			} else {
 				// log.Printf("      Pen up\n")
-				ev.Type = PenRelease
-				t.enqueueEvent(ev)
+				if ev.Type != PenRelease {
+					ev.Type = PenRelease
+					ev.trace = newLatencyTrace(edge, time.Now())
+					t.enqueueEvent(ev)
+				}
+				t.resetFilters()
 			}
 			t.tspi.WriteReg8(hw.INT_STA, hw.INT_TOUCH_DET)
 		}
+
+		if (intStatus & hw.INT_FIFO_OFLOW) != 0 {
+			// Ueberlauf ohne neue Messwerte: wird beim naechsten Messwert
+			// gemeldet.
+			t.tspi.WriteReg8(hw.INT_STA, hw.INT_FIFO_OFLOW)
+			t.streamSamples(nil, true)
+		}
+
+		if (intStatus & hw.INT_GPIO) != 0 {
+			t.dispatchGPIO()
+			t.tspi.WriteReg8(hw.INT_STA, hw.INT_GPIO)
+		}
+		edge = time.Now()
 	}
 	// log.Printf("ISR left\n")
 }
@@ -0,0 +1,253 @@
+package adatft
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Mit TouchConfig wird eingestellt, wie der Touch-Controller (STMPE610) die
+// Position misst. Damit kann pro Geraet zwischen schneller Reaktion und
+// geringem Rauschen abgewogen werden. Es sind nur die Werte erlaubt, welche
+// der Controller unterstuetzt (siehe Kommentare); andere Werte werden von
+// Validate zurueckgewiesen.
+type TouchConfig struct {
+	// Neue Messwerte werden nur dann in die FIFO gestellt, wenn sie sich um
+	// mindestens diesen Wert (in Einheiten des Touchscreens) vom letzten
+	// unterscheiden: 0 (aus), 4, 8, 16, 32, 64, 92 oder 127.
+	WindowTracking int
+	// Anzahl Messungen, welche zu einem Messwert gemittelt werden: 1, 2, 4
+	// oder 8.
+	Samples int
+	// Verzoegerung zwischen Erkennung einer Beruehrung und erster Messung:
+	// 10us, 50us, 100us, 500us, 1ms, 5ms, 10ms oder 50ms.
+	Delay time.Duration
+	// Einschwingzeit vor jeder Messung: 10us, 100us, 500us, 1ms, 5ms, 10ms,
+	// 50ms oder 100ms.
+	Settle time.Duration
+	// Aufloesung des ADC: 10 oder 12 Bit.
+	ADCBits int
+	// Sample-Zeit des ADC in Takten: 36, 44, 56, 64, 80, 96 oder 124.
+	SampleClocks int
+	// Treiberstrom in mA: 20 oder 50.
+	DriveCurrent int
+	// Anzahl Nachkommastellen des Z-Wertes (0 bis 7).
+	FractionZ int
+	// Anzahl Messwerte in der FIFO, ab welcher ein Interrupt ausgeloest
+	// wird (1 bis 127).
+	FifoThreshold int
+	// Groesse der Queue fuer die Events (EventQ, mindestens 2). Sie wird nur
+	// beim Oeffnen des Touchscreens beruecksichtigt.
+	QueueSize int
+	// Intervall, mit welchem der Controller abgefragt wird, falls keine
+	// Interrupts verwendet werden sollen (Polling). Mit 0 werden die
+	// Interrupts verwendet; ist dies nicht moeglich (z.B. weil die
+	// Interrupt-Leitung nicht angeschlossen ist), wird automatisch auf
+	// Polling umgestellt (siehe Touch.PollInterval).
+	PollInterval time.Duration
+	// Messwerte mit einem geringeren Druck (TouchPos.Z im Bereich [0,1])
+	// werden verworfen, womit Geisterberuehrungen unterdrueckt werden. Der
+	// Wert ist erst nach einer Kalibrierung des Drucks (siehe
+	// CalibratePressure) aussagekraeftig; 0 schaltet die Pruefung aus.
+	MinPressure float64
+}
+
+var (
+	// Diese Werte entsprechen der bisher fest eingestellten Konfiguration.
+	DefaultTouchConfig = TouchConfig{
+		WindowTracking: 8,
+		Samples:        4,
+		Delay:          time.Millisecond,
+		Settle:         5 * time.Millisecond,
+		ADCBits:        10,
+		SampleClocks:   124,
+		DriveCurrent:   50,
+		FractionZ:      5,
+		FifoThreshold:  1,
+		QueueSize:      eventQueueSize,
+	}
+
+	ErrTouchConfig = errors.New("touch: invalid configuration")
+)
+
+// Die vom Controller unterstuetzten Werte und die zugehoerigen Bitmuster.
+var (
+	wtrkValues = map[int]uint8{
+		0:   hw.TSC_CTRL_WTRK_OFF,
+		4:   hw.TSC_CTRL_WTRK4,
+		8:   hw.TSC_CTRL_WTRK8,
+		16:  hw.TSC_CTRL_WTRK16,
+		32:  hw.TSC_CTRL_WTRK32,
+		64:  hw.TSC_CTRL_WTRK64,
+		92:  hw.TSC_CTRL_WTRK92,
+		127: hw.TSC_CTRL_WTRK127,
+	}
+	sampleValues = map[int]uint8{
+		1: hw.TSC_CFG_1SAMPLE,
+		2: hw.TSC_CFG_2SAMPLE,
+		4: hw.TSC_CFG_4SAMPLE,
+		8: hw.TSC_CFG_8SAMPLE,
+	}
+	delayValues = map[time.Duration]uint8{
+		10 * time.Microsecond:  hw.TSC_CFG_DELAY_10US,
+		50 * time.Microsecond:  hw.TSC_CFG_DELAY_50US,
+		100 * time.Microsecond: hw.TSC_CFG_DELAY_100US,
+		500 * time.Microsecond: hw.TSC_CFG_DELAY_500US,
+		time.Millisecond:       hw.TSC_CFG_DELAY_1MS,
+		5 * time.Millisecond:   hw.TSC_CFG_DELAY_5MS,
+		10 * time.Millisecond:  hw.TSC_CFG_DELAY_10MS,
+		50 * time.Millisecond:  hw.TSC_CFG_DELAY_50MS,
+	}
+	settleValues = map[time.Duration]uint8{
+		10 * time.Microsecond:  hw.TSC_CFG_SETTLE_10US,
+		100 * time.Microsecond: hw.TSC_CFG_SETTLE_100US,
+		500 * time.Microsecond: hw.TSC_CFG_SETTLE_500US,
+		time.Millisecond:       hw.TSC_CFG_SETTLE_1MS,
+		5 * time.Millisecond:   hw.TSC_CFG_SETTLE_5MS,
+		10 * time.Millisecond:  hw.TSC_CFG_SETTLE_10MS,
+		50 * time.Millisecond:  hw.TSC_CFG_SETTLE_50MS,
+		100 * time.Millisecond: hw.TSC_CFG_SETTLE_100MS,
+	}
+	adcBitsValues = map[int]uint8{
+		10: hw.ADC_CTRL1_10BIT,
+		12: hw.ADC_CTRL1_12BIT,
+	}
+	clockValues = map[int]uint8{
+		36:  hw.ADC_CTRL1_36CLK,
+		44:  hw.ADC_CTRL1_44CLK,
+		56:  hw.ADC_CTRL1_56CLK,
+		64:  hw.ADC_CTRL1_64CLK,
+		80:  hw.ADC_CTRL1_80CLK,
+		96:  hw.ADC_CTRL1_96CLK,
+		124: hw.ADC_CTRL1_124CLK,
+	}
+	driveValues = map[int]uint8{
+		20: hw.TSC_I_DRIVE_20MA,
+		50: hw.TSC_I_DRIVE_50MA,
+	}
+)
+
+// Prueft, ob alle Werte vom Controller unterstuetzt werden.
+func (tc TouchConfig) Validate() error {
+	if tc.QueueSize < 2 {
+		return fmt.Errorf("%w: queue size %d not supported", ErrTouchConfig,
+			tc.QueueSize)
+	}
+	if tc.MinPressure < 0.0 || tc.MinPressure >= 1.0 {
+		return fmt.Errorf("%w: min. pressure %g not supported",
+			ErrTouchConfig, tc.MinPressure)
+	}
+	if tc.PollInterval < 0 {
+		return fmt.Errorf("%w: poll interval %v not supported",
+			ErrTouchConfig, tc.PollInterval)
+	}
+	_, err := tc.hwConfig()
+	return err
+}
+
+// Liefert die Registerwerte fuer den STMPE610.
+func (tc TouchConfig) hwConfig() (cfg hw.Config, err error) {
+	lookup := func(name string, ok bool, val any) {
+		if !ok && err == nil {
+			err = fmt.Errorf("%w: %s %v not supported", ErrTouchConfig,
+				name, val)
+		}
+	}
+	wtrk, ok := wtrkValues[tc.WindowTracking]
+	lookup("window tracking", ok, tc.WindowTracking)
+	samples, ok := sampleValues[tc.Samples]
+	lookup("samples", ok, tc.Samples)
+	delay, ok := delayValues[tc.Delay]
+	lookup("delay", ok, tc.Delay)
+	settle, ok := settleValues[tc.Settle]
+	lookup("settle time", ok, tc.Settle)
+	adcBits, ok := adcBitsValues[tc.ADCBits]
+	lookup("ADC resolution", ok, tc.ADCBits)
+	clocks, ok := clockValues[tc.SampleClocks]
+	lookup("sample clocks", ok, tc.SampleClocks)
+	drive, ok := driveValues[tc.DriveCurrent]
+	lookup("drive current", ok, tc.DriveCurrent)
+	lookup("fraction z", tc.FractionZ >= 0 && tc.FractionZ <= 7,
+		tc.FractionZ)
+	lookup("FIFO threshold", tc.FifoThreshold >= 1 &&
+		tc.FifoThreshold <= 127, tc.FifoThreshold)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg = hw.DefaultConfig
+	cfg.TscCtrl = wtrk | hw.TSC_CTRL_XYZ
+	cfg.TscCfg = samples | delay | settle
+	cfg.AdcCtrl1 = adcBits | clocks
+	cfg.FractZ = uint8(tc.FractionZ)
+	cfg.IDrive = drive
+	cfg.FifoTh = uint8(tc.FifoThreshold)
+	return cfg, nil
+}
+
+// Groesster Rohwert fuer Z bei diesem Format des Z-Wertes. Da Z in der
+// FIFO nur 8 Bit umfasst, wird der Wert bei 255 begrenzt.
+func (tc TouchConfig) rawZMax() uint8 {
+	return uint8(min((0b100<<tc.FractionZ)-1, 0xff))
+}
+
+// Liefert die aktuelle Konfiguration des Touch-Controllers.
+func (tch *Touch) Config() TouchConfig {
+	tch.configMutex.RLock()
+	defer tch.configMutex.RUnlock()
+	return tch.config
+}
+
+// Aendert die Konfiguration des Touch-Controllers im laufenden Betrieb.
+// Die Abbildung der Z-Werte wird dem neuen Format angepasst (eine
+// Kalibrierung des Drucks bleibt dabei gueltig). Ebenso wird die
+// Kalibrierung fuer X und Y auf die neue Aufloesung des ADC umgerechnet,
+// sofern deren Aufloesung bekannt ist (siehe DistortedPlane.ADCBits). Die
+// Groesse der Queue bleibt unveraendert. SetConfig kann aus einer beliebigen
+// Go-Routine aufgerufen werden.
+func (tch *Touch) SetConfig(tc TouchConfig) error {
+	if tch.EventQ != nil {
+		tc.QueueSize = cap(tch.EventQ)
+	}
+	if err := tc.Validate(); err != nil {
+		return err
+	}
+	cfg, _ := tc.hwConfig()
+	tch.configMutex.Lock()
+	hw.WriteConfig(tch.tspi, cfg)
+	tch.config = tc
+	tch.configMutex.Unlock()
+	tch.applyConfig(tc)
+	return nil
+}
+
+// Passt Polling, minimalen Druck und Kalibrierung an die Konfiguration tc
+// an, nachdem sie zum Controller gesendet und in tch.config abgelegt wurde.
+func (tch *Touch) applyConfig(tc TouchConfig) {
+	if p, ok := tch.tspi.(poller); ok {
+		p.SetPollInterval(tc.PollInterval)
+	}
+	tch.setMinPressure(tc.MinPressure)
+	tch.planeMutex.Lock()
+	defer tch.planeMutex.Unlock()
+	tch.plane.setZFormat(tc.FractionZ, tc.rawZMax())
+	tch.plane.setXYFormat(tc.ADCBits)
+}
+
+// Wird von Touch-Controllern implementiert, welche statt mit Interrupts
+// auch durch regelmaessiges Abfragen betrieben werden koennen.
+type poller interface {
+	SetPollInterval(interval time.Duration)
+	PollInterval() time.Duration
+}
+
+// Liefert das Intervall, mit welchem der Controller abgefragt wird, oder 0,
+// falls Interrupts verwendet werden.
+func (tch *Touch) PollInterval() time.Duration {
+	if p, ok := tch.tspi.(poller); ok {
+		return p.PollInterval()
+	}
+	return 0
+}
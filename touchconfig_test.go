@@ -0,0 +1,199 @@
+package adatft
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Ein Touch-Controller ohne Hardware, welcher nur die beschriebenen
+// Register festhaelt.
+type regRecorder struct {
+	regs   map[uint8]uint8
+	writes []uint8
+}
+
+func newRegRecorder() *regRecorder {
+	return &regRecorder{regs: make(map[uint8]uint8)}
+}
+
+func (r *regRecorder) Init(params []any)                        {}
+func (r *regRecorder) Close()                                   {}
+func (r *regRecorder) ReadReg8(addr uint8) uint8                { return r.regs[addr] }
+func (r *regRecorder) ReadReg16(addr uint8) uint16              { return 0 }
+func (r *regRecorder) WriteReg16(addr uint8, value uint16)      {}
+func (r *regRecorder) ReadData() (x, y uint16, z uint8)         { return 0, 0, 0 }
+func (r *regRecorder) SetCallback(cbFunc func(any), cbData any) {}
+func (r *regRecorder) WriteReg8(addr uint8, value uint8) {
+	r.regs[addr] = value
+	r.writes = append(r.writes, addr)
+}
+
+func TestTouchConfigDefault(t *testing.T) {
+	cfg, err := DefaultTouchConfig.hwConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != hw.DefaultConfig {
+		t.Errorf("got %+v, want %+v", cfg, hw.DefaultConfig)
+	}
+}
+
+func TestTouchConfigValidate(t *testing.T) {
+	testList := []func(tc *TouchConfig){
+		func(tc *TouchConfig) { tc.WindowTracking = 10 },
+		func(tc *TouchConfig) { tc.Samples = 3 },
+		func(tc *TouchConfig) { tc.Delay = 2 * time.Millisecond },
+		func(tc *TouchConfig) { tc.Settle = 0 },
+		func(tc *TouchConfig) { tc.ADCBits = 8 },
+		func(tc *TouchConfig) { tc.SampleClocks = 100 },
+		func(tc *TouchConfig) { tc.DriveCurrent = 30 },
+		func(tc *TouchConfig) { tc.FractionZ = 8 },
+		func(tc *TouchConfig) { tc.FifoThreshold = 0 },
+		func(tc *TouchConfig) { tc.QueueSize = 1 },
+	}
+	for i, modify := range testList {
+		tc := DefaultTouchConfig
+		modify(&tc)
+		if err := tc.Validate(); !errors.Is(err, ErrTouchConfig) {
+			t.Errorf("case %d: got %v", i, err)
+		}
+	}
+}
+
+func TestTouchSetConfig(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	rec := tch.tspi.(*regRecorder)
+	tc := DefaultTouchConfig
+	tc.ADCBits = 12
+	tc.Samples = 8
+	tc.WindowTracking = 0
+	tc.FractionZ = 6
+	if err := tch.SetConfig(tc); err != nil {
+		t.Fatal(err)
+	}
+	if rec.regs[hw.ADC_CTRL1]&hw.ADC_CTRL1_12BIT == 0 ||
+		rec.regs[hw.TSC_CFG]&0xC0 != hw.TSC_CFG_8SAMPLE ||
+		rec.regs[hw.TSC_CTRL] != hw.TSC_CTRL_XYZ|hw.TSC_CTRL_EN {
+		t.Errorf("registers %v", rec.regs)
+	}
+	// Der Controller muss waehrend der Konfiguration ausgeschaltet sein.
+	if rec.writes[0] != hw.TSC_CTRL || rec.writes[len(rec.writes)-1] !=
+		hw.TSC_CTRL {
+		t.Errorf("write order %v", rec.writes)
+	}
+	if tch.plane.RawZmax != 0xff || tch.Config() != tc {
+		t.Errorf("z range %d, config %+v", tch.plane.RawZmax, tch.Config())
+	}
+	tc.Samples = 5
+	if err := tch.SetConfig(tc); err == nil || tch.Config().Samples != 8 {
+		t.Errorf("invalid config applied")
+	}
+}
+
+type pollRecorder struct {
+	*regRecorder
+	interval time.Duration
+}
+
+func (p *pollRecorder) SetPollInterval(interval time.Duration) {
+	p.interval = interval
+}
+
+func (p *pollRecorder) PollInterval() time.Duration {
+	return p.interval
+}
+
+func TestTouchPollInterval(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	if tch.PollInterval() != 0 {
+		t.Errorf("controller without polling reports %v", tch.PollInterval())
+	}
+
+	rec := &pollRecorder{regRecorder: newRegRecorder()}
+	tch.tspi = rec
+	tc := DefaultTouchConfig
+	tc.PollInterval = 20 * time.Millisecond
+	if err := tch.SetConfig(tc); err != nil {
+		t.Fatal(err)
+	}
+	if rec.interval != tc.PollInterval || tch.PollInterval() !=
+		tc.PollInterval {
+		t.Errorf("poll interval is %v, want %v", rec.interval,
+			tc.PollInterval)
+	}
+	tc.PollInterval = -time.Millisecond
+	if err := tch.SetConfig(tc); !errors.Is(err, ErrTouchConfig) {
+		t.Errorf("negative poll interval: got %v", err)
+	}
+}
+
+// Die Konfiguration darf geaendert werden, waehrend der Dispatcher Daten
+// verarbeitet (mit -race pruefen).
+func TestTouchSetConfigConcurrent(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	tch.config = DefaultTouchConfig
+	tch.plane.setDefault(Rotate000)
+	tch.OpenRawStream(4)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		tc := DefaultTouchConfig
+		for i := 0; i < 100; i++ {
+			tc.Samples = 1 << (i % 4)
+			if err := tch.SetConfig(tc); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	samples := []TouchRawPos{{RawX: 1000, RawY: 2000, RawZ: 50}}
+	for i := 0; i < 100; i++ {
+		tch.streamSamples(samples, false)
+		_ = tch.Config()
+	}
+	<-done
+}
+
+// Beim Wechsel zwischen dem 10- und dem 12-Bit-Modus muss die Kalibrierung
+// auf die neue Aufloesung der Rohdaten umgerechnet werden.
+func TestTouchSetConfigADCBits(t *testing.T) {
+	for cm := CalibLinear; cm < NumCalibModels; cm++ {
+		raw, pos := modelTestPoints(modelTestMaps[cm], 3)
+		coeff, err := fitModel(cm, raw, pos)
+		if err != nil {
+			t.Fatalf("%v: %v", cm, err)
+		}
+		tch := newTestTouch(eventQueueSize)
+		tch.setPlane(DistortedPlane{Model: cm, Width: 240, Height: 320,
+			RawPosList: raw, PosList: pos, Coeff: coeff, ADCBits: 12})
+		for _, bits := range []int{10, 12} {
+			tc := DefaultTouchConfig
+			tc.ADCBits = bits
+			if err := tch.SetConfig(tc); err != nil {
+				t.Fatal(err)
+			}
+			plane := tch.Plane()
+			shift := 12 - bits
+			if plane.ADCBits != bits ||
+				plane.RawPosList[1].RawY != raw[1].RawY>>shift {
+				t.Errorf("%v/%d bit: plane %d bit, raw %v", cm, bits,
+					plane.ADCBits, plane.RawPosList[1])
+			}
+			for _, r := range []TouchRawPos{{RawX: 400, RawY: 352},
+				{RawX: 2000, RawY: 1800}, {RawX: 3600, RawY: 3648}} {
+				wx, wy := modelTestMaps[cm](float64(r.RawX), float64(r.RawY))
+				r.RawX, r.RawY = r.RawX>>shift, r.RawY>>shift
+				p, err := tch.transform(r)
+				if err != nil || math.Hypot(p.X-wx, p.Y-wy) > 1e-6 {
+					t.Errorf("%v/%d bit: %v -> %v, want (%.3f, %.3f)", cm,
+						bits, r, p, wx, wy)
+				}
+			}
+		}
+	}
+}
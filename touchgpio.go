@@ -0,0 +1,120 @@
+package adatft
+
+import (
+	"errors"
+	"time"
+)
+
+// Ueber den Touchscreen sind auch die GPIOs und die freien ADC-Kanaele des
+// Touch-Controllers zugaenglich (siehe stmpe610.SetGPIOMode). Die Pins
+// werden als Bitmaske angegeben; die vom Touchscreen belegten Pins werden
+// zurueckgewiesen. Flanken an den GPIOs werden vom gleichen Interrupt-
+// Handler wie die Beruehrungen verarbeitet (siehe SetGPIOHandler).
+
+var (
+	ErrNoGPIO = errors.New("touch: controller has no GPIO and ADC functions")
+)
+
+// Beschreibt die Flanken, welche an den GPIOs erkannt wurden.
+type GPIOEvent struct {
+	// Pins mit einer Flanke seit dem letzten Event.
+	Pins uint8
+	// Zustand aller Pins nach den Flanken.
+	Level uint8
+	Time  time.Time
+}
+
+// Typ der Funktion, welche bei Flanken an den GPIOs aufgerufen wird.
+type GPIOHandlerType func(ev GPIOEvent)
+
+// Wird von Touch-Controllern implementiert, welche GPIOs und einen ADC zur
+// Verfuegung stellen.
+type gpioController interface {
+	SetGPIOMode(pins uint8, output bool) error
+	WriteGPIO(pins uint8, high bool) error
+	ReadGPIO() uint8
+	SetGPIOEdges(pins uint8, rising, falling bool) error
+	GPIOEdges() (pins, level uint8)
+	ReadADC(channel int) (uint16, error)
+}
+
+func (tch *Touch) gpio() (gpioController, error) {
+	if gc, ok := tch.tspi.(gpioController); ok {
+		return gc, nil
+	}
+	return nil, ErrNoGPIO
+}
+
+// Konfiguriert die Pins pins als GPIO, als Ausgang (output ist true) oder
+// als Eingang.
+func (tch *Touch) SetGPIOMode(pins uint8, output bool) error {
+	gc, err := tch.gpio()
+	if err != nil {
+		return err
+	}
+	return gc.SetGPIOMode(pins, output)
+}
+
+// Setzt die Ausgaenge pins auf High (high ist true) oder Low.
+func (tch *Touch) WriteGPIO(pins uint8, high bool) error {
+	gc, err := tch.gpio()
+	if err != nil {
+		return err
+	}
+	return gc.WriteGPIO(pins, high)
+}
+
+// Liefert den aktuellen Zustand aller Pins.
+func (tch *Touch) ReadGPIO() (uint8, error) {
+	gc, err := tch.gpio()
+	if err != nil {
+		return 0, err
+	}
+	return gc.ReadGPIO(), nil
+}
+
+// Fuehrt eine Wandlung auf dem ADC-Kanal channel durch und liefert den
+// Rohwert.
+func (tch *Touch) ReadADC(channel int) (uint16, error) {
+	gc, err := tch.gpio()
+	if err != nil {
+		return 0, err
+	}
+	return gc.ReadADC(channel)
+}
+
+// Legt fest, bei welchen Flanken an den Pins pins der Handler (siehe
+// SetGPIOHandler) aufgerufen wird. Sind weder rising noch falling gesetzt,
+// werden die Flanken dieser Pins nicht mehr gemeldet.
+func (tch *Touch) SetGPIOEdges(pins uint8, rising, falling bool) error {
+	gc, err := tch.gpio()
+	if err != nil {
+		return err
+	}
+	return gc.SetGPIOEdges(pins, rising, falling)
+}
+
+// Hinterlegt die Funktion handler, welche bei Flanken an den GPIOs
+// aufgerufen wird (nil entfernt den Handler). Sie wird im Interrupt-Handler
+// aufgerufen und muss daher rasch zurueckkehren.
+func (tch *Touch) SetGPIOHandler(handler GPIOHandlerType) {
+	tch.gpioMutex.Lock()
+	defer tch.gpioMutex.Unlock()
+	tch.gpioHandler = handler
+}
+
+// Liest und quittiert die Flanken an den GPIOs und ruft den Handler auf.
+// Wird vom eventDispatcher aufgerufen.
+func (tch *Touch) dispatchGPIO() {
+	gc, err := tch.gpio()
+	if err != nil {
+		return
+	}
+	pins, level := gc.GPIOEdges()
+	tch.gpioMutex.Lock()
+	handler := tch.gpioHandler
+	tch.gpioMutex.Unlock()
+	if pins != 0 && handler != nil {
+		handler(GPIOEvent{Pins: pins, Level: level, Time: time.Now()})
+	}
+}
@@ -0,0 +1,107 @@
+package adatft
+
+import (
+	"errors"
+	"testing"
+
+	hw "github.com/stefan-muehlebach/adatft/stmpe610"
+)
+
+// Simuliert die GPIOs und den ADC eines Touch-Controllers.
+type gpioSim struct {
+	*fifoSim
+	output, level, edges uint8
+	adc                  [8]uint16
+}
+
+func (g *gpioSim) SetGPIOMode(pins uint8, output bool) error {
+	if pins&hw.TouchPins != 0 {
+		return hw.ErrGPIOPin
+	}
+	if output {
+		g.output |= pins
+	} else {
+		g.output &^= pins
+	}
+	return nil
+}
+
+func (g *gpioSim) WriteGPIO(pins uint8, high bool) error {
+	if high {
+		g.level |= pins & g.output
+	} else {
+		g.level &^= pins & g.output
+	}
+	return nil
+}
+
+func (g *gpioSim) ReadGPIO() uint8 { return g.level }
+
+func (g *gpioSim) SetGPIOEdges(pins uint8, rising, falling bool) error {
+	g.regs[hw.INT_EN] |= hw.INT_GPIO
+	return nil
+}
+
+func (g *gpioSim) GPIOEdges() (pins, level uint8) {
+	pins, g.edges = g.edges, 0
+	return pins, g.level
+}
+
+func (g *gpioSim) ReadADC(channel int) (uint16, error) {
+	return g.adc[channel], nil
+}
+
+func TestTouchNoGPIO(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	if err := tch.SetGPIOMode(0x01, true); !errors.Is(err, ErrNoGPIO) {
+		t.Errorf("SetGPIOMode: got %v, want ErrNoGPIO", err)
+	}
+	if _, err := tch.ReadADC(0); !errors.Is(err, ErrNoGPIO) {
+		t.Errorf("ReadADC: got %v, want ErrNoGPIO", err)
+	}
+}
+
+func TestTouchGPIO(t *testing.T) {
+	sim := &gpioSim{fifoSim: &fifoSim{regRecorder: newRegRecorder()}}
+	sim.regs[hw.INT_EN] = hw.INT_TOUCH_DET | hw.INT_FIFO_TH
+	sim.adc[2] = 0x123
+	tch := newTestTouch(eventQueueSize)
+	tch.tspi = sim
+
+	if err := tch.SetGPIOMode(0x10, true); !errors.Is(err, hw.ErrGPIOPin) {
+		t.Errorf("touchscreen pin: got %v, want ErrGPIOPin", err)
+	}
+	if err := tch.SetGPIOMode(0x03, true); err != nil {
+		t.Fatal(err)
+	}
+	tch.WriteGPIO(0x01, true)
+	if level, err := tch.ReadGPIO(); err != nil || level != 0x01 {
+		t.Errorf("ReadGPIO() = %08b, %v", level, err)
+	}
+	if v, err := tch.ReadADC(2); err != nil || v != 0x123 {
+		t.Errorf("ReadADC(2) = %#x, %v", v, err)
+	}
+
+	events := make(chan GPIOEvent, 1)
+	tch.SetGPIOHandler(func(ev GPIOEvent) { events <- ev })
+	if err := tch.SetGPIOEdges(0x08, true, false); err != nil {
+		t.Fatal(err)
+	}
+	sim.edges, sim.level = 0x08, 0x09
+	sim.regs[hw.INT_STA] = hw.INT_GPIO
+	eventDispatcher(tch)
+	select {
+	case ev := <-events:
+		if ev.Pins != 0x08 || ev.Level != 0x09 || ev.Time.IsZero() {
+			t.Errorf("got %+v", ev)
+		}
+	default:
+		t.Fatalf("GPIO handler not called")
+	}
+	if sim.regs[hw.INT_STA]&hw.INT_GPIO != 0 {
+		t.Errorf("GPIO interrupt not acknowledged")
+	}
+	if len(tch.EventQ) != 0 {
+		t.Errorf("GPIO edge produced a pen event")
+	}
+}
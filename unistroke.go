@@ -0,0 +1,491 @@
+package adatft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Mit einem UnistrokeRecognizer werden Symbole erkannt, welche in einem
+// Zug auf den Touchscreen gezeichnet werden (z.B. Haken, Kreuz oder Kreis).
+// Gerade mit Handschuhen sind solche Symbole zuverlaessiger als kleine
+// Schaltflaechen. Das Verfahren entspricht dem $1-Recognizer von Wobbrock,
+// Wilson und Li: der Strich wird auf eine feste Anzahl Punkte umgerechnet,
+// gedreht, skaliert und verschoben und anschliessend mit allen Vorlagen
+// verglichen. Die Vorlage mit dem geringsten mittleren Abstand gewinnt.
+
+const (
+	// Name des Files (im Konfigurationsverzeichnis) mit den Vorlagen der
+	// Applikation.
+	unistrokeFile = "Unistrokes.json"
+	// Anzahl Punkte, auf welche ein Strich umgerechnet wird.
+	unistrokePoints = 64
+	// Groesse des Quadrates, auf welches ein Strich skaliert wird.
+	unistrokeSize = 250.0
+	// Striche mit einem kleineren Verhaeltnis zwischen Breite und Hoehe
+	// gelten als eindimensional und werden in beiden Richtungen gleich
+	// skaliert.
+	unistrokeRatio1D = 0.3
+	// Bereich und Genauigkeit, mit welchen die beste Drehung gesucht wird.
+	unistrokeAngleRange     = 45.0 * math.Pi / 180.0
+	unistrokeAnglePrecision = 2.0 * math.Pi / 180.0
+)
+
+var (
+	ErrUnistroke     = errors.New("unistroke: stroke too short")
+	ErrUnistrokeData = errors.New("unistroke: invalid template data")
+
+	// Die Werte eines neuen UnistrokeRecognizers.
+	DefaultUnistrokeMinScore  = 0.8
+	DefaultUnistrokeMinLength = 30.0
+)
+
+// Eine Vorlage fuer ein Symbol. Points enthaelt den Strich so, wie er
+// gezeichnet wurde (Z wird nicht beruecksichtigt). Fuer ein Symbol koennen
+// mehrere Vorlagen existieren (z.B. fuer verschiedene Zeichenrichtungen).
+type UnistrokeTemplate struct {
+	Name   string
+	Points []TouchPos
+}
+
+// Die fuer den Vergleich aufbereitete Vorlage.
+type unistrokeTemplate struct {
+	UnistrokeTemplate
+	norm    []TouchPos
+	builtin bool
+}
+
+// Das Ergebnis einer Erkennung. Score liegt im Bereich [0,1] und ist ein
+// Mass fuer die Uebereinstimmung mit der besten Vorlage. Liegt Score unter
+// MinScore des UnistrokeRecognizers, ist Name leer.
+type UnistrokeResult struct {
+	Name   string
+	Score  float64
+	Points []TouchPos
+}
+
+// Der UnistrokeRecognizer enthaelt die eingebauten Vorlagen (siehe
+// BuiltinUnistrokes) sowie diejenigen der Applikation. Alle Methoden
+// koennen aus verschiedenen Go-Routinen aufgerufen werden.
+type UnistrokeRecognizer struct {
+	// Minimaler Score fuer eine erfolgreiche Erkennung.
+	MinScore float64
+	// Minimale Laenge eines Striches (in Pixeln). Kuerzere Striche (z.B.
+	// ein Tap) werden nicht ausgewertet.
+	MinLength float64
+
+	mutex     sync.Mutex
+	templates []*unistrokeTemplate
+	points    []TouchPos
+	down      bool
+}
+
+// Erstellt einen neuen UnistrokeRecognizer mit den eingebauten Vorlagen.
+// Die Vorlagen der Applikation koennen mit LoadTemplates geladen werden.
+func NewUnistrokeRecognizer() *UnistrokeRecognizer {
+	ur := &UnistrokeRecognizer{MinScore: DefaultUnistrokeMinScore,
+		MinLength: DefaultUnistrokeMinLength}
+	for _, t := range BuiltinUnistrokes() {
+		ur.templates = append(ur.templates, &unistrokeTemplate{
+			UnistrokeTemplate: t, norm: normalizeUnistroke(t.Points),
+			builtin: true})
+	}
+	return ur
+}
+
+// Liefert die eingebauten Vorlagen: check (Haken), x (Kreuz, in einem Zug
+// von oben links), circle (Kreis, in beiden Richtungen), arrow (Pfeil nach
+// rechts), triangle und rectangle.
+func BuiltinUnistrokes() []UnistrokeTemplate {
+	poly := func(name string, pts ...float64) UnistrokeTemplate {
+		t := UnistrokeTemplate{Name: name}
+		for i := 0; i < len(pts); i += 2 {
+			t.Points = append(t.Points, TouchPos{X: pts[i], Y: pts[i+1]})
+		}
+		return t
+	}
+	circle := func(dir float64) UnistrokeTemplate {
+		t := UnistrokeTemplate{Name: "circle"}
+		for i := 0; i <= 32; i++ {
+			a := dir * 2.0 * math.Pi * float64(i) / 32.0
+			t.Points = append(t.Points, TouchPos{X: 50.0 + 50.0*math.Sin(a),
+				Y: 50.0 - 50.0*math.Cos(a)})
+		}
+		return t
+	}
+	return []UnistrokeTemplate{
+		poly("check", 0, 50, 30, 90, 100, 0),
+		poly("x", 0, 0, 100, 100, 100, 0, 0, 100),
+		circle(1.0),
+		circle(-1.0),
+		poly("arrow", 0, 50, 100, 50, 70, 20, 100, 50, 70, 80),
+		poly("triangle", 50, 0, 0, 100, 100, 100, 50, 0),
+		poly("rectangle", 0, 0, 0, 100, 100, 100, 100, 0, 0, 0),
+	}
+}
+
+// Fuegt eine Vorlage der Applikation fuer das Symbol name hinzu.
+func (ur *UnistrokeRecognizer) AddTemplate(name string,
+	points []TouchPos) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrUnistrokeData)
+	}
+	if pathLength(points) <= 0.0 {
+		return ErrUnistroke
+	}
+	t := &unistrokeTemplate{UnistrokeTemplate: UnistrokeTemplate{Name: name,
+		Points: slices.Clone(points)}, norm: normalizeUnistroke(points)}
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+	ur.templates = append(ur.templates, t)
+	return nil
+}
+
+// Entfernt alle Vorlagen der Applikation fuer das Symbol name und liefert
+// deren Anzahl. Die eingebauten Vorlagen bleiben erhalten.
+func (ur *UnistrokeRecognizer) RemoveTemplates(name string) int {
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+	n := len(ur.templates)
+	ur.templates = slices.DeleteFunc(ur.templates,
+		func(t *unistrokeTemplate) bool {
+			return !t.builtin && t.Name == name
+		})
+	return n - len(ur.templates)
+}
+
+// Liefert die Namen aller bekannten Symbole (sortiert, ohne Duplikate).
+func (ur *UnistrokeRecognizer) Names() []string {
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+	var names []string
+	for _, t := range ur.templates {
+		names = append(names, t.Name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Vergleicht den Strich points mit allen Vorlagen und liefert das Symbol
+// mit der besten Uebereinstimmung. Ist der Strich kuerzer als MinLength,
+// wird ErrUnistroke geliefert.
+func (ur *UnistrokeRecognizer) Recognize(points []TouchPos) (UnistrokeResult,
+	error) {
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+	return ur.recognize(points)
+}
+
+func (ur *UnistrokeRecognizer) recognize(points []TouchPos) (UnistrokeResult,
+	error) {
+	res := UnistrokeResult{Points: slices.Clone(points)}
+	if pathLength(points) < max(ur.MinLength, math.SmallestNonzeroFloat64) {
+		return res, ErrUnistroke
+	}
+	norm := normalizeUnistroke(points)
+	best, bestName := math.Inf(1), ""
+	for _, t := range ur.templates {
+		if d := distanceAtBestAngle(norm, t.norm); d < best {
+			best, bestName = d, t.Name
+		}
+	}
+	if bestName == "" {
+		return res, nil
+	}
+	res.Score = max(1.0-best/(0.5*math.Sqrt2*unistrokeSize), 0.0)
+	if res.Score >= ur.MinScore {
+		res.Name = bestName
+	}
+	return res, nil
+}
+
+// Verarbeitet das PenEvent ev. Beim Loslassen wird der seit dem Druck
+// gezeichnete Strich ausgewertet und das Ergebnis mit true geliefert.
+// Zu kurze Striche werden ignoriert.
+func (ur *UnistrokeRecognizer) Feed(ev PenEvent) (UnistrokeResult, bool) {
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+
+	switch ev.Type {
+	case PenPress:
+		ur.points = append(ur.points[:0], ev.TouchPos)
+		ur.down = true
+	case PenDrag:
+		if ur.down {
+			ur.points = append(ur.points, ev.TouchPos)
+		}
+	case PenRelease:
+		if !ur.down {
+			break
+		}
+		ur.down = false
+		res, err := ur.recognize(ur.points)
+		return res, err == nil
+	}
+	return UnistrokeResult{}, false
+}
+
+// Liest die PenEvents aus in und schreibt die Ergebnisse nach out, bis in
+// geschlossen wird. Anschliessend wird out geschlossen.
+func (ur *UnistrokeRecognizer) Run(in <-chan PenEvent,
+	out chan<- UnistrokeResult) {
+	defer close(out)
+	for ev := range in {
+		if res, ok := ur.Feed(ev); ok {
+			out <- res
+		}
+	}
+}
+
+// Startet die Erkennung von Symbolen auf den Events des Touchscreens und
+// liefert einen Channel mit den Ergebnissen. Die Erkennung beobachtet alle
+// Events, ohne sie zu verbrauchen: die Abonnenten der Applikation (siehe
+// Subscribe) und EventQ erhalten sie weiterhin, so dass z.B. WaitForEvent,
+// Gestures oder Calibrate unveraendert funktionieren. Da die Handler aller
+// Abonnenten in der gleichen Go-Routine laufen, werden Ergebnisse
+// verworfen, solange der Channel voll ist. Mit der gelieferten Funktion
+// wird die Erkennung beendet und der Channel geschlossen.
+func (tch *Touch) Unistrokes(ur *UnistrokeRecognizer) (<-chan UnistrokeResult,
+	func()) {
+	var mutex sync.Mutex
+	var once sync.Once
+	out := make(chan UnistrokeResult, eventQueueSize)
+	closed := false
+
+	sub := tch.observe(func(ev PenEvent) {
+		res, ok := ur.Feed(ev)
+		if !ok {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case out <- res:
+		default:
+		}
+	})
+	cancel := func() {
+		once.Do(func() {
+			sub.Unsubscribe()
+			mutex.Lock()
+			defer mutex.Unlock()
+			closed = true
+			close(out)
+		})
+	}
+	return out, cancel
+}
+
+// Das Format des Files mit den Vorlagen der Applikation.
+type unistrokeData struct {
+	Templates []UnistrokeTemplate
+}
+
+// Liefert den Namen des Files mit den Vorlagen der Applikation im
+// Konfigurationsverzeichnis.
+func UnistrokeFileName() string {
+	return filepath.Join(confDir, unistrokeFile)
+}
+
+// Schreibt die Vorlagen der Applikation (ohne die eingebauten) in das File
+// im Konfigurationsverzeichnis (siehe UnistrokeFileName).
+func (ur *UnistrokeRecognizer) SaveTemplates() error {
+	return ur.SaveTemplatesFile(UnistrokeFileName())
+}
+
+// Wie SaveTemplates, die Vorlagen werden jedoch in das File fileName
+// geschrieben. Als Dateiformat wird JSON verwendet.
+func (ur *UnistrokeRecognizer) SaveTemplatesFile(fileName string) error {
+	ur.mutex.Lock()
+	data := unistrokeData{Templates: []UnistrokeTemplate{}}
+	for _, t := range ur.templates {
+		if !t.builtin {
+			data.Templates = append(data.Templates, t.UnistrokeTemplate)
+		}
+	}
+	ur.mutex.Unlock()
+
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(fileName+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+// Laedt die Vorlagen der Applikation aus dem File im Konfigurations-
+// verzeichnis (siehe UnistrokeFileName). Existiert das File nicht, bleiben
+// die Vorlagen unveraendert und es wird kein Fehler geliefert.
+func (ur *UnistrokeRecognizer) LoadTemplates() error {
+	err := ur.LoadTemplatesFile(UnistrokeFileName())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Wie LoadTemplates, die Vorlagen werden jedoch aus dem File fileName
+// gelesen. Sie ersetzen alle bisherigen Vorlagen der Applikation. Bei
+// einem Fehler bleiben die Vorlagen unveraendert.
+func (ur *UnistrokeRecognizer) LoadTemplatesFile(fileName string) error {
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var data unistrokeData
+	if err = json.Unmarshal(buf, &data); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnistrokeData, err)
+	}
+	var templates []*unistrokeTemplate
+	for i, t := range data.Templates {
+		if t.Name == "" || pathLength(t.Points) <= 0.0 {
+			return fmt.Errorf("%w: template %d", ErrUnistrokeData, i)
+		}
+		templates = append(templates, &unistrokeTemplate{
+			UnistrokeTemplate: t, norm: normalizeUnistroke(t.Points)})
+	}
+
+	ur.mutex.Lock()
+	defer ur.mutex.Unlock()
+	ur.templates = slices.DeleteFunc(ur.templates,
+		func(t *unistrokeTemplate) bool {
+			return !t.builtin
+		})
+	ur.templates = append(ur.templates, templates...)
+	return nil
+}
+
+// Bereitet den Strich points fuer den Vergleich auf: gleichmaessig
+// verteilte Punkte, Drehung auf den charakteristischen Winkel (erster
+// Punkt zum Schwerpunkt), Skalierung auf unistrokeSize und Verschiebung
+// des Schwerpunktes in den Ursprung.
+func normalizeUnistroke(points []TouchPos) []TouchPos {
+	pts := resample(points, unistrokePoints)
+	c := centroid(pts)
+	pts = rotateBy(pts, -math.Atan2(c.Y-pts[0].Y, c.X-pts[0].X))
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range pts {
+		minX, maxX = min(minX, p.X), max(maxX, p.X)
+		minY, maxY = min(minY, p.Y), max(maxY, p.Y)
+	}
+	w, h := maxX-minX, maxY-minY
+	sx, sy := unistrokeSize/w, unistrokeSize/h
+	if min(w, h)/max(w, h) < unistrokeRatio1D {
+		sx = unistrokeSize / max(w, h)
+		sy = sx
+	}
+	for i := range pts {
+		pts[i].X *= sx
+		pts[i].Y *= sy
+	}
+	c = centroid(pts)
+	for i := range pts {
+		pts[i].X -= c.X
+		pts[i].Y -= c.Y
+	}
+	return pts
+}
+
+// Liefert n Punkte, welche gleichmaessig auf dem Strich points verteilt
+// sind.
+func resample(points []TouchPos, n int) []TouchPos {
+	interval := pathLength(points) / float64(n-1)
+	res := make([]TouchPos, 0, n)
+	res = append(res, TouchPos{X: points[0].X, Y: points[0].Y})
+	prev, dist := res[0], 0.0
+	for i := 1; i < len(points) && len(res) < n; i++ {
+		p := TouchPos{X: points[i].X, Y: points[i].Y}
+		d := math.Hypot(p.X-prev.X, p.Y-prev.Y)
+		for dist+d >= interval && d > 0.0 && len(res) < n {
+			t := (interval - dist) / d
+			q := TouchPos{X: prev.X + t*(p.X-prev.X),
+				Y: prev.Y + t*(p.Y-prev.Y)}
+			res = append(res, q)
+			prev, d, dist = q, d-(interval-dist), 0.0
+		}
+		dist += d
+		prev = p
+	}
+	last := points[len(points)-1]
+	for len(res) < n {
+		res = append(res, TouchPos{X: last.X, Y: last.Y})
+	}
+	return res
+}
+
+func pathLength(points []TouchPos) float64 {
+	l := 0.0
+	for i := 1; i < len(points); i++ {
+		l += math.Hypot(points[i].X-points[i-1].X, points[i].Y-points[i-1].Y)
+	}
+	return l
+}
+
+func centroid(points []TouchPos) TouchPos {
+	var c TouchPos
+	for _, p := range points {
+		c.X += p.X
+		c.Y += p.Y
+	}
+	c.X /= float64(len(points))
+	c.Y /= float64(len(points))
+	return c
+}
+
+// Dreht die Punkte um den Winkel angle um ihren Schwerpunkt.
+func rotateBy(points []TouchPos, angle float64) []TouchPos {
+	c := centroid(points)
+	sin, cos := math.Sincos(angle)
+	res := make([]TouchPos, len(points))
+	for i, p := range points {
+		dx, dy := p.X-c.X, p.Y-c.Y
+		res[i] = TouchPos{X: dx*cos - dy*sin + c.X, Y: dx*sin + dy*cos + c.Y}
+	}
+	return res
+}
+
+// Liefert den mittleren Abstand zwischen den Punkten von a und b.
+func pathDistance(a, b []TouchPos) float64 {
+	d := 0.0
+	for i := range a {
+		d += math.Hypot(a[i].X-b[i].X, a[i].Y-b[i].Y)
+	}
+	return d / float64(len(a))
+}
+
+// Sucht mit dem Goldenen Schnitt die Drehung von pts (im Bereich
+// +/-unistrokeAngleRange), bei welcher der Abstand zur Vorlage tmpl
+// minimal ist, und liefert diesen Abstand.
+func distanceAtBestAngle(pts, tmpl []TouchPos) float64 {
+	phi := 0.5 * (math.Sqrt(5.0) - 1.0)
+	a, b := -unistrokeAngleRange, unistrokeAngleRange
+	x1 := phi*a + (1.0-phi)*b
+	f1 := pathDistance(rotateBy(pts, x1), tmpl)
+	x2 := (1.0-phi)*a + phi*b
+	f2 := pathDistance(rotateBy(pts, x2), tmpl)
+	for math.Abs(b-a) > unistrokeAnglePrecision {
+		if f1 < f2 {
+			b, x2, f2 = x2, x1, f1
+			x1 = phi*a + (1.0-phi)*b
+			f1 = pathDistance(rotateBy(pts, x1), tmpl)
+		} else {
+			a, x1, f1 = x1, x2, f2
+			x2 = (1.0-phi)*a + phi*b
+			f2 = pathDistance(rotateBy(pts, x2), tmpl)
+		}
+	}
+	return min(f1, f2)
+}
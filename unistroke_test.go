@@ -0,0 +1,211 @@
+package adatft
+
+import (
+	"errors"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Liefert die Punkte der Vorlage, skaliert, verschoben, leicht gedreht und
+// mit (deterministischem) Rauschen, wie sie etwa auf dem Touchscreen
+// gezeichnet werden.
+func drawnUnistroke(t UnistrokeTemplate, scale, angle float64) []TouchPos {
+	pts := resample(t.Points, 40)
+	pts = rotateBy(pts, angle)
+	for i := range pts {
+		pts[i].X = 100.0 + scale*pts[i].X + 1.5*math.Sin(float64(i)*1.7)
+		pts[i].Y = 60.0 + scale*pts[i].Y + 1.5*math.Cos(float64(i)*2.3)
+	}
+	return pts
+}
+
+func TestUnistrokeBuiltins(t *testing.T) {
+	ur := NewUnistrokeRecognizer()
+	want := []string{"arrow", "check", "circle", "rectangle", "triangle", "x"}
+	if names := ur.Names(); !slices.Equal(names, want) {
+		t.Errorf("Names() = %v, want %v", names, want)
+	}
+	for _, tmpl := range BuiltinUnistrokes() {
+		for _, scale := range []float64{0.8, 1.7} {
+			for _, angle := range []float64{-0.2, 0.0, 0.2} {
+				res, err := ur.Recognize(drawnUnistroke(tmpl, scale, angle))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Name != tmpl.Name {
+					t.Errorf("%s (scale %g, angle %g): recognized %q "+
+						"(score %.3f)", tmpl.Name, scale, angle, res.Name,
+						res.Score)
+				}
+			}
+		}
+	}
+}
+
+func TestUnistrokeScore(t *testing.T) {
+	ur := NewUnistrokeRecognizer()
+	tmpl := BuiltinUnistrokes()[0]
+	exact, _ := ur.Recognize(tmpl.Points)
+	noisy, _ := ur.Recognize(drawnUnistroke(tmpl, 1.0, 0.1))
+	if exact.Score < 0.99 || noisy.Score >= exact.Score {
+		t.Errorf("score exact %.3f, noisy %.3f", exact.Score, noisy.Score)
+	}
+
+	// Eine Spirale passt zu keiner Vorlage.
+	var spiral []TouchPos
+	for i := 0; i <= 100; i++ {
+		a := 6.0 * math.Pi * float64(i) / 100.0
+		r := 5.0 + 0.5*float64(i)
+		spiral = append(spiral, TouchPos{X: 100 + r*math.Cos(a),
+			Y: 100 + r*math.Sin(a)})
+	}
+	ur.MinScore = 0.9
+	res, err := ur.Recognize(spiral)
+	if err != nil || res.Name != "" {
+		t.Errorf("spiral recognized as %q (score %.3f, err %v)", res.Name,
+			res.Score, err)
+	}
+
+	if _, err := ur.Recognize([]TouchPos{{X: 10, Y: 10},
+		{X: 12, Y: 11}}); !errors.Is(err, ErrUnistroke) {
+		t.Errorf("short stroke: got %v, want ErrUnistroke", err)
+	}
+}
+
+func TestUnistrokeFeed(t *testing.T) {
+	ur := NewUnistrokeRecognizer()
+	var tmpl UnistrokeTemplate
+	for _, tmpl = range BuiltinUnistrokes() {
+		if tmpl.Name == "x" {
+			break
+		}
+	}
+	pts := drawnUnistroke(tmpl, 1.2, 0.0)
+	if _, ok := ur.Feed(PenEvent{Type: PenPress, TouchPos: pts[0]}); ok {
+		t.Errorf("result after PenPress")
+	}
+	for _, p := range pts[1:] {
+		ur.Feed(PenEvent{Type: PenDrag, TouchPos: p})
+	}
+	res, ok := ur.Feed(PenEvent{Type: PenRelease})
+	if !ok || res.Name != "x" || len(res.Points) != len(pts) {
+		t.Errorf("got %q (%v, %d points), want x", res.Name, ok,
+			len(res.Points))
+	}
+
+	// Ein Tap liefert kein Ergebnis.
+	ur.Feed(PenEvent{Type: PenPress, TouchPos: TouchPos{X: 5, Y: 5}})
+	if _, ok := ur.Feed(PenEvent{Type: PenRelease}); ok {
+		t.Errorf("result for a tap")
+	}
+}
+
+func TestTouchUnistrokes(t *testing.T) {
+	tch := newTestTouch(eventQueueSize)
+	defer tch.Close()
+	buttons := make(chan subEvent, eventQueueSize)
+	subscribeTest(tch, "button", image.Rect(0, 0, 20, 20), 0, buttons)
+	results, cancel := tch.Unistrokes(NewUnistrokeRecognizer())
+
+	var tmpl UnistrokeTemplate
+	for _, tmpl = range BuiltinUnistrokes() {
+		if tmpl.Name == "x" {
+			break
+		}
+	}
+	pts := drawnUnistroke(tmpl, 1.2, 0.0)
+	tch.enqueueEvent(PenEvent{Type: PenPress, TouchPos: pts[0]})
+	for _, p := range pts[1:] {
+		// Die Drags sollen nicht zusammengefasst werden (siehe QueueStats).
+		for len(tch.router.queue) > eventQueueSize/2 {
+			time.Sleep(time.Millisecond)
+		}
+		tch.enqueueEvent(PenEvent{Type: PenDrag, TouchPos: p})
+	}
+	tch.enqueueEvent(PenEvent{Type: PenRelease, TouchPos: pts[len(pts)-1]})
+	select {
+	case res := <-results:
+		if res.Name != "x" {
+			t.Errorf("got %q, want x", res.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no result")
+	}
+
+	// Die Erkennung verbraucht keine Events: der Strich landet auch in
+	// EventQ (die Drags eventuell verdichtet).
+	got := queueContent(tch)
+	if len(got) < 2 || got[0] != PenPress || got[len(got)-1] != PenRelease {
+		t.Errorf("got %v in EventQ, want the whole stroke", got)
+	}
+
+	// Die Schaltflaeche erhaelt ihre Events weiterhin.
+	tch.enqueueEvent(penEventAt(PenPress, 10, 10, 0, 0))
+	expectSubEvent(t, buttons, "button", PenPress)
+	if len(tch.EventQ) != 0 {
+		t.Errorf("%d events in EventQ", len(tch.EventQ))
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-results; ok {
+		t.Errorf("result channel not closed")
+	}
+	tch.enqueueEvent(penEventAt(PenPress, 100, 100, 0, 0))
+	if ev := <-tch.EventQ; ev.Type != PenPress {
+		t.Errorf("after cancel: got %v in EventQ, want PenPress", ev.Type)
+	}
+}
+
+func TestUnistrokeTemplates(t *testing.T) {
+	tempConfDir(t)
+
+	zigzag := []TouchPos{{X: 0, Y: 0}, {X: 30, Y: 60}, {X: 60, Y: 0},
+		{X: 90, Y: 60}, {X: 120, Y: 0}}
+	ur := NewUnistrokeRecognizer()
+	if err := ur.LoadTemplates(); err != nil {
+		t.Fatalf("missing template file: %v", err)
+	}
+	if err := ur.AddTemplate("zigzag", zigzag); err != nil {
+		t.Fatal(err)
+	}
+	if err := ur.AddTemplate("", zigzag); !errors.Is(err,
+		ErrUnistrokeData) {
+		t.Errorf("empty name: got %v", err)
+	}
+	if err := ur.SaveTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(confDir, unistrokeFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewUnistrokeRecognizer()
+	if err := other.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	drawn := drawnUnistroke(UnistrokeTemplate{Points: zigzag}, 1.5, 0.1)
+	if res, _ := other.Recognize(drawn); res.Name != "zigzag" {
+		t.Errorf("user template recognized as %q (score %.3f)", res.Name,
+			res.Score)
+	}
+	if n := other.RemoveTemplates("zigzag"); n != 1 {
+		t.Errorf("RemoveTemplates removed %d templates, want 1", n)
+	}
+	if n := other.RemoveTemplates("circle"); n != 0 {
+		t.Errorf("built-in templates removed")
+	}
+
+	bad := filepath.Join(confDir, "bad.json")
+	os.WriteFile(bad, []byte(`{"Templates":[{"Name":"a","Points":[]}]}`),
+		0644)
+	if err := other.LoadTemplatesFile(bad); !errors.Is(err,
+		ErrUnistrokeData) {
+		t.Errorf("invalid template: got %v, want ErrUnistrokeData", err)
+	}
+}